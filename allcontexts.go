@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// runPrintAllContexts resolves every context of the kube config the same way
+// a single context is resolved (which means --force-token, --replace-ca-cert
+// and the other flags apply to each context) and prints a single kube config
+// with every context, cluster and user embedded. The entries are named after
+// the source context. The contexts that fail to resolve are skipped.
+func runPrintAllContexts(ctx context.Context, proxy string) error {
+	if *kubecontext != "" || *minify {
+		return flagErrorf("--all-contexts can't be used with --context or --minify")
+	}
+	if inCluster() {
+		return flagErrorf("--all-contexts requires --kubeconfig when running in a pod")
+	}
+	if outputFormat != "" && outputFormat != "kubeconfig" && outputFormat != "sops" {
+		return flagErrorf("--all-contexts only supports --output-format=kubeconfig and --output-format=sops")
+	}
+
+	opts, err := inclusterOptions()
+	if err != nil {
+		return err
+	}
+	source, err := incluster.LoadKubeconfig(opts)
+	if err != nil {
+		return fmt.Errorf("loading the kube config: %w", err)
+	}
+
+	var names []string
+	for name := range source.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	merged := clientcmdapi.NewConfig()
+	for _, name := range names {
+		*kubecontext = name
+		resolved, err := resolveKubeconfig(ctx, proxy)
+		if err != nil {
+			logutil.Warnf("skipping the context %s: %s", name, err)
+			continue
+		}
+
+		resolvedCtx := resolved.Contexts[resolved.CurrentContext]
+		merged.Clusters[name] = resolved.Clusters[resolvedCtx.Cluster]
+		merged.AuthInfos[name] = resolved.AuthInfos[resolvedCtx.AuthInfo]
+		merged.Contexts[name] = &clientcmdapi.Context{
+			Cluster:   name,
+			AuthInfo:  name,
+			Namespace: resolvedCtx.Namespace,
+		}
+		if merged.CurrentContext == "" || name == source.CurrentContext {
+			merged.CurrentContext = name
+		}
+	}
+	*kubecontext = ""
+
+	if len(merged.Contexts) == 0 {
+		return fmt.Errorf("none of the %d contexts could be resolved", len(names))
+	}
+	return writeKubeconfigOutput(ctx, merged)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/yaml"
+)
+
+// argoCDClusterConfig is the 'config' field of an Argo CD cluster Secret. See
+// https://argo-cd.readthedocs.io/en/stable/operator-manual/declarative-setup/#clusters.
+type argoCDClusterConfig struct {
+	BearerToken     string                `json:"bearerToken,omitempty"`
+	Username        string                `json:"username,omitempty"`
+	Password        string                `json:"password,omitempty"`
+	TLSClientConfig argoCDTLSClientConfig `json:"tlsClientConfig"`
+	ProxyURL        string                `json:"proxyUrl,omitempty"`
+}
+
+type argoCDTLSClientConfig struct {
+	Insecure   bool   `json:"insecure"`
+	ServerName string `json:"serverName,omitempty"`
+	CAData     []byte `json:"caData,omitempty"`
+	CertData   []byte `json:"certData,omitempty"`
+	KeyData    []byte `json:"keyData,omitempty"`
+}
+
+var notDNSLabel = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// argoCDClusterSecret renders the current context of the kube config as an
+// Argo CD cluster Secret manifest in the namespace 'argocd'. The byte
+// fields such as caData are base64-encoded by encoding/json, which is what
+// Argo CD expects.
+func argoCDClusterSecret(kubeconfig *clientcmdapi.Config) ([]byte, error) {
+	cluster, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if user.Exec != nil || user.AuthProvider != nil {
+		return nil, fmt.Errorf("the credentials use an exec or auth provider plugin, which Argo CD can't run; use a token or a client certificate instead")
+	}
+
+	config, err := json.Marshal(argoCDClusterConfig{
+		BearerToken: user.Token,
+		Username:    user.Username,
+		Password:    user.Password,
+		TLSClientConfig: argoCDTLSClientConfig{
+			Insecure:   cluster.InsecureSkipTLSVerify,
+			ServerName: cluster.TLSServerName,
+			CAData:     cluster.CertificateAuthorityData,
+			CertData:   user.ClientCertificateData,
+			KeyData:    user.ClientKeyData,
+		},
+		ProxyURL: cluster.ProxyURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("serializing the Argo CD cluster config: %w", err)
+	}
+
+	// Argo CD names the cluster Secrets it creates 'cluster-<host>-<hash>';
+	// we skip the hash since there is a single server.
+	host := cluster.Server
+	if u, err := url.Parse(cluster.Server); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	name := "cluster-" + strings.Trim(notDNSLabel.ReplaceAllString(strings.ToLower(host), "-"), "-")
+
+	secret := &v1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "argocd",
+			Labels:    map[string]string{"argocd.argoproj.io/secret-type": "cluster"},
+		},
+		Type: v1.SecretTypeOpaque,
+		StringData: map[string]string{
+			"name":   name,
+			"server": cluster.Server,
+			"config": string(config),
+		},
+	}
+
+	content, err := yaml.Marshal(secret)
+	if err != nil {
+		return nil, fmt.Errorf("serializing the Argo CD cluster Secret: %w", err)
+	}
+	return content, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// outputExtensions maps the --output-format to the extension of the files
+// written to --output-dir.
+var outputExtensions = map[string]string{
+	"":             ".kubeconfig",
+	"kubeconfig":   ".kubeconfig",
+	"argocd":       ".yaml",
+	"terraform":    ".tf",
+	"rest-config":  ".json",
+	"sops":         ".sops.yaml",
+	"tarball":      ".tar",
+	"go-template":  ".txt",
+	"dockerconfig": ".json",
+	"go":           ".go",
+}
+
+// runPrintBatch writes one kube config per service account given with
+// --serviceaccount to the directory given with --output-dir. The tokens are
+// fetched concurrently. The other flags apply to every kube config.
+func runPrintBatch(ctx context.Context, proxy string) error {
+	refs := *serviceaccount
+	if len(refs) == 0 {
+		refs = *sa
+	}
+	if len(refs) == 0 {
+		return flagErrorf("--output-dir requires --serviceaccount")
+	}
+	if *output != "" || *outputSecret != "" {
+		return flagErrorf("--output-dir can't be used with --output or --output-secret")
+	}
+	if dryRunEnabled() {
+		return flagErrorf("--output-dir can't be used with --dry-run")
+	}
+	ext, ok := outputExtensions[outputFormat]
+	if !ok {
+		return flagErrorf("--output-format: expected one of %s, got: %s", strings.Join(outputFormats, ", "), outputFormat)
+	}
+
+	// The base config is resolved without the service accounts. Their tokens
+	// replace its credentials below.
+	*serviceaccount, *sa = nil, nil
+	base, _, err := resolveConfig(ctx, proxy)
+	if err != nil {
+		return err
+	}
+
+	untouched, err := apiConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+	cl, err := kubernetes.NewForConfig(untouched)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	if err := os.MkdirAll(*outputDir, 0700); err != nil {
+		return fmt.Errorf("creating the directory %s: %w", *outputDir, err)
+	}
+
+	errs := make([]error, len(refs))
+	var wg sync.WaitGroup
+	for i, ref := range refs {
+		wg.Add(1)
+		go func(i int, ref string) {
+			defer wg.Done()
+			errs[i] = writeServiceAccountKubeconfig(ctx, cl, base, ref, ext)
+		}(i, ref)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			logutil.Errorf("%s: %s", refs[i], err)
+			failed = append(failed, refs[i])
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("writing the kube config failed for %d out of %d service accounts: %s", len(failed), len(refs), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// writeServiceAccountKubeconfig writes the kube config of the given service
+// account, of the form 'namespace/name', to --output-dir.
+func writeServiceAccountKubeconfig(ctx context.Context, cl kubernetes.Interface, base *rest.Config, ref, ext string) error {
+	splits := strings.Split(ref, "/")
+	if len(splits) != 2 || splits[0] == "" || splits[1] == "" {
+		return flagErrorf("expected value of the form 'namespace/serviceaccount', got: %s", ref)
+	}
+	saNamespace, name := splits[0], splits[1]
+
+	token, _, err := serviceAccountToken(ctx, cl, saNamespace, name)
+	if err != nil {
+		return err
+	}
+
+	c := rest.CopyConfig(base)
+	useToken(c, token)
+
+	// Like with a single --serviceaccount, the namespace of the context is
+	// the service account's namespace unless --namespace is given.
+	ns := saNamespace
+	if *namespace != "" {
+		ns = *namespace
+	}
+	kubeconfig, err := kubeconfigFromConfig(ctx, c, ns)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(*outputDir, saNamespace+"-"+name+ext)
+	content, err := formatKubeconfig(kubeconfig, filename)
+	if err != nil {
+		return err
+	}
+
+	if err := writeKubeconfigFile(content, filename); err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+	logutil.Infof("wrote the kube config of the serviceaccount %s to %s", ref, filename)
+	return nil
+}
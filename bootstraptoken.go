@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	certutil "k8s.io/client-go/util/cert"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// bootstrapTokenOptions are the flags of the bootstrap-token subcommand.
+type bootstrapTokenOptions struct {
+	TTL         time.Duration
+	Usages      []string
+	Groups      []string
+	Description string
+}
+
+// runBootstrapToken creates a bootstrap token, i.e. a Secret of type
+// bootstrap.kubernetes.io/token in kube-system, the same way 'kubeadm token
+// create' does, and prints a kube config that uses it. The resolved
+// credentials are only used to create the Secret. The 'kubeadm join' command
+// is also printed to stderr.
+func runBootstrapToken(ctx context.Context, proxy string, opts bootstrapTokenOptions) error {
+	authentication := false
+	for _, usage := range opts.Usages {
+		switch usage {
+		case "authentication":
+			authentication = true
+		case "signing":
+		default:
+			return flagErrorf("--usages: unknown usage '%s', expected 'signing' or 'authentication'", usage)
+		}
+	}
+	if !authentication {
+		logutil.Warnf("the bootstrap token can't be used to authenticate without --usages authentication, the printed kube config won't work")
+	}
+	for _, group := range opts.Groups {
+		if !strings.HasPrefix(group, "system:bootstrappers:") {
+			return flagErrorf("--groups: the group '%s' must start with 'system:bootstrappers:'", group)
+		}
+	}
+
+	c, ns, err := resolveConfig(ctx, proxy)
+	if err != nil {
+		return err
+	}
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	id, err := randomBootstrapString(6)
+	if err != nil {
+		return err
+	}
+	secret, err := randomBootstrapString(16)
+	if err != nil {
+		return err
+	}
+	token := id + "." + secret
+
+	data := map[string]string{
+		"token-id":     id,
+		"token-secret": secret,
+	}
+	if opts.TTL > 0 {
+		data["expiration"] = time.Now().Add(opts.TTL).UTC().Format(time.RFC3339)
+	}
+	for _, usage := range opts.Usages {
+		data["usage-bootstrap-"+usage] = "true"
+	}
+	if len(opts.Groups) > 0 {
+		data["auth-extra-groups"] = strings.Join(opts.Groups, ",")
+	}
+	if opts.Description != "" {
+		data["description"] = opts.Description
+	}
+	bootstrapSecret := &v1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-token-" + id,
+			Namespace: "kube-system",
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "kubectl-incluster"},
+		},
+		Type:       v1.SecretTypeBootstrapToken,
+		StringData: data,
+	}
+	if !dryRunClient() {
+		_, err = cl.CoreV1().Secrets("kube-system").Create(ctx, bootstrapSecret, metav1.CreateOptions{DryRun: dryRunOptions()})
+		if err != nil {
+			return fmt.Errorf("creating secret bootstrap-token-%s in namespace kube-system: %w", id, err)
+		}
+	}
+	// The token is generated here, which means the kube config works once
+	// the Secret is applied.
+	if dryRunEnabled() {
+		recordDryRun(bootstrapSecret, "secret kube-system/bootstrap-token-"+id)
+	} else {
+		logutil.Debugf("created the bootstrap token %s, it expires on %s", id, data["expiration"])
+	}
+
+	kubeconfig, err := kubeconfigFromConfig(ctx, c, ns)
+	if err != nil {
+		return err
+	}
+	for name := range kubeconfig.AuthInfos {
+		kubeconfig.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: token}
+	}
+	for _, kctx := range kubeconfig.Contexts {
+		kctx.Namespace = ""
+	}
+
+	if hash, err := caCertHash(c); err == nil {
+		logutil.Infof("to join a node, run: kubeadm join %s --token %s --discovery-token-ca-cert-hash %s", strings.TrimPrefix(c.Host, "https://"), token, hash)
+	} else {
+		logutil.Debugf("not printing the 'kubeadm join' command: %s", err)
+	}
+
+	return writeKubeconfigOutput(ctx, kubeconfig)
+}
+
+// randomBootstrapString returns a random string of the given length made of
+// the characters allowed in bootstrap tokens, i.e. [a-z0-9].
+func randomBootstrapString(length int) (string, error) {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", fmt.Errorf("generating the bootstrap token: %w", err)
+		}
+		b[i] = chars[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// caCertHash returns the hash of the CA's public key in the format expected
+// by 'kubeadm join --discovery-token-ca-cert-hash'. When there are several
+// CAs, the first one is used.
+func caCertHash(c *rest.Config) (string, error) {
+	ca := c.TLSClientConfig.CAData
+	if len(ca) == 0 && c.TLSClientConfig.CAFile != "" {
+		var err error
+		ca, err = ioutil.ReadFile(c.TLSClientConfig.CAFile)
+		if err != nil {
+			return "", fmt.Errorf("reading CA file: %w", err)
+		}
+	}
+	if len(ca) == 0 {
+		return "", fmt.Errorf("the kube config has no CA")
+	}
+	certs, err := certutil.ParseCertsPEM(ca)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(certs[0].RawSubjectPublicKeyInfo)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// canIList prints what the credentials of the given kube config are allowed
+// to do in the given namespaces, similarly to 'kubectl auth can-i --list'. It
+// uses SelfSubjectRulesReview, which means the result may be incomplete when
+// the cluster uses an authorizer other than RBAC (e.g., a webhook).
+func canIList(ctx context.Context, kubeconfig *clientcmdapi.Config, namespaces []string, out io.Writer) error {
+	c, err := restConfigFromKubeconfig(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("loading the generated kube config: %w", err)
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tRESOURCES\tNON-RESOURCE URLS\tRESOURCE NAMES\tVERBS")
+	for _, ns := range namespaces {
+		review, err := cl.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, &authorizationv1.SelfSubjectRulesReview{
+			Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: ns},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("creating a SelfSubjectRulesReview for namespace %s: %w", ns, err)
+		}
+
+		for _, rule := range review.Status.ResourceRules {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ns, strings.Join(qualifiedResources(rule), ", "), "", list(rule.ResourceNames), list(rule.Verbs))
+		}
+		for _, rule := range review.Status.NonResourceRules {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ns, "", list(rule.NonResourceURLs), "", list(rule.Verbs))
+		}
+		if review.Status.Incomplete {
+			fmt.Fprintf(w, "%s\t(incomplete: %s)\t\t\t\n", ns, review.Status.EvaluationError)
+		}
+	}
+
+	return w.Flush()
+}
+
+// qualifiedResources returns the resources of the rule in the form
+// 'resource.group', e.g., 'certificates.cert-manager.io'.
+func qualifiedResources(rule authorizationv1.ResourceRule) []string {
+	groups := rule.APIGroups
+	if len(groups) == 0 {
+		groups = []string{""}
+	}
+
+	var resources []string
+	for _, resource := range rule.Resources {
+		for _, group := range groups {
+			if group == "" {
+				resources = append(resources, resource)
+				continue
+			}
+			resources = append(resources, resource+"."+group)
+		}
+	}
+	return resources
+}
+
+func list(items []string) string {
+	return "[" + strings.Join(items, " ") + "]"
+}
+
+// listNamespaces lists the namespaces of the cluster using the credentials of
+// the given kube config.
+func listNamespaces(ctx context.Context, kubeconfig *clientcmdapi.Config) ([]string, error) {
+	c, err := restConfigFromKubeconfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading the generated kube config: %w", err)
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	nsList, err := cl.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces: %w", err)
+	}
+
+	var namespaces []string
+	for _, ns := range nsList.Items {
+		namespaces = append(namespaces, ns.Name)
+	}
+	return namespaces, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// certInfo is what --json prints for each certificate.
+type certInfo struct {
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SANs              []string  `json:"sans,omitempty"`
+	NotBefore         time.Time `json:"notBefore"`
+	NotAfter          time.Time `json:"notAfter"`
+	IsCA              bool      `json:"isCA"`
+	SHA256Fingerprint string    `json:"sha256Fingerprint"`
+}
+
+// parseCertsPEM decodes the certificates found in the given PEM bundle. The
+// blocks that aren't certificates, such as private keys, are skipped.
+func parseCertsPEM(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM-encoded certificate found")
+	}
+	return certs, nil
+}
+
+func newCertInfo(cert *x509.Certificate) certInfo {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	return certInfo{
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		SANs:              sans,
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+		IsCA:              cert.IsCA,
+		SHA256Fingerprint: fingerprint(cert.Raw),
+	}
+}
+
+// fingerprint returns the SHA-256 fingerprint of the given DER-encoded
+// certificate in the same format as openssl, e.g. 'AB:CD:...'.
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// printCertsText prints the certificates found in the given PEM bundle in a
+// human-readable way, similarly to 'openssl x509 -text'.
+func printCertsText(out io.Writer, data []byte) error {
+	certs, err := parseCertsPEM(data)
+	if err != nil {
+		return err
+	}
+
+	for i, cert := range certs {
+		info := newCertInfo(cert)
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "Certificate %d:\n", i)
+		fmt.Fprintf(out, "    Subject: %s\n", info.Subject)
+		fmt.Fprintf(out, "    Issuer: %s\n", info.Issuer)
+		fmt.Fprintf(out, "    Validity\n")
+		fmt.Fprintf(out, "        Not Before: %s\n", info.NotBefore.Format(time.RFC3339))
+		fmt.Fprintf(out, "        Not After : %s\n", info.NotAfter.Format(time.RFC3339))
+		if len(info.SANs) > 0 {
+			fmt.Fprintf(out, "    Subject Alternative Names: %s\n", strings.Join(info.SANs, ", "))
+		}
+		fmt.Fprintf(out, "    CA: %t\n", info.IsCA)
+		fmt.Fprintf(out, "    SHA-256 Fingerprint: %s\n", info.SHA256Fingerprint)
+	}
+
+	return nil
+}
+
+// printCertsJSON prints the certificates found in the given PEM bundle as a
+// JSON array.
+func printCertsJSON(out io.Writer, data []byte) error {
+	certs, err := parseCertsPEM(data)
+	if err != nil {
+		return err
+	}
+
+	infos := make([]certInfo, 0, len(certs))
+	for _, cert := range certs {
+		infos = append(infos, newCertInfo(cert))
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(infos)
+}
+
+// warnExpiry prints a warning for each certificate embedded in the given kube
+// config that has expired or that expires within the given window. Expired
+// client certificates are a frequent source of confusing "Unauthorized"
+// errors.
+func warnExpiry(kubeconfig *clientcmdapi.Config, window time.Duration) {
+	check := func(what string, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		certs, err := parseCertsPEM(data)
+		if err != nil {
+			logutil.Debugf("not checking the expiry of the %s: %s", what, err)
+			return
+		}
+		for _, cert := range certs {
+			switch {
+			case time.Now().After(cert.NotAfter):
+				logutil.Warnf("the %s '%s' has expired on %s", what, cert.Subject, cert.NotAfter.Format(time.RFC3339))
+			case time.Now().Add(window).After(cert.NotAfter):
+				logutil.Warnf("the %s '%s' expires soon, on %s", what, cert.Subject, cert.NotAfter.Format(time.RFC3339))
+			}
+		}
+	}
+
+	for _, cluster := range kubeconfig.Clusters {
+		check("CA certificate", cluster.CertificateAuthorityData)
+	}
+	for _, user := range kubeconfig.AuthInfos {
+		check("client certificate", user.ClientCertificateData)
+	}
+}
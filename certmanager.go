@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+var certificateGVR = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+
+// clientCertFromCertManager creates (or reuses) a cert-manager Certificate
+// for a client identity, waits for it to be issued, and returns the tls.crt
+// and tls.key of the resulting Secret. It is used with
+// --client-cert-from-cert-manager.
+//
+// The value is a comma-separated list of key=value pairs:
+//
+//	issuer=[namespace/]name   the Issuer, the Certificate is created in its namespace
+//	clusterissuer=name        the ClusterIssuer, the Certificate is created in the current namespace
+//	user=name                 the common name of the client certificate
+//
+// The groups are set as the organizations of the certificate's subject.
+func clientCertFromCertManager(ctx context.Context, c *rest.Config, value, defaultNamespace string, groups []string, timeout time.Duration) (cert, key []byte, _ error) {
+	params, err := parseKeyValues(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var namespace, issuerName, issuerKind string
+	switch {
+	case params["issuer"] != "" && params["clusterissuer"] != "":
+		return nil, nil, fmt.Errorf("issuer and clusterissuer can't be used together")
+	case params["issuer"] != "":
+		namespace, issuerName, issuerKind = defaultNamespace, params["issuer"], "Issuer"
+		if splits := strings.Split(issuerName, "/"); len(splits) == 2 {
+			namespace, issuerName = splits[0], splits[1]
+		}
+		if namespace == "" {
+			namespace = "default"
+		}
+	case params["clusterissuer"] != "":
+		namespace, issuerName, issuerKind = defaultNamespace, params["clusterissuer"], "ClusterIssuer"
+		if namespace == "" {
+			namespace = "default"
+		}
+	default:
+		return nil, nil, flagErrorf("expected either issuer=[namespace/]name or clusterissuer=name, got: %s", value)
+	}
+
+	user := params["user"]
+	if user == "" {
+		return nil, nil, flagErrorf("expected user=name, got: %s", value)
+	}
+	for k := range params {
+		if k != "issuer" && k != "clusterissuer" && k != "user" {
+			return nil, nil, flagErrorf("unknown key '%s', expected one of issuer, clusterissuer or user", k)
+		}
+	}
+
+	dyn, err := dynamic.NewForConfig(c)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	name := "kubectl-incluster-" + user
+	orgs := make([]interface{}, 0, len(groups))
+	for _, g := range groups {
+		orgs = append(orgs, g)
+	}
+	certificate := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+			"labels":    map[string]interface{}{"app.kubernetes.io/managed-by": "kubectl-incluster"},
+		},
+		"spec": map[string]interface{}{
+			"secretName": name,
+			"commonName": user,
+			"subject":    map[string]interface{}{"organizations": orgs},
+			"usages":     []interface{}{"client auth", "digital signature"},
+			"issuerRef": map[string]interface{}{
+				"group": "cert-manager.io",
+				"kind":  issuerKind,
+				"name":  issuerName,
+			},
+		},
+	}}
+
+	// The Secret only exists once cert-manager has issued the certificate.
+	if dryRunEnabled() {
+		if !dryRunClient() {
+			_, err = dyn.Resource(certificateGVR).Namespace(namespace).Create(ctx, certificate, metav1.CreateOptions{DryRun: dryRunOptions()})
+			if err != nil && !k8serrors.IsAlreadyExists(err) {
+				return nil, nil, fmt.Errorf("creating certificate %s in namespace %s: %w", name, namespace, err)
+			}
+		}
+		recordDryRun(certificate.Object, "certificate "+namespace+"/"+name)
+		logutil.Warnf("--dry-run=%s: no kube config is printed since the certificate is only issued once the certificate is created", *dryRun)
+		return nil, nil, errDryRunStop
+	}
+
+	_, err = dyn.Resource(certificateGVR).Namespace(namespace).Create(ctx, certificate, metav1.CreateOptions{})
+	switch {
+	case k8serrors.IsAlreadyExists(err):
+		logutil.Debugf("reusing the existing certificate %s/%s", namespace, name)
+	case err != nil:
+		return nil, nil, fmt.Errorf("creating certificate %s in namespace %s: %w", name, namespace, err)
+	default:
+		logutil.Infof("created the certificate %s/%s for user %s, waiting for it to be issued", namespace, name, user)
+	}
+
+	err = wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		got, err := dyn.Resource(certificateGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("getting certificate %s in namespace %s: %w", name, namespace, err)
+		}
+		conds, _, _ := unstructured.NestedSlice(got.Object, "status", "conditions")
+		for _, cond := range conds {
+			cond, ok := cond.(map[string]interface{})
+			if ok && cond["type"] == "Ready" && cond["status"] == "True" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, nil, fmt.Errorf("the certificate %s in namespace %s was not ready after %s, run 'kubectl describe certificate -n %s %s' to know why", name, namespace, timeout, namespace, name)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+	return getTLSFromSecret(ctx, cl, namespace, name)
+}
+
+// getTLSFromSecret returns the tls.crt and tls.key of the given Secret.
+func getTLSFromSecret(ctx context.Context, cl kubernetes.Interface, namespace, name string) (cert, key []byte, _ error) {
+	secret, err := cl.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting secret %s in namespace %s: %w", name, namespace, err)
+	}
+
+	cert, key = secret.Data["tls.crt"], secret.Data["tls.key"]
+	if len(cert) == 0 || len(key) == 0 {
+		return nil, nil, fmt.Errorf("the secret %s in namespace %s has no 'tls.crt' or no 'tls.key'", name, namespace)
+	}
+
+	return cert, key, nil
+}
+
+// parseKeyValues parses a comma-separated list of key=value pairs such as
+// 'issuer=ns/name,user=alice'.
+func parseKeyValues(value string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, kv := range strings.Split(value, ",") {
+		splits := strings.SplitN(kv, "=", 2)
+		if len(splits) != 2 || splits[0] == "" {
+			return nil, flagErrorf("expected a comma-separated list of key=value, got: %s", value)
+		}
+		params[splits[0]] = splits[1]
+	}
+	return params, nil
+}
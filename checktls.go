@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// checkTLS dials the API server of the given kube config and prints the
+// negotiated TLS version, the certificate chain presented by the server, and
+// whether the chain validates against the CA embedded in the kube config.
+// When HTTPS_PROXY is set, the connection goes through the proxy, which is
+// useful to see what mitmproxy or a corporate TLS interception box presents.
+func checkTLS(ctx context.Context, kubeconfig *clientcmdapi.Config, out io.Writer) error {
+	cluster, ok := kubeconfig.Clusters[kubeconfig.Contexts[kubeconfig.CurrentContext].Cluster]
+	if !ok {
+		return fmt.Errorf("no cluster found in the kube config")
+	}
+
+	serverURL, err := url.Parse(cluster.Server)
+	if err != nil {
+		return fmt.Errorf("parsing the server URL %q: %w", cluster.Server, err)
+	}
+	addr := serverURL.Host
+	if serverURL.Port() == "" {
+		addr = net.JoinHostPort(serverURL.Hostname(), "443")
+	}
+	serverName := serverURL.Hostname()
+	if cluster.TLSServerName != "" {
+		serverName = cluster.TLSServerName
+	}
+
+	conn, err := dialThroughProxy(ctx, serverURL, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// We don't verify the chain during the handshake since we want to show
+	// the chain even when it doesn't validate.
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake with %s: %w", addr, err)
+	}
+	state := tlsConn.ConnectionState()
+
+	fmt.Fprintf(out, "server:       %s\n", cluster.Server)
+	fmt.Fprintf(out, "server name:  %s\n", serverName)
+	fmt.Fprintf(out, "TLS version:  %s\n", tlsVersionName(state.Version))
+	fmt.Fprintf(out, "cipher suite: %s\n", tls.CipherSuiteName(state.CipherSuite))
+	fmt.Fprintf(out, "certificate chain:\n")
+	for i, cert := range state.PeerCertificates {
+		printCertShort(out, i, cert)
+	}
+
+	if len(cluster.CertificateAuthorityData) == 0 {
+		fmt.Fprintf(out, "verification: skipped, no CA in the kube config\n")
+		return nil
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(cluster.CertificateAuthorityData) {
+		return fmt.Errorf("the CA in the kube config isn't a valid PEM-encoded certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		fmt.Fprintf(out, "verification: failed against the CA that will be embedded: %s\n", err)
+		return nil
+	}
+	fmt.Fprintf(out, "verification: ok against the CA that will be embedded\n")
+
+	return nil
+}
+
+// dialThroughProxy opens a TCP connection to addr, going through the HTTP
+// proxy given in HTTPS_PROXY if there is one using the CONNECT method.
+func dialThroughProxy(ctx context.Context, serverURL *url.URL, addr string) (net.Conn, error) {
+	proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: serverURL})
+	if err != nil {
+		return nil, fmt.Errorf("reading the proxy settings: %w", err)
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if proxyURL == nil {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %w", addr, err)
+		}
+		return conn, nil
+	}
+
+	logutil.Debugf("dialing %s through the proxy %s", addr, proxyURL.Host)
+	conn, err := dialer.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("dialing the proxy %s: %w", proxyURL.Host, err)
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sending CONNECT to the proxy %s: %w", proxyURL.Host, err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading the CONNECT response from the proxy %s: %w", proxyURL.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("the proxy %s refused CONNECT %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+
+	return conn, nil
+}
+
+func printCertShort(out io.Writer, i int, cert *x509.Certificate) {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	expiry := ""
+	switch {
+	case time.Now().After(cert.NotAfter):
+		expiry = " (expired)"
+	case time.Now().Before(cert.NotBefore):
+		expiry = " (not yet valid)"
+	}
+
+	fmt.Fprintf(out, "  %d: subject:   %s\n", i, cert.Subject)
+	fmt.Fprintf(out, "     issuer:    %s\n", cert.Issuer)
+	if len(sans) > 0 {
+		fmt.Fprintf(out, "     SANs:      %s\n", strings.Join(sans, ", "))
+	}
+	fmt.Fprintf(out, "     not after: %s%s\n", cert.NotAfter.Format(time.RFC3339), expiry)
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04x", version)
+	}
+}
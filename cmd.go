@@ -0,0 +1,921 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// newRootCmd returns the 'kubectl-incluster' command. When no subcommand is
+// given, the kube config is printed, which is what kubectl-incluster has
+// always done.
+func newRootCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kubectl-incluster",
+		Short: "Print a kube config that works from anywhere using the in-cluster or the current kube config credentials",
+		Long: strings.ReplaceAll(
+			`Print a kube config that embeds the credentials of the current pod's
+			service account (when in cluster, or with Telepresence) or the
+			credentials of the current kube config context (when out-of-cluster).
+			The kube config is "minified" and only contains the current cluster.
+
+			Without a subcommand, kubectl-incluster behaves like 'kubectl
+			incluster print'.`, "\t", ""),
+		Example: strings.ReplaceAll(
+			`kubectl incluster >/tmp/kubeconfig
+			kubectl incluster --sa cert-manager/cert-manager
+			HTTPS_PROXY=:9090 kubectl incluster proxy`, "\t", ""),
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := configureLogging(); err != nil {
+				return err
+			}
+			if err := configureOTel(cmd.Context()); err != nil {
+				return err
+			}
+			if err := checkDryRun(); err != nil {
+				return err
+			}
+
+			// Prompting while the shell waits for the completion would hang
+			// it.
+			switch cmd.Name() {
+			case "version", "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+				return nil
+			}
+			if err := resolveRoot(); err != nil {
+				return err
+			}
+			if *interactive {
+				if err := pickInteractively(cmd.Context()); err != nil {
+					return err
+				}
+			}
+			if *viaPortForward != "" {
+				if err := useViaPortForward(cmd.Context(), *viaPortForward); err != nil {
+					return err
+				}
+			}
+			sources := 0
+			for _, f := range []string{*kubeconfigFromSecret, *clusterAPI, *vcluster, *rancherServer, *eks, *gke, *fromNode} {
+				if f != "" {
+					sources++
+				}
+			}
+			switch {
+			case sources > 1:
+				return flagErrorf("only one of --kubeconfig-from-secret, --cluster-api, --vcluster, --rancher-server, --eks, --gke and --from-node can be given")
+			case *rancherServer == "" && (*rancherToken != "" || *rancherCluster != ""):
+				return flagErrorf("--rancher-token and --rancher-cluster can only be used with --rancher-server")
+			case *eks == "" && *gke == "" && *staticToken:
+				return flagErrorf("--static-token can only be used with --eks or --gke")
+			case *kubeconfigFromSecret != "":
+				return useKubeconfigFromSecret(cmd.Context(), "--kubeconfig-from-secret", *kubeconfigFromSecret)
+			case *clusterAPI != "":
+				ref, err := clusterAPISecretRef(*clusterAPI)
+				if err != nil {
+					return err
+				}
+				return useKubeconfigFromSecret(cmd.Context(), "--cluster-api", ref)
+			case *vcluster != "":
+				return useVcluster(cmd.Context(), *vcluster)
+			case *rancherServer != "":
+				return useRancher(cmd.Context(), *rancherServer, *rancherToken, *rancherCluster)
+			case *eks != "":
+				return useEKS(cmd.Context(), *eks)
+			case *gke != "":
+				return useGKE(cmd.Context(), *gke)
+			case *fromNode != "":
+				return useFromNode(cmd.Context(), *fromNode)
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The flags --print-client-cert and --print-ca-cert predate the
+			// subcommands of the same name.
+			switch {
+			case *printClientCert:
+				return runPrintClientCert(cmd.Context())
+			case *printClientKey:
+				return runPrintClientKey(cmd.Context())
+			case *printClientCertOnly:
+				return runPrintClientCertOnly(cmd.Context())
+			case *printClientP12:
+				return runPrintClientP12(cmd.Context())
+			case *printCAJKS:
+				return runPrintCAJKS(cmd.Context())
+			case *printFingerprint:
+				return runPrintFingerprint(cmd.Context())
+			case *printCACert:
+				return runPrintCACert(cmd.Context())
+			case *printOIDC:
+				return runPrintOIDC(cmd.Context())
+			case *printNamespace:
+				return runPrintNamespace(cmd.Context())
+			case *vaultLogin != "":
+				return runVaultLogin(cmd.Context(), *vaultLogin)
+			default:
+				return runPrint(cmd.Context(), os.Getenv("HTTPS_PROXY"))
+			}
+		},
+	}
+	cmd.PersistentFlags().AddFlagSet(flags)
+	addOutputFormatFlag(cmd)
+	registerFlagCompletions(cmd)
+
+	cmd.AddCommand(
+		newPrintCmd(),
+		newPrintCACertCmd(),
+		newPrintClientCertCmd(),
+		newServiceAccountCmd(),
+		newProxyCmd(),
+		newVerifyCmd(),
+		newWhoamiCmd(),
+		newCanICmd(),
+		newCheckTLSCmd(),
+		newBootstrapTokenCmd(),
+		newStoreCmd(),
+		newLoadCmd(),
+		newDiffCmd(),
+		newLintCmd(),
+		newEnvCmd(),
+		newCleanupCmd(),
+		newRunCmd(),
+		newMitmCmd(),
+		newVersionCmd(),
+		newCompletionCmd(),
+	)
+	markFlagErrors(cmd)
+
+	return cmd
+}
+
+// outputFormat is set with the -o flag of the commands that print a kube
+// config. It isn't part of the persistent flags since the version subcommand
+// has its own -o flag.
+var outputFormat string
+
+// outputTemplate is the Go template given with --template, used with
+// -o go-template.
+var outputTemplate string
+
+// outputRegistries are the registries given with --registry, used with
+// -o dockerconfig.
+var outputRegistries []string
+
+var outputFormats = []string{"kubeconfig", "argocd", "terraform", "rest-config", "sops", "tarball", "go-template", "dockerconfig", "go"}
+
+func addOutputFormatFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&outputFormat, "output-format", "o", "kubeconfig", "The format of the output: 'kubeconfig', 'argocd' to print an Argo CD cluster Secret manifest, 'terraform' to print the kubernetes and helm Terraform provider blocks, 'rest-config' to print the host, credentials, TLS data and proxy as JSON, 'sops' to print the kube config encrypted with the sops CLI using the recipients configured in .sops.yaml, 'tarball' to print a tar archive with the kube config, the CA and credential files it refers to with relative paths, and a load.sh script, 'go-template' to print the template given with --template, 'dockerconfig' to print a Docker config.json that uses the token for the registries given with --registry, or 'go' to print a Go program that builds the equivalent client-go rest.Config.")
+	cmd.Flags().StringSliceVar(&outputRegistries, "registry", nil, "The registry host (e.g., 'registry.example.com:5000') that the token is used for with -o dockerconfig. Can be repeated.")
+	cmd.Flags().StringVar(&outputTemplate, "template", "", "The Go template used with -o go-template, e.g. '{{ .Server }} {{ .Token }}'. The fields are Server, TLSServerName, InsecureSkipTLSVerify, ProxyURL, Namespace, Token, Username, Password, CAData, ClientCertificateData, ClientKeyData (PEM) and Expiry (the expiry of the token or client certificate, if known). On top of the built-in functions, 'b64enc', 'json' and 'rfc3339' are available.")
+	_ = cmd.RegisterFlagCompletionFunc("output-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return outputFormats, cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+func newPrintCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "print",
+		Short: "Print the kube config (default)",
+		Long: strings.ReplaceAll(
+			`Print the kube config. This is what kubectl-incluster does when no
+			subcommand is given. Use --output to write it to a file instead.`, "\t", ""),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPrint(cmd.Context(), os.Getenv("HTTPS_PROXY"))
+		},
+	}
+	addOutputFormatFlag(cmd)
+
+	return cmd
+}
+
+func newPrintCACertCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "print-ca-cert",
+		Short: "Print the kube config's certificate-authority-data",
+		Long: strings.ReplaceAll(
+			`Instead of printing a kube config, print the content of the kube
+			config's certificate-authority-data. Use --text or --json to decode
+			the certificates.`, "\t", ""),
+		Example: `kubectl incluster print-ca-cert --text`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPrintCACert(cmd.Context())
+		},
+	}
+}
+
+func newPrintClientCertCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "print-client-cert",
+		Short: "Print the kube config's client-key-data and client-certificate-data",
+		Long: strings.ReplaceAll(
+			`Instead of printing a kube config, print the content of the kube
+			config's client-key-data followed by the client-certificate-data.
+			Use --order=cert-first to print the certificate first. Useful with
+			mitmproxy's '--set client_certs'. Use --text or --json to decode the
+			certificates.`, "\t", ""),
+		Example: `kubectl incluster print-client-cert >/tmp/client.pem`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPrintClientCert(cmd.Context())
+		},
+	}
+}
+
+func newServiceAccountCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "serviceaccount NAMESPACE/NAME...",
+		Aliases: []string{"sa"},
+		Short:   "Print a kube config that uses the token of the given service account",
+		Long: strings.ReplaceAll(
+			`Print a kube config that uses the token of the given service account
+			instead of the current credentials. Same as 'kubectl incluster
+			--serviceaccount NAMESPACE/NAME'. With several service accounts,
+			--output-dir is required.`, "\t", ""),
+		Example: strings.ReplaceAll(
+			`kubectl incluster serviceaccount cert-manager/cert-manager
+			kubectl incluster serviceaccount team-a/ci team-b/ci --output-dir ./kubeconfigs`, "\t", ""),
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeServiceAccounts,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			*serviceaccount = args
+			return runPrint(cmd.Context(), os.Getenv("HTTPS_PROXY"))
+		},
+	}
+	addOutputFormatFlag(cmd)
+
+	return cmd
+}
+
+func newProxyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy [PROXY_URL]",
+		Short: "Print a kube config meant to be used through mitmproxy",
+		Long: strings.ReplaceAll(
+			`Print a kube config meant to be used through an HTTP proxy such as
+			mitmproxy. The proxy URL defaults to HTTPS_PROXY. The CA presented
+			by mitmproxy replaces the cluster's CA, the proxy is checked to
+			support streaming responses, and the proxy URL is written as
+			'proxy-url' in the kube config so that HTTPS_PROXY doesn't need to
+			be set when using it.`, "\t", ""),
+		Example: strings.ReplaceAll(
+			`kubectl incluster proxy http://localhost:9090
+			HTTPS_PROXY=:9090 kubectl incluster proxy`, "\t", ""),
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			proxy := os.Getenv("HTTPS_PROXY")
+			if len(args) == 1 {
+				proxy = args[0]
+			}
+			if proxy == "" {
+				return fmt.Errorf("no proxy given, please give the proxy URL as an argument or set HTTPS_PROXY")
+			}
+			// Go's http.ProxyFromEnvironment accepts ':9090', but the
+			// kube config's proxy-url needs a scheme and a host.
+			if strings.HasPrefix(proxy, ":") {
+				proxy = "http://localhost" + proxy
+			}
+
+			kubeconfig, err := resolveKubeconfig(cmd.Context(), proxy)
+			if err != nil {
+				return err
+			}
+			for _, cluster := range kubeconfig.Clusters {
+				cluster.ProxyURL = proxy
+			}
+			warnProxyEnvIgnored(proxy)
+
+			return writeKubeconfigOutput(cmd.Context(), kubeconfig)
+		},
+	}
+	addOutputFormatFlag(cmd)
+
+	return cmd
+}
+
+func newVerifyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Check that the generated kube config works",
+		Long: strings.ReplaceAll(
+			`Load the generated kube config the same way kubectl would, call
+			/version, and create a SelfSubjectReview (Kubernetes 1.26+) to show
+			who you are authenticated as.`, "\t", ""),
+		Example: `kubectl incluster verify --sa kube-system/kubectl-incluster`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeconfig, err := resolveKubeconfig(cmd.Context(), os.Getenv("HTTPS_PROXY"))
+			if err != nil {
+				return err
+			}
+			if err := verify(cmd.Context(), kubeconfig, os.Stdout); err != nil {
+				return fmt.Errorf("verify: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+func newWhoamiCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "whoami",
+		Short: "Print the identity the credentials map to",
+		Long: strings.ReplaceAll(
+			`Print the identity the resolved credentials map to. A
+			SelfSubjectReview is used when available, then a TokenReview, and
+			finally the token (JWT) or the client certificate are decoded
+			without being validated.`, "\t", ""),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeconfig, err := resolveKubeconfig(cmd.Context(), os.Getenv("HTTPS_PROXY"))
+			if err != nil {
+				return err
+			}
+			if err := whoami(cmd.Context(), kubeconfig, os.Stdout); err != nil {
+				return fmt.Errorf("whoami: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+func newCanICmd() *cobra.Command {
+	var listFlag, allNamespaces bool
+	cmd := &cobra.Command{
+		Use:   "can-i --list",
+		Short: "List what the credentials are allowed to do",
+		Long: strings.ReplaceAll(
+			`List the verbs and resources the resolved credentials are allowed to
+			use in the context's namespace, similarly to 'kubectl auth can-i
+			--list'. A SelfSubjectRulesReview is used, which means the result may
+			be incomplete when the cluster uses an authorizer other than RBAC.`, "\t", ""),
+		Example: `kubectl incluster can-i --list --sa cert-manager/cert-manager`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !listFlag {
+				return fmt.Errorf("can-i: only --list is supported, e.g. 'kubectl incluster can-i --list'")
+			}
+
+			kubeconfig, err := resolveKubeconfig(cmd.Context(), os.Getenv("HTTPS_PROXY"))
+			if err != nil {
+				return err
+			}
+
+			ns := kubeconfig.Contexts[kubeconfig.CurrentContext].Namespace
+			namespaces := []string{ns}
+			if ns == "" {
+				namespaces = []string{"default"}
+			}
+			if allNamespaces {
+				namespaces, err = listNamespaces(cmd.Context(), kubeconfig)
+				if err != nil {
+					return fmt.Errorf("can-i: %w", err)
+				}
+			}
+			if err := canIList(cmd.Context(), kubeconfig, namespaces, os.Stdout); err != nil {
+				return fmt.Errorf("can-i: %w", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&listFlag, "list", false, "List the verbs and resources the credentials are allowed to use.")
+	cmd.Flags().BoolVar(&allNamespaces, "all-namespaces", false, "Show the rules for every namespace instead of only the context's namespace.")
+
+	return cmd
+}
+
+func newBootstrapTokenCmd() *cobra.Command {
+	var opts bootstrapTokenOptions
+	cmd := &cobra.Command{
+		Use:   "bootstrap-token",
+		Short: "Create a bootstrap token and print a kube config that uses it",
+		Long: strings.ReplaceAll(
+			`Create a bootstrap token, i.e. a Secret of type
+			bootstrap.kubernetes.io/token in kube-system, the same way 'kubeadm
+			token create' does, and print a bootstrap kube config that uses it,
+			e.g. for the kubelet's --bootstrap-kubeconfig. The resolved
+			credentials are only used to create the Secret. The 'kubeadm join'
+			command is printed to stderr.`, "\t", ""),
+		Example: strings.ReplaceAll(
+			`kubectl incluster bootstrap-token --ttl 1h >/etc/kubernetes/bootstrap-kubelet.conf
+			kubectl incluster bootstrap-token --usages authentication --groups system:bootstrappers:ci`, "\t", ""),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBootstrapToken(cmd.Context(), os.Getenv("HTTPS_PROXY"), opts)
+		},
+	}
+	cmd.Flags().DurationVar(&opts.TTL, "ttl", 24*time.Hour, "How long the bootstrap token is valid. Use 0 for a token that never expires.")
+	cmd.Flags().StringSliceVar(&opts.Usages, "usages", []string{"signing", "authentication"}, "The ways the bootstrap token can be used: 'signing' to sign the cluster-info ConfigMap, 'authentication' to authenticate to the API server.")
+	cmd.Flags().StringSliceVar(&opts.Groups, "groups", []string{"system:bootstrappers:kubeadm:default-node-token"}, "The extra groups the bootstrap token authenticates as, on top of 'system:bootstrappers'. They must start with 'system:bootstrappers:'.")
+	cmd.Flags().StringVar(&opts.Description, "description", "Created by kubectl-incluster", "A human-friendly description of the bootstrap token.")
+	addOutputFormatFlag(cmd)
+
+	return cmd
+}
+
+func newStoreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "store NAME",
+		Short: "Store the credentials in the OS keyring",
+		Long: strings.ReplaceAll(
+			`Store the generated kube config in the OS keyring (the macOS
+			Keychain, or the Secret Service on Linux) under the given name, and
+			print a kube config whose user reads the credentials from the
+			keyring with 'kubectl-incluster load --exec-credential NAME', so that
+			the token or client key never sits in plain text in ~/.kube.`, "\t", ""),
+		Example: `kubectl incluster store prod --sa ci/deployer >~/.kube/prod`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStore(cmd.Context(), args[0])
+		},
+	}
+	addOutputFormatFlag(cmd)
+
+	return cmd
+}
+
+func newLoadCmd() *cobra.Command {
+	var execCredential bool
+	cmd := &cobra.Command{
+		Use:   "load NAME",
+		Short: "Print the kube config stored in the OS keyring",
+		Long: strings.ReplaceAll(
+			`Print the kube config stored in the OS keyring with 'kubectl
+			incluster store NAME'. With --exec-credential, print its credentials
+			as an ExecCredential instead, which is what the kube config printed
+			by 'store' uses as an exec plugin.`, "\t", ""),
+		Example: `kubectl incluster load prod >/tmp/kubeconfig`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runLoad(cmd.Context(), args[0], execCredential, os.Stdout)
+		},
+	}
+	cmd.Flags().BoolVar(&execCredential, "exec-credential", false, "Print the credentials as an ExecCredential (client.authentication.k8s.io/v1beta1) instead of the kube config.")
+	addOutputFormatFlag(cmd)
+
+	return cmd
+}
+
+func newDiffCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff [FILE_A] FILE_B",
+		Short: "Compare the credentials of two kube configs",
+		Long: strings.ReplaceAll(
+			`Compare the server, the CA fingerprints and expiry, the
+			authentication method, the identity and the expiry of the
+			credentials of the current context of two kube config files, and
+			mark with '~' what changed. When a single file is given, it is
+			compared with the kube config that kubectl-incluster would print.
+			Useful after a rotation of the cluster CA or a reset of the
+			tokens. Nothing is sent to the API servers: the identity is decoded
+			from the token (JWT) or the client certificate.`, "\t", ""),
+		Example: `kubectl incluster diff ~/.kube/prod.old ~/.kube/prod`,
+		Args:    cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var nameA string
+			var a *clientcmdapi.Config
+			var err error
+			if len(args) == 1 {
+				nameA = "current"
+				a, err = resolveKubeconfig(cmd.Context(), os.Getenv("HTTPS_PROXY"))
+				if err != nil {
+					return err
+				}
+			} else {
+				nameA = args[0]
+				a, err = loadKubeconfigFile(nameA)
+				if err != nil {
+					return fmt.Errorf("diff: loading %s: %w", nameA, err)
+				}
+			}
+			nameB := args[len(args)-1]
+			b, err := loadKubeconfigFile(nameB)
+			if err != nil {
+				return fmt.Errorf("diff: loading %s: %w", nameB, err)
+			}
+
+			if err := diffKubeconfigs(os.Stdout, nameA, a, nameB, b); err != nil {
+				return fmt.Errorf("diff: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+func newLintCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lint [FILE]",
+		Short: "Check a kube config for common problems",
+		Long: strings.ReplaceAll(
+			`Check every cluster and user of the given kube config file (or
+			the one given with --kubeconfig, $KUBECONFIG or ~/.kube/config)
+			for files that can't be read, expired certificates and tokens,
+			exec plugins missing from the PATH, insecure-skip-tls-verify, and
+			servers that can't be reached over TCP. The certificates that
+			expire within --expiry-warning are warned about. Fails when an
+			error is found.`, "\t", ""),
+		Example: `kubectl incluster lint ~/.kube/config`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var kubeconfig *clientcmdapi.Config
+			var err error
+			if len(args) == 1 {
+				kubeconfig, err = loadKubeconfigFile(args[0])
+			} else {
+				var opts incluster.Options
+				opts, err = inclusterOptions()
+				if err == nil {
+					kubeconfig, err = incluster.LoadKubeconfig(opts)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("lint: loading the kube config: %w", err)
+			}
+
+			problems := lintKubeconfig(kubeconfig, *expiryWarning)
+			if len(problems) == 0 {
+				logutil.Infof("no problem found")
+				return nil
+			}
+			if err := printLintProblems(os.Stdout, problems); err != nil {
+				return err
+			}
+			for _, p := range problems {
+				if p.Severity == lintError {
+					return fmt.Errorf("lint: the kube config has errors")
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func newEnvCmd() *cobra.Command {
+	var opts envOptions
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Write the kube config to a temporary file and print the command that sets KUBECONFIG",
+		Long: strings.ReplaceAll(
+			`Write the kube config to a temporary file only readable by you, and
+			print the shell command that sets KUBECONFIG to it, meant to be
+			evaluated. The temporary kube configs written more than --ttl ago
+			are removed at the same time; use the cleanup subcommand to remove
+			them right away.`, "\t", ""),
+		Example: strings.ReplaceAll(
+			`eval "$(kubectl incluster env --sa ci/deployer)"
+			kubectl incluster env --shell fish | source`, "\t", ""),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runEnv(cmd.Context(), os.Getenv("HTTPS_PROXY"), opts, os.Stdout)
+		},
+	}
+	cmd.Flags().StringVar(&opts.Shell, "shell", "", "The shell to print the command for: "+strings.Join(envShells, ", ")+". Defaults to the shell in $SHELL, or bash.")
+	cmd.Flags().DurationVar(&opts.TTL, "ttl", 24*time.Hour, "Remove the temporary kube configs written more than this long ago. Use 0 to keep them.")
+	_ = cmd.RegisterFlagCompletionFunc("shell", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return envShells, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	return cmd
+}
+
+func newCleanupCmd() *cobra.Command {
+	var olderThan time.Duration
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Remove the temporary kube configs written by env",
+		Long: strings.ReplaceAll(
+			`Remove the temporary kube configs written by the env subcommand.
+			With --older-than, only the ones written more than that long ago
+			are removed.`, "\t", ""),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cleanupTempKubeconfigs(olderThan); err != nil {
+				return fmt.Errorf("cleanup: %w", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&olderThan, "older-than", 0, "Only remove the temporary kube configs written more than this long ago.")
+
+	return cmd
+}
+
+func newRunCmd() *cobra.Command {
+	var proxy string
+	cmd := &cobra.Command{
+		Use:   "run [flags] -- COMMAND [ARGS...]",
+		Short: "Run a command with KUBECONFIG set to the kube config",
+		Long: strings.ReplaceAll(
+			`Write the kube config to a temporary file only readable by you,
+			run the command with KUBECONFIG set to it, and remove the file once
+			the command exits. kubectl-incluster exits with the exit code of the
+			command. With --proxy, the command also gets HTTPS_PROXY and
+			SSL_CERT_FILE set to the CA presented by mitmproxy.`, "\t", ""),
+		Example: strings.ReplaceAll(
+			`kubectl incluster run --sa ci/deployer -- helm upgrade --install app ./chart
+			kubectl incluster run --proxy http://localhost:9090 -- go run ./cmd/controller`, "\t", ""),
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCommand(cmd.Context(), proxy, args)
+		},
+	}
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().StringVar(&proxy, "proxy", "", "The URL of mitmproxy, e.g. 'http://localhost:9090'. The kube config uses the CA presented by mitmproxy, and the command gets HTTPS_PROXY and SSL_CERT_FILE.")
+
+	return cmd
+}
+
+func newMitmCmd() *cobra.Command {
+	var port int
+	var upstreamProxy string
+	cmd := &cobra.Command{
+		Use:   "mitm [flags] [-- mitmproxy|mitmweb [ARGS...]]",
+		Short: "Start mitmproxy and write a kube config that goes through it",
+		Long: strings.ReplaceAll(
+			`Start mitmproxy (or the given command, e.g. mitmweb) listening on
+			--port, with the cluster's CA to verify the API server and a script
+			that streams the watch responses. Once mitmproxy is ready, write a
+			kube config that goes through it to --output, or to a temporary file
+			otherwise, with the CA presented by mitmproxy and the proxy written
+			as 'proxy-url'. The temporary files are removed when mitmproxy
+			exits. Since client certificates can't go through mitmproxy, the
+			credentials must be a token; use --force-token or --serviceaccount
+			otherwise. When the API server can only be reached through another
+			proxy (e.g., the proxy-url of the kube config), mitmproxy forwards
+			the traffic to it.`, "\t", ""),
+		Example: strings.ReplaceAll(
+			`kubectl incluster mitm
+			kubectl incluster mitm --sa ci/deployer --output /tmp/kubeconfig -- mitmweb --web-port 8082`, "\t", ""),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runMitm(cmd.Context(), port, upstreamProxy, args)
+		},
+	}
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().IntVar(&port, "port", 9090, "The port mitmproxy listens on.")
+	cmd.Flags().StringVar(&upstreamProxy, "upstream-proxy", "", "The proxy mitmproxy forwards the traffic to, e.g. a corporate proxy, using '--mode upstream'. Defaults to the proxy-url of the kube config's cluster.")
+
+	return cmd
+}
+
+func newCheckTLSCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "check-tls",
+		Short: "Show the certificate chain presented by the API server",
+		Long: strings.ReplaceAll(
+			`Dial the API server (through HTTPS_PROXY if set) and print the
+			negotiated TLS version, the certificate chain with its SANs and
+			expiry, and whether the chain validates against the CA that would be
+			embedded in the kube config.`, "\t", ""),
+		Example: `HTTPS_PROXY=:9090 kubectl incluster check-tls`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeconfig, err := resolveKubeconfig(cmd.Context(), os.Getenv("HTTPS_PROXY"))
+			if err != nil {
+				return err
+			}
+			if err := checkTLS(cmd.Context(), kubeconfig, os.Stdout); err != nil {
+				return fmt.Errorf("check-tls: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+// runPrint prints the kube config, or writes it to the file given with
+// --output, or writes one kube config per service account to the directory
+// given with --output-dir. The proxy may be empty.
+func runPrint(ctx context.Context, proxy string) error {
+	if *outputDir != "" {
+		return runPrintBatch(ctx, proxy)
+	}
+	if *allContexts {
+		return runPrintAllContexts(ctx, proxy)
+	}
+	if *watch {
+		return runWatch(ctx, proxy)
+	}
+
+	kubeconfig, err := resolveKubeconfig(ctx, proxy)
+	if err != nil {
+		return err
+	}
+
+	if *exportDir != "" {
+		if *redact {
+			return flagErrorf("--redact can't be used with --export-dir")
+		}
+		if err := exportCredentials(kubeconfig, *exportDir); err != nil {
+			return fmt.Errorf("while processing flag --export-dir: %w", err)
+		}
+		logutil.Infof("wrote the credentials to %s", *exportDir)
+	}
+
+	// With --summary or --export-dir, the kube config is only written when
+	// it doesn't go to stdout.
+	if (!*summary && *exportDir == "") || *output != "" || *outputSecret != "" {
+		if err := writeKubeconfigOutput(ctx, kubeconfig); err != nil {
+			return err
+		}
+	}
+	if *summary {
+		if err := printSummary(os.Stdout, kubeconfig); err != nil {
+			return fmt.Errorf("while processing flag --summary: %w", err)
+		}
+	}
+	waitForPortForward(ctx)
+	return nil
+}
+
+func runPrintClientCert(ctx context.Context) error {
+	if *pemOrder != "key-first" && *pemOrder != "cert-first" {
+		return flagErrorf("--order: expected 'key-first' or 'cert-first', got: %s", *pemOrder)
+	}
+	c, _, err := resolveConfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+
+	var pem []byte
+	if *pemOrder == "cert-first" {
+		var cert, key []byte
+		cert, err = incluster.ClientCertOnlyPEM(c)
+		if err == nil {
+			key, err = incluster.ClientKeyPEM(c)
+		}
+		pem = append(append([]byte(nil), cert...), key...)
+	} else {
+		pem, err = incluster.ClientCertPEM(c)
+	}
+	if err != nil {
+		return fmt.Errorf("building the PEM bundle with the client-certificate-data and client-key-data: %w", err)
+	}
+	return printPEM(os.Stdout, pem)
+}
+
+// runPrintClientKey prints the client key alone, e.g. for nginx's
+// ssl_certificate_key or curl's --key.
+func runPrintClientKey(ctx context.Context) error {
+	if *textFlag || *jsonFlag {
+		return flagErrorf("--text and --json only decode certificates, they can't be used with --print-client-key")
+	}
+	c, _, err := resolveConfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+
+	pem, err := incluster.ClientKeyPEM(c)
+	if err != nil {
+		return fmt.Errorf("while processing flag --print-client-key: %w", err)
+	}
+	return printPEM(os.Stdout, pem)
+}
+
+// runPrintClientCertOnly prints the client certificate without the key,
+// e.g. for nginx's ssl_certificate or curl's --cert with --key.
+func runPrintClientCertOnly(ctx context.Context) error {
+	c, _, err := resolveConfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+
+	pem, err := incluster.ClientCertOnlyPEM(c)
+	if err != nil {
+		return fmt.Errorf("while processing flag --print-client-cert-only: %w", err)
+	}
+	return printPEM(os.Stdout, pem)
+}
+
+// runPrintClientP12 prints the client certificate and key as a PKCS#12
+// bundle for the browsers and Java-based tools that can't use PEM.
+func runPrintClientP12(ctx context.Context) error {
+	password, err := passwordFromEnv("--print-client-p12")
+	if err != nil {
+		return err
+	}
+	c, _, err := resolveConfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+
+	key, err := incluster.ClientKeyPEM(c)
+	if err != nil {
+		return fmt.Errorf("while processing flag --print-client-p12: %w", err)
+	}
+	cert, err := incluster.ClientCertOnlyPEM(c)
+	if err != nil {
+		return fmt.Errorf("while processing flag --print-client-p12: %w", err)
+	}
+	p12, err := clientP12(key, cert, password)
+	if err != nil {
+		return fmt.Errorf("while processing flag --print-client-p12: %w", err)
+	}
+	_, err = os.Stdout.Write(p12)
+	return err
+}
+
+// runPrintCAJKS prints the CA as a Java truststore for the JVM applications
+// that can't be given a PEM CA.
+func runPrintCAJKS(ctx context.Context) error {
+	password, err := passwordFromEnv("--print-ca-jks")
+	if err != nil {
+		return err
+	}
+	c, _, err := resolveConfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+
+	pem, err := incluster.CACertPEM(c)
+	if err != nil {
+		return fmt.Errorf("while processing flag --print-ca-jks: %w", err)
+	}
+	certs, err := parseCertsPEM(pem)
+	if err != nil {
+		return fmt.Errorf("while processing flag --print-ca-jks: parsing the CA: %w", err)
+	}
+	jks, err := caJKS(certs, password)
+	if err != nil {
+		return fmt.Errorf("while processing flag --print-ca-jks: %w", err)
+	}
+	_, err = os.Stdout.Write(jks)
+	return err
+}
+
+func runPrintFingerprint(ctx context.Context) error {
+	c, _, err := resolveConfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+
+	fps, err := fingerprints(c)
+	if err != nil {
+		return fmt.Errorf("while processing flag --fingerprint: %w", err)
+	}
+	return printFingerprints(os.Stdout, fps)
+}
+
+// passwordFromEnv reads the password from the environment variable given
+// with --password-env, which the given flag requires.
+func passwordFromEnv(flag string) (string, error) {
+	if *passwordEnv == "" {
+		return "", flagErrorf("%s requires --password-env", flag)
+	}
+	password, ok := os.LookupEnv(*passwordEnv)
+	if !ok {
+		return "", flagErrorf("--password-env: the environment variable %s isn't set", *passwordEnv)
+	}
+	return password, nil
+}
+
+func runPrintCACert(ctx context.Context) error {
+	c, _, err := resolveConfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+
+	pem, err := incluster.CACertPEM(c)
+	if err != nil {
+		return fmt.Errorf("building the PEM bundle with the ca-certificate-data: %w", err)
+	}
+	return printPEM(os.Stdout, pem)
+}
+
+// runPrintNamespace prints the namespace the same way kubectl would pick it
+// with the generated kube config, so that scripts get the same answer in and
+// out of the cluster.
+func runPrintNamespace(ctx context.Context) error {
+	_, ns, err := resolveConfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+	if ns == "" {
+		ns = "default"
+	}
+	fmt.Println(ns)
+	return nil
+}
+
+func runPrintOIDC(ctx context.Context) error {
+	c, _, err := resolveConfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+
+	if err := writeOIDC(ctx, c, os.Stdout); err != nil {
+		return fmt.Errorf("while processing flag --print-oidc: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+func newCompletionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion bash|zsh|fish|powershell",
+		Short: "Print the shell completion script",
+		Long: strings.ReplaceAll(
+			`Print the completion script for the given shell. The names of the
+			contexts, clusters and users are completed using the kube config,
+			and the namespaces and service accounts are completed by querying
+			the cluster.
+
+			Bash:
+			    source <(kubectl-incluster completion bash)
+
+			Zsh:
+			    kubectl-incluster completion zsh >"${fpath[1]}/_kubectl-incluster"
+
+			Fish:
+			    kubectl-incluster completion fish >~/.config/fish/completions/kubectl-incluster.fish
+
+			PowerShell:
+			    kubectl-incluster completion powershell | Out-String | Invoke-Expression`, "\t", ""),
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletion(os.Stdout)
+			case "zsh":
+				return root.GenZshCompletion(os.Stdout)
+			case "fish":
+				return root.GenFishCompletion(os.Stdout, true)
+			default:
+				return root.GenPowerShellCompletion(os.Stdout)
+			}
+		},
+	}
+}
+
+// registerFlagCompletions registers the dynamic completion of the flags whose
+// values come from the kube config or from the cluster.
+func registerFlagCompletions(cmd *cobra.Command) {
+	_ = cmd.RegisterFlagCompletionFunc("context", completeFromKubeconfig(func(apicfg *clientcmdapi.Config) []string {
+		var names []string
+		for name := range apicfg.Contexts {
+			names = append(names, name)
+		}
+		return names
+	}))
+	_ = cmd.RegisterFlagCompletionFunc("cluster", completeFromKubeconfig(func(apicfg *clientcmdapi.Config) []string {
+		var names []string
+		for name := range apicfg.Clusters {
+			names = append(names, name)
+		}
+		return names
+	}))
+	_ = cmd.RegisterFlagCompletionFunc("user", completeFromKubeconfig(func(apicfg *clientcmdapi.Config) []string {
+		var names []string
+		for name := range apicfg.AuthInfos {
+			names = append(names, name)
+		}
+		return names
+	}))
+	_ = cmd.RegisterFlagCompletionFunc("namespace", completeNamespaces)
+	_ = cmd.RegisterFlagCompletionFunc("serviceaccount", completeServiceAccounts)
+	_ = cmd.RegisterFlagCompletionFunc("sa", completeServiceAccounts)
+}
+
+// completeFromKubeconfig returns a completion function that completes the
+// names returned by the given func.
+func completeFromKubeconfig(names func(*clientcmdapi.Config) []string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		// Reading stdin while completing would hang the shell.
+		if *kubeconfig == "-" {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		apicfg, err := incluster.LoadKubeconfig(incluster.Options{Kubeconfig: *kubeconfig})
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		return withPrefix(names(apicfg), toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func completeNamespaces(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cl, err := completionClient(context.Background())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	namespaces, err := cl.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var names []string
+	for _, ns := range namespaces.Items {
+		names = append(names, ns.Name)
+	}
+	return withPrefix(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeServiceAccounts completes values of the form 'namespace/name'. The
+// namespace is completed first (with a trailing slash), and the service
+// accounts are only listed once the namespace is known. That way, we don't
+// need the permission to list the service accounts of every namespace.
+func completeServiceAccounts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	i := strings.Index(toComplete, "/")
+	if i == -1 {
+		namespaces, directive := completeNamespaces(cmd, args, toComplete)
+		for j := range namespaces {
+			namespaces[j] += "/"
+		}
+		return namespaces, directive | cobra.ShellCompDirectiveNoSpace
+	}
+
+	cl, err := completionClient(context.Background())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	ns := toComplete[:i]
+	sas, err := cl.CoreV1().ServiceAccounts(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var names []string
+	for _, sa := range sas.Items {
+		names = append(names, ns+"/"+sa.Name)
+	}
+	return withPrefix(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completionClient returns a client that uses the same credentials as the
+// ones kubectl-incluster would use. A short timeout is set since the shell
+// waits for the completion.
+func completionClient(ctx context.Context) (kubernetes.Interface, error) {
+	if *kubeconfig == "-" {
+		return nil, fmt.Errorf("can't complete when the kube config is read from stdin")
+	}
+
+	c, err := apiConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+
+	return kubernetes.NewForConfig(c)
+}
+
+// withPrefix returns the sorted names that start with the given prefix.
+func withPrefix(names []string, prefix string) []string {
+	var filtered []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			filtered = append(filtered, name)
+		}
+	}
+	sort.Strings(filtered)
+	return filtered
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"fmt"
+	"strings"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// clientCertFromCSR generates a private key and gets a client certificate
+// for the given user and groups issued by the API server using a
+// CertificateSigningRequest with the 'kubernetes.io/kube-apiserver-client'
+// signer. It is used with --client-cert-from-csr, which is the inverse of
+// --force-token for clusters that prefer x509 authentication.
+//
+// When approve is true, the CSR is approved right away, which requires the
+// permission to update 'certificatesigningrequests/approval'. Otherwise, we
+// wait for someone to approve it until the timeout expires.
+func clientCertFromCSR(ctx context.Context, c *rest.Config, user string, groups []string, approve bool, timeout time.Duration) (cert, key []byte, _ error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating private key: %w", err)
+	}
+	key, err = keyutil.MarshalPrivateKeyToPEM(privKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding private key: %w", err)
+	}
+
+	csrPEM, err := certutil.MakeCSR(privKey, &pkix.Name{CommonName: user, Organization: groups}, nil, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating the certificate request: %w", err)
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	// Users such as 'system:admin' or 'jane@example.com' aren't valid in
+	// object names. The name must also leave room for the 5 random characters
+	// added by the API server to stay under 253 characters.
+	prefix := "kubectl-incluster-"
+	if name := strings.Trim(notDNSLabel.ReplaceAllString(strings.ToLower(user), "-"), "-"); name != "" {
+		if len(name) > 200 {
+			name = strings.TrimRight(name[:200], "-")
+		}
+		prefix += name + "-"
+	}
+
+	csr := &certificatesv1.CertificateSigningRequest{
+		TypeMeta: metav1.TypeMeta{APIVersion: certificatesv1.SchemeGroupVersion.String(), Kind: "CertificateSigningRequest"},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: prefix,
+			Labels:       map[string]string{"app.kubernetes.io/managed-by": "kubectl-incluster"},
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:    csrPEM,
+			SignerName: certificatesv1.KubeAPIServerClientSignerName,
+			Usages:     []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageClientAuth},
+		},
+	}
+
+	// The certificate is only issued once the CSR exists, and the private key
+	// only lives in memory. The CSR is printed for review.
+	if dryRunEnabled() {
+		if !dryRunClient() {
+			_, err = cl.CertificatesV1().CertificateSigningRequests().Create(ctx, csr, metav1.CreateOptions{DryRun: dryRunOptions()})
+			if err != nil {
+				return nil, nil, fmt.Errorf("creating certificatesigningrequest: %w", err)
+			}
+		}
+		recordDryRun(csr, "certificatesigningrequest for user "+user)
+		logutil.Warnf("--dry-run=%s: no kube config is printed since the certificate is only issued once the certificatesigningrequest is created", *dryRun)
+		return nil, nil, errDryRunStop
+	}
+
+	csr, err = cl.CertificatesV1().CertificateSigningRequests().Create(ctx, csr, metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating certificatesigningrequest: %w", err)
+	}
+	logutil.Infof("created the certificatesigningrequest %s for user %s", csr.Name, user)
+
+	if approve {
+		csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+			Type:    certificatesv1.CertificateApproved,
+			Status:  v1.ConditionTrue,
+			Reason:  "KubectlIncluster",
+			Message: "Approved by kubectl-incluster --approve-csr",
+		})
+		csr, err = cl.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("approving certificatesigningrequest %s: %w", csr.Name, err)
+		}
+		logutil.Debugf("approved the certificatesigningrequest %s", csr.Name)
+	} else {
+		logutil.Infof("waiting for the certificatesigningrequest to be approved, run: kubectl certificate approve %s", csr.Name)
+	}
+
+	name := csr.Name
+	err = wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		csr, err = cl.CertificatesV1().CertificateSigningRequests().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("getting certificatesigningrequest %s: %w", name, err)
+		}
+		for _, cond := range csr.Status.Conditions {
+			if cond.Type == certificatesv1.CertificateDenied || cond.Type == certificatesv1.CertificateFailed {
+				return false, fmt.Errorf("the certificatesigningrequest %s was not issued: %s: %s", name, cond.Reason, cond.Message)
+			}
+		}
+		return len(csr.Status.Certificate) > 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, nil, fmt.Errorf("the certificatesigningrequest %s was not issued after %s", name, timeout)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return csr.Status.Certificate, key, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// kubeconfigSummary is what 'diff' compares. Each field is shown as a row.
+type kubeconfigSummary struct {
+	Server        string
+	TLSServerName string
+	CA            string
+	CAExpiry      string
+	Auth          string
+	Identity      string
+	Expiry        string
+	Namespace     string
+}
+
+// loadKubeconfigFile loads the kube config stored in the given file. Use '-'
+// to read it from stdin. Like kubectl does, the relative paths are resolved
+// against the directory of the file.
+func loadKubeconfigFile(filename string) (*clientcmdapi.Config, error) {
+	if filename != "-" {
+		kubeconfig, err := clientcmd.LoadFromFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := clientcmd.ResolveLocalPaths(kubeconfig); err != nil {
+			return nil, err
+		}
+		return kubeconfig, nil
+	}
+	data, err := readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.Load(data)
+}
+
+// diffKubeconfigs prints, for each field of kubeconfigSummary, the values
+// found in the current context of the two kube configs. The fields that
+// differ are marked with '~', which shows at a glance what changed after a CA
+// rotation or a token reset. Nothing is sent to the API servers: the identity
+// and the expiry are decoded from the token (JWT) or the client certificate.
+func diffKubeconfigs(out io.Writer, nameA string, a *clientcmdapi.Config, nameB string, b *clientcmdapi.Config) error {
+	summaryA, err := summarizeKubeconfig(a)
+	if err != nil {
+		return fmt.Errorf("%s: %w", nameA, err)
+	}
+	summaryB, err := summarizeKubeconfig(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", nameB, err)
+	}
+
+	rows := []struct {
+		field string
+		a, b  string
+	}{
+		{"server", summaryA.Server, summaryB.Server},
+		{"tls-server-name", summaryA.TLSServerName, summaryB.TLSServerName},
+		{"ca", summaryA.CA, summaryB.CA},
+		{"ca expiry", summaryA.CAExpiry, summaryB.CAExpiry},
+		{"auth", summaryA.Auth, summaryB.Auth},
+		{"identity", summaryA.Identity, summaryB.Identity},
+		{"expiry", summaryA.Expiry, summaryB.Expiry},
+		{"namespace", summaryA.Namespace, summaryB.Namespace},
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "  FIELD\t%s\t%s\n", nameA, nameB)
+	for _, row := range rows {
+		if row.a == "" && row.b == "" {
+			continue
+		}
+		marker := " "
+		if row.a != row.b {
+			marker = "~"
+		}
+		fmt.Fprintf(w, "%s %s\t%s\t%s\n", marker, row.field, orNone(row.a), orNone(row.b))
+	}
+	return w.Flush()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+// summarizeKubeconfig returns the rows of the summary of the current context
+// of the kube config, as shown by 'diff'.
+func summarizeKubeconfig(kubeconfig *clientcmdapi.Config) (kubeconfigSummary, error) {
+	s, err := summarizeOutput(kubeconfig)
+	if err != nil {
+		return kubeconfigSummary{}, err
+	}
+	_, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return kubeconfigSummary{}, err
+	}
+
+	summary := kubeconfigSummary{
+		Server:        s.Server,
+		TLSServerName: s.TLSServerName,
+		Namespace:     s.Namespace,
+	}
+
+	switch {
+	case s.InsecureSkipTLSVerify:
+		summary.CA = "insecure-skip-tls-verify"
+	case len(s.CA) == 0:
+		summary.CA = "system roots"
+	default:
+		var fingerprints, expiries []string
+		for _, cert := range s.CA {
+			fingerprints = append(fingerprints, cert.SHA256Fingerprint)
+			expiries = append(expiries, cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+		summary.CA, summary.CAExpiry = strings.Join(fingerprints, ", "), strings.Join(expiries, ", ")
+	}
+
+	if s.Identity != nil {
+		summary.Identity = s.Identity.Username
+	}
+	switch s.AuthType {
+	case authTypeToken:
+		summary.Auth = "token"
+		if !s.tokenIsJWT {
+			summary.Identity, summary.Expiry = "unknown (not a JWT)", "unknown (not a JWT)"
+			break
+		}
+		summary.Expiry = "never"
+		if s.TokenExpiry != nil {
+			summary.Expiry = s.TokenExpiry.Format(time.RFC3339)
+		}
+	case authTypeClientCert:
+		summary.Auth = "client certificate"
+		if len(s.Identity.Groups) > 0 {
+			summary.Identity += " (groups: " + strings.Join(s.Identity.Groups, ", ") + ")"
+		}
+		summary.Expiry = s.ClientCertificateExpiry.Format(time.RFC3339)
+	case authTypeExec:
+		summary.Auth = "exec plugin '" + strings.Join(append([]string{user.Exec.Command}, user.Exec.Args...), " ") + "'"
+	case authTypeAuthProvider:
+		summary.Auth = "auth provider '" + user.AuthProvider.Name + "'"
+	case authTypeBasic:
+		summary.Auth = "basic auth"
+	default:
+		summary.Auth = "none"
+	}
+
+	return summary, nil
+}
+
+// dataOrFile returns the data when it isn't empty, or the content of the
+// file otherwise. Both may be empty.
+func dataOrFile(data []byte, filename string) ([]byte, error) {
+	if len(data) > 0 || filename == "" {
+		return data, nil
+	}
+	return ioutil.ReadFile(filename)
+}
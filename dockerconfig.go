@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// dockerRegistryUsername is the username that goes with the token in the
+// 'auth' field. Registries that trust service account tokens ignore it, but
+// the username can't be empty with basic auth. It is the same as OpenShift's
+// internal registry expects.
+const dockerRegistryUsername = "serviceaccount"
+
+type dockerConfigJSON struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+// dockerAuth is an entry of the 'auths' of a config.json. The token is both
+// the password of 'auth', for the clients that only do basic auth, and the
+// 'registrytoken', which the Docker daemon and go-containerregistry send as a
+// bearer token.
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// dockerConfig wraps the token of the kube config's current context as a
+// Docker config.json with an entry for each of the given registries. It is
+// meant for the registries that trust the cluster's service account tokens.
+func dockerConfig(kubeconfig *clientcmdapi.Config, registries []string) ([]byte, error) {
+	if len(registries) == 0 {
+		return nil, flagErrorf("-o dockerconfig requires --registry")
+	}
+	_, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	token, err := userToken(user)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, fmt.Errorf("%w: -o dockerconfig requires a token, use --sa or --force-token to get one", incluster.ErrNoCredentials)
+	}
+
+	config := dockerConfigJSON{Auths: make(map[string]dockerAuth)}
+	for _, registry := range registries {
+		config.Auths[registry] = dockerAuth{
+			Auth:          base64.StdEncoding.EncodeToString([]byte(dockerRegistryUsername + ":" + token)),
+			RegistryToken: token,
+		}
+	}
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("serializing the Docker config: %w", err)
+	}
+	return append(content, '\n'), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// dryRunObjects are the objects that would have been created or updated
+// with --dry-run, in the order they would have been. They are printed along
+// with the kube config as a multi-document YAML so that the whole credential
+// bundle can be reviewed or committed to a GitOps repository.
+var dryRunObjects []interface{}
+
+// errDryRunStop is returned with --dry-run when the kube config can't be
+// generated without actually creating the objects, e.g. when a certificate
+// needs to be issued. Only the objects are printed.
+var errDryRunStop = errors.New("dry run")
+
+// checkDryRun checks the value of --dry-run.
+func checkDryRun() error {
+	switch *dryRun {
+	case "none", "client", "server":
+		return nil
+	default:
+		return flagErrorf("--dry-run: expected 'none', 'client' or 'server', got: %s", *dryRun)
+	}
+}
+
+// dryRunEnabled tells whether the objects should be printed instead of being
+// created or updated.
+func dryRunEnabled() bool {
+	return *dryRun == "client" || *dryRun == "server"
+}
+
+// dryRunClient tells whether the objects should be printed without sending
+// them to the API server.
+func dryRunClient() bool {
+	return *dryRun == "client"
+}
+
+// dryRunOptions is the 'dryRun' option of the requests that create or update
+// objects. With --dry-run=server, the API server runs the validation and the
+// admission webhooks without persisting the objects.
+func dryRunOptions() []string {
+	if *dryRun == "server" {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// recordDryRun records the object that would have been created or updated.
+// The object must have its apiVersion and kind set.
+func recordDryRun(obj interface{}, what string) {
+	logutil.Infof("--dry-run=%s: not creating the %s", *dryRun, what)
+	dryRunObjects = append(dryRunObjects, obj)
+}
+
+// dryRunManifests returns the objects recorded with --dry-run as a
+// multi-document YAML.
+func dryRunManifests() ([]byte, error) {
+	var buf bytes.Buffer
+	for i, obj := range dryRunObjects {
+		content, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("serializing the manifests: %w", err)
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(content)
+	}
+	return buf.Bytes(), nil
+}
+
+// printDryRunManifests prints the objects recorded with --dry-run. It is used
+// when there is no kube config to print along with them.
+func printDryRunManifests(out io.Writer) error {
+	manifests, err := dryRunManifests()
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(manifests)
+	return err
+}
+
+// withDryRunObjects prepends the objects recorded with --dry-run to the kube
+// config, separated by '---'.
+func withDryRunObjects(kubeconfig []byte) ([]byte, error) {
+	manifests, err := dryRunManifests()
+	if err != nil {
+		return nil, err
+	}
+	return append(append(manifests, "---\n"...), kubeconfig...), nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// useEKS builds the kube config of the given EKS cluster, the same way 'aws
+// eks update-kubeconfig' does, and uses it as the source kube config instead
+// of the one given with --kubeconfig. The server and CA are found using 'aws
+// eks describe-cluster'. The user is an exec stanza running 'aws eks
+// get-token', or, with --static-token, the token it returns. The value is of
+// the form 'name[@region]'; when the region is omitted, the aws CLI picks it
+// from $AWS_REGION or from the profile.
+func useEKS(ctx context.Context, value string) error {
+	if *kubeconfig != "" {
+		return flagErrorf("--eks can't be used with --kubeconfig")
+	}
+	if *server != "" {
+		return flagErrorf("--eks can't be used with --server")
+	}
+	name, region := value, ""
+	if i := strings.LastIndex(value, "@"); i != -1 {
+		name, region = value[:i], value[i+1:]
+	}
+	if name == "" || strings.Contains(value, "@") && region == "" {
+		return flagErrorf("--eks: expected value of the form 'name[@region]', got: %s", value)
+	}
+	var regionArgs []string
+	if region != "" {
+		regionArgs = []string{"--region", region}
+	}
+
+	out, err := runCat("aws", append(regionArgs, "eks", "describe-cluster", "--name", name, "--output", "json")...)
+	if err != nil {
+		return fmt.Errorf("while processing flag --eks: %w", err)
+	}
+	var described struct {
+		Cluster struct {
+			Arn                  string `json:"arn"`
+			Endpoint             string `json:"endpoint"`
+			CertificateAuthority struct {
+				Data string `json:"data"`
+			} `json:"certificateAuthority"`
+		} `json:"cluster"`
+	}
+	if err := json.Unmarshal(out, &described); err != nil {
+		return fmt.Errorf("while processing flag --eks: decoding the output of 'aws eks describe-cluster': %w", err)
+	}
+	if described.Cluster.Endpoint == "" {
+		return fmt.Errorf("while processing flag --eks: the cluster %s has no endpoint yet, is it still being created?", name)
+	}
+	ca, err := base64.StdEncoding.DecodeString(described.Cluster.CertificateAuthority.Data)
+	if err != nil {
+		return fmt.Errorf("while processing flag --eks: decoding the CA of the cluster %s: %w", name, err)
+	}
+	logutil.Debugf("the EKS cluster %s is served at %s", described.Cluster.Arn, described.Cluster.Endpoint)
+
+	getToken := append(regionArgs, "eks", "get-token", "--cluster-name", name, "--output", "json")
+	user := &clientcmdapi.AuthInfo{}
+	if *staticToken {
+		user.Token, err = eksToken(getToken)
+		if err != nil {
+			return fmt.Errorf("while processing flag --eks: %w", err)
+		}
+	} else {
+		user.Exec = &clientcmdapi.ExecConfig{
+			APIVersion: "client.authentication.k8s.io/v1beta1",
+			Command:    "aws",
+			Args:       getToken,
+		}
+		if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+			user.Exec.Env = []clientcmdapi.ExecEnvVar{{Name: "AWS_PROFILE", Value: profile}}
+		}
+		// Without the exec stanza, the generated kube config would have no
+		// credentials.
+		*keepExec = true
+	}
+
+	return useGeneratedKubeconfig(described.Cluster.Arn, &clientcmdapi.Cluster{
+		Server:                   described.Cluster.Endpoint,
+		CertificateAuthorityData: ca,
+	}, user)
+}
+
+// eksToken runs 'aws eks get-token' with the given arguments and returns the
+// token, which is valid for 15 minutes.
+func eksToken(args []string) (string, error) {
+	out, err := runCat("aws", args...)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", incluster.ErrNoCredentials, err)
+	}
+	var cred struct {
+		Status struct {
+			Token string `json:"token"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(out, &cred); err != nil {
+		return "", fmt.Errorf("decoding the output of 'aws eks get-token': %w", err)
+	}
+	if cred.Status.Token == "" {
+		return "", fmt.Errorf("%w: 'aws eks get-token' didn't return a token", incluster.ErrNoCredentials)
+	}
+	return cred.Status.Token, nil
+}
+
+// useGeneratedKubeconfig uses a kube config made of the given cluster and
+// user as the source kube config. The context, cluster and user are all given
+// the same name.
+func useGeneratedKubeconfig(name string, cluster *clientcmdapi.Cluster, user *clientcmdapi.AuthInfo) error {
+	apiconf := clientcmdapi.NewConfig()
+	apiconf.Clusters[name] = cluster
+	apiconf.AuthInfos[name] = user
+	apiconf.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	apiconf.CurrentContext = name
+
+	data, err := clientcmd.Write(*apiconf)
+	if err != nil {
+		return fmt.Errorf("encoding the kube config of %s: %w", name, err)
+	}
+	sourceKubeconfig = data
+	*kubecontext, overrides.Context.Cluster, overrides.Context.AuthInfo = "", "", ""
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// envOptions are the flags of the env subcommand.
+type envOptions struct {
+	Shell string
+	TTL   time.Duration
+}
+
+var envShells = []string{"bash", "zsh", "sh", "fish", "powershell"}
+
+// tempKubeconfigDir is where the env subcommand writes the kube configs. It
+// is only readable by you.
+func tempKubeconfigDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kubectl-incluster-%d", os.Getuid()))
+}
+
+// runEnv writes the kube config to a temporary file and prints the shell
+// command that sets KUBECONFIG to it, meant to be evaluated, e.g. with 'eval
+// "$(kubectl incluster env)"'. The temporary kube configs older than the TTL
+// are removed at the same time.
+func runEnv(ctx context.Context, proxy string, opts envOptions, out io.Writer) error {
+	if opts.Shell == "" {
+		opts.Shell = filepath.Base(os.Getenv("SHELL"))
+		if !contains(envShells, opts.Shell) {
+			opts.Shell = "bash"
+		}
+	}
+	if !contains(envShells, opts.Shell) {
+		return flagErrorf("--shell: expected one of %s, got: %s", strings.Join(envShells, ", "), opts.Shell)
+	}
+
+	kubeconfig, err := resolveKubeconfig(ctx, proxy)
+	if err != nil {
+		return err
+	}
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return fmt.Errorf("serializing the kube config: %w", err)
+	}
+
+	if opts.TTL > 0 {
+		if err := cleanupTempKubeconfigs(opts.TTL); err != nil {
+			logutil.Warnf("while removing the stale temporary kube configs: %s", err)
+		}
+	}
+	path, err := writeTempKubeconfig(content)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Shell {
+	case "fish":
+		fmt.Fprintf(out, "set -gx KUBECONFIG '%s';\n", strings.ReplaceAll(strings.ReplaceAll(path, `\`, `\\`), `'`, `\'`))
+	case "powershell":
+		fmt.Fprintf(out, "$env:KUBECONFIG = '%s'\n", strings.ReplaceAll(path, `'`, `''`))
+	default:
+		fmt.Fprintf(out, "export KUBECONFIG='%s'\n", strings.ReplaceAll(path, `'`, `'\''`))
+	}
+	return nil
+}
+
+// writeTempKubeconfig writes the kube config to a new file in
+// tempKubeconfigDir with the mode 0600, and returns its path.
+func writeTempKubeconfig(content []byte) (string, error) {
+	return writeTempFile("kubeconfig-*.yaml", content)
+}
+
+// writeTempFile writes the content to a new file in tempKubeconfigDir with the
+// mode 0600. The pattern is the same as with ioutil.TempFile.
+func writeTempFile(pattern string, content []byte) (string, error) {
+	dir := tempKubeconfigDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("creating %s: %w", dir, err)
+	}
+	// The directory may have been created by someone else to read our
+	// kube configs. Windows doesn't have Unix permissions.
+	if info, err := os.Stat(dir); err != nil {
+		return "", err
+	} else if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("the directory %s can be accessed by other users (mode %s), remove it", dir, info.Mode().Perm())
+	}
+
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return "", fmt.Errorf("creating a temporary file: %w", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing %s: %w", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing %s: %w", f.Name(), err)
+	}
+
+	logutil.Debugf("%s written", f.Name())
+	return f.Name(), nil
+}
+
+// cleanupTempKubeconfigs removes the kube configs written by the env
+// subcommand that were written more than the given duration ago. All of them
+// are removed when the duration is 0.
+func cleanupTempKubeconfigs(olderThan time.Duration) error {
+	dir := tempKubeconfigDir()
+	paths, err := filepath.Glob(filepath.Join(dir, "kubeconfig-*.yaml"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) < olderThan {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		logutil.Debugf("removed %s", path)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
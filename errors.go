@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/spf13/cobra"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// The exit codes let wrapper scripts branch on the cause of the failure
+// without parsing the logs. They are documented in the README.
+const (
+	exitError         = 1
+	exitNotInCluster  = 2
+	exitNoCredentials = 3
+	exitAPIError      = 4
+	exitInvalidFlags  = 5
+)
+
+// flagError is returned when the flags or arguments are invalid, e.g., when
+// two flags can't be used together.
+type flagError struct {
+	err error
+}
+
+func (e *flagError) Error() string { return e.err.Error() }
+func (e *flagError) Unwrap() error { return e.err }
+
+func flagErrorf(format string, a ...interface{}) error {
+	return &flagError{err: fmt.Errorf(format, a...)}
+}
+
+// markFlagErrors makes the flag parsing and argument validation errors of
+// the command and its subcommands flag errors.
+func markFlagErrors(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &flagError{err: err}
+	})
+
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		if args := cmd.Args; args != nil {
+			cmd.Args = func(cmd *cobra.Command, a []string) error {
+				if err := args(cmd, a); err != nil {
+					return &flagError{err: err}
+				}
+				return nil
+			}
+		}
+		for _, sub := range cmd.Commands() {
+			walk(sub)
+		}
+	}
+	walk(cmd)
+}
+
+// classifyError returns the exit code and the kind of the error. The kind is
+// the 'kind' field of the JSON error printed with --error-format=json.
+func classifyError(err error) (code int, kind string) {
+	var flagErr *flagError
+	var apiErr k8serrors.APIStatus
+	var urlErr *url.Error
+	var cmdErr *commandExitError
+	switch {
+	case errors.As(err, &cmdErr):
+		return cmdErr.code, "CommandFailed"
+	case errors.As(err, &flagErr):
+		return exitInvalidFlags, "InvalidFlags"
+	case errors.Is(err, incluster.ErrNoContext) || errors.Is(err, rest.ErrNotInCluster):
+		return exitNotInCluster, "NotInCluster"
+	case errors.Is(err, incluster.ErrNoCredentials):
+		return exitNoCredentials, "MissingCredentials"
+	case errors.As(err, &apiErr) || errors.As(err, &urlErr):
+		return exitAPIError, "APIError"
+	default:
+		return exitError, "Error"
+	}
+}
+
+// printErrorJSON prints the error as a single JSON object, which is what
+// --error-format=json does. The reason is only set for errors returned by the
+// Kubernetes API, e.g. 'Forbidden' or 'NotFound'.
+func printErrorJSON(out io.Writer, err error) {
+	code, kind := classifyError(err)
+	var reason string
+	var apiErr k8serrors.APIStatus
+	if errors.As(err, &apiErr) {
+		reason = string(apiErr.Status().Reason)
+	}
+
+	line, _ := json.Marshal(struct {
+		Error    string `json:"error"`
+		Kind     string `json:"kind"`
+		Reason   string `json:"reason,omitempty"`
+		ExitCode int    `json:"exitCode"`
+	}{err.Error(), kind, reason, code})
+	fmt.Fprintf(out, "%s\n", line)
+}
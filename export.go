@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// exportFiles are the files written by --export-dir. The ones that don't
+// apply to the credentials (e.g., 'token' with a client certificate) are
+// removed so that a previous export doesn't leave stale credentials behind.
+var exportFiles = []string{"ca.crt", "token", "client.crt", "client.key", "server", "namespace"}
+
+// exportCredentials writes the credential material of the kube config's
+// current context as individual files to the given directory: the inverse
+// of embedding, for the tools that are configured with discrete file paths.
+// Like the service account mount, the files have no trailing newline. The
+// directory is created with the mode 0700 and the files are written
+// atomically with the mode 0600.
+func exportCredentials(kubeconfig *clientcmdapi.Config, dir string) error {
+	cluster, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return err
+	}
+	if user.Exec != nil || user.AuthProvider != nil {
+		return fmt.Errorf("the credentials use an exec or auth provider plugin, use a token or a client certificate instead")
+	}
+	ns, _, err := clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{}).Namespace()
+	if err != nil {
+		return fmt.Errorf("loading the namespace: %w", err)
+	}
+
+	files := map[string][]byte{
+		"server":    []byte(cluster.Server),
+		"namespace": []byte(ns),
+	}
+	add := func(name, what string, data []byte, filename string) error {
+		content, err := dataOrFile(data, filename)
+		if err != nil {
+			return fmt.Errorf("reading the %s: %w", what, err)
+		}
+		if len(content) > 0 {
+			files[name] = content
+		}
+		return nil
+	}
+	if err := add("ca.crt", "CA", cluster.CertificateAuthorityData, cluster.CertificateAuthority); err != nil {
+		return err
+	}
+	token, err := userToken(user)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		files["token"] = []byte(token)
+	}
+	if err := add("client.crt", "client certificate", user.ClientCertificateData, user.ClientCertificate); err != nil {
+		return err
+	}
+	if err := add("client.key", "client key", user.ClientKeyData, user.ClientKey); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating the directory %s: %w", dir, err)
+	}
+	for _, name := range exportFiles {
+		filename := filepath.Join(dir, name)
+		content, ok := files[name]
+		if !ok {
+			if err := os.Remove(filename); err == nil {
+				logutil.Debugf("removed the stale %s", filename)
+			} else if !os.IsNotExist(err) {
+				return fmt.Errorf("removing the stale %s: %w", filename, err)
+			}
+			continue
+		}
+		if err := writeFileAtomic(content, filename); err != nil {
+			return fmt.Errorf("writing: %w", err)
+		}
+		logutil.Debugf("wrote %s", filename)
+	}
+	return nil
+}
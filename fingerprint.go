@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// credentialFingerprints is what --fingerprint prints with --json. Nothing
+// secret is printed: the certificates are public, and only the SHA-256 of
+// the token is printed.
+type credentialFingerprints struct {
+	CA                []certFingerprint `json:"ca,omitempty"`
+	ClientCertificate []certFingerprint `json:"clientCertificate,omitempty"`
+	Token             string            `json:"token,omitempty"`
+}
+
+type certFingerprint struct {
+	Subject           string `json:"subject"`
+	SHA256Fingerprint string `json:"sha256Fingerprint"`
+}
+
+// fingerprints returns the SHA-256 fingerprints of the CA and client
+// certificates and the SHA-256 of the token of the rest config, so that two
+// environments can be checked to hold the same credentials without printing
+// them.
+func fingerprints(c *rest.Config) (credentialFingerprints, error) {
+	var fps credentialFingerprints
+	certs := func(pem []byte) ([]certFingerprint, error) {
+		parsed, err := parseCertsPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		var out []certFingerprint
+		for _, cert := range parsed {
+			out = append(out, certFingerprint{Subject: cert.Subject.String(), SHA256Fingerprint: fingerprint(cert.Raw)})
+		}
+		return out, nil
+	}
+
+	if len(c.CAData) > 0 || c.CAFile != "" {
+		pem, err := incluster.CACertPEM(c)
+		if err != nil {
+			return fps, err
+		}
+		if fps.CA, err = certs(pem); err != nil {
+			return fps, fmt.Errorf("parsing the CA: %w", err)
+		}
+	}
+	if len(c.CertData) > 0 || c.CertFile != "" {
+		pem, err := incluster.ClientCertOnlyPEM(c)
+		if err != nil {
+			return fps, err
+		}
+		if fps.ClientCertificate, err = certs(pem); err != nil {
+			return fps, fmt.Errorf("parsing the client certificate: %w", err)
+		}
+	}
+
+	token, err := userToken(&clientcmdapi.AuthInfo{Token: c.BearerToken, TokenFile: c.BearerTokenFile})
+	if err != nil {
+		return fps, err
+	}
+	if token != "" {
+		sum := sha256.Sum256([]byte(token))
+		fps.Token = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return fps, nil
+}
+
+// printFingerprints prints the fingerprints as a table, or as JSON with
+// --json.
+func printFingerprints(out io.Writer, fps credentialFingerprints) error {
+	if *jsonFlag {
+		content, err := json.MarshalIndent(fps, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", content)
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, fp := range fps.CA {
+		fmt.Fprintf(w, "ca\t%s\t%s\n", fp.Subject, fp.SHA256Fingerprint)
+	}
+	for _, fp := range fps.ClientCertificate {
+		fmt.Fprintf(w, "client-certificate\t%s\t%s\n", fp.Subject, fp.SHA256Fingerprint)
+	}
+	if fps.Token != "" {
+		fmt.Fprintf(w, "token\t\t%s\n", fps.Token)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// forceTokenServiceAccount makes sure that the given service account exists
+// and is bound to the given ClusterRole, and returns its token. It is used
+// with --force-token when the kube config only has a client certificate,
+// since client certificates can't go through a proxy that inspects the HTTP
+// traffic like mitmproxy does.
+//
+// The ref is of the form 'namespace/name'. Both the ServiceAccount and the
+// ClusterRoleBinding are reused on subsequent runs.
+func forceTokenServiceAccount(ctx context.Context, c *rest.Config, ref, clusterRole string) (token, secretRef string, _ error) {
+	splits := strings.Split(ref, "/")
+	if len(splits) != 2 || splits[0] == "" || splits[1] == "" {
+		return "", "", flagErrorf("expected value of the form 'namespace/serviceaccount', got: %s", ref)
+	}
+	namespace, name := splits[0], splits[1]
+
+	sa := &v1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "kubectl-incluster"},
+		},
+	}
+	bindingName := "kubectl-incluster:" + namespace + ":" + name
+	binding := &rbacv1.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   bindingName,
+			Labels: map[string]string{"app.kubernetes.io/managed-by": "kubectl-incluster"},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     clusterRole,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      name,
+			Namespace: namespace,
+		}},
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return "", "", fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	// No token can be requested for a service account that doesn't exist
+	// yet, which is why the token Secret is part of the manifests.
+	if dryRunEnabled() {
+		if !*createSecret {
+			return "", "", flagErrorf("--dry-run requires --create-secret since no token can be requested for a service account that isn't created")
+		}
+		if !dryRunClient() {
+			_, err = cl.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, metav1.CreateOptions{DryRun: dryRunOptions()})
+			if err != nil && !k8serrors.IsAlreadyExists(err) {
+				return "", "", fmt.Errorf("creating serviceaccount %s in namespace %s: %w", name, namespace, err)
+			}
+			_, err = cl.RbacV1().ClusterRoleBindings().Create(ctx, binding, metav1.CreateOptions{DryRun: dryRunOptions()})
+			if err != nil && !k8serrors.IsAlreadyExists(err) {
+				return "", "", fmt.Errorf("creating clusterrolebinding %s: %w", bindingName, err)
+			}
+		}
+		recordDryRun(sa, "serviceaccount "+namespace+"/"+name)
+		recordDryRun(binding, "clusterrolebinding "+bindingName)
+		token, err = dryRunTokenSecret(ctx, cl, namespace, name)
+		return token, "", err
+	}
+
+	_, err = cl.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, metav1.CreateOptions{})
+	switch {
+	case k8serrors.IsAlreadyExists(err):
+		logutil.Debugf("reusing the existing serviceaccount %s/%s", namespace, name)
+	case err != nil:
+		return "", "", fmt.Errorf("creating serviceaccount %s in namespace %s: %w", name, namespace, err)
+	default:
+		logutil.Infof("created the serviceaccount %s/%s", namespace, name)
+	}
+
+	binding, err = cl.RbacV1().ClusterRoleBindings().Create(ctx, binding, metav1.CreateOptions{})
+	switch {
+	case k8serrors.IsAlreadyExists(err):
+		binding, err = cl.RbacV1().ClusterRoleBindings().Get(ctx, bindingName, metav1.GetOptions{})
+		if err != nil {
+			return "", "", fmt.Errorf("getting clusterrolebinding %s: %w", bindingName, err)
+		}
+		// The roleRef of a binding can't be changed.
+		if binding.RoleRef.Name != clusterRole {
+			return "", "", fmt.Errorf("the clusterrolebinding %s already exists and binds the clusterrole %s instead of %s, please delete it first", bindingName, binding.RoleRef.Name, clusterRole)
+		}
+		logutil.Debugf("reusing the existing clusterrolebinding %s", bindingName)
+	case err != nil:
+		return "", "", fmt.Errorf("creating clusterrolebinding %s: %w", bindingName, err)
+	default:
+		logutil.Infof("bound the serviceaccount %s/%s to the clusterrole %s with the clusterrolebinding %s", namespace, name, clusterRole, binding.Name)
+	}
+
+	return serviceAccountToken(ctx, cl, namespace, name)
+}
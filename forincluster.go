@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// forInClusterPaths replaces the token and CA of the kube config with
+// references to the files that Kubernetes mounts in every pod, i.e.
+// /var/run/secrets/kubernetes.io/serviceaccount/token and ca.crt, regardless
+// of where they were read from (e.g., with --root). It is used with
+// --for-in-cluster when the kube config is extracted on a dev machine to be
+// mounted back into a pod.
+func forInClusterPaths(apiconf *clientcmdapi.Config) error {
+	if *noEmbed {
+		return flagErrorf("--for-in-cluster can't be used with --no-embed")
+	}
+
+	for name, user := range apiconf.AuthInfos {
+		if len(user.ClientCertificateData) > 0 || user.ClientCertificate != "" {
+			return fmt.Errorf("--for-in-cluster requires a token, but the user %s uses a client certificate, use --force-token or --serviceaccount", name)
+		}
+		if user.Token == "" && user.TokenFile == "" {
+			return fmt.Errorf("--for-in-cluster requires a token, but the user %s has none", name)
+		}
+		user.Token = ""
+		user.TokenFile = incluster.DefaultTokenMount + "/token"
+	}
+
+	for _, cluster := range apiconf.Clusters {
+		// Client-go refuses kube configs that have both a CA and
+		// insecure-skip-tls-verify.
+		if cluster.InsecureSkipTLSVerify {
+			continue
+		}
+		cluster.CertificateAuthorityData = nil
+		cluster.CertificateAuthority = incluster.DefaultTokenMount + "/ca.crt"
+	}
+
+	return nil
+}
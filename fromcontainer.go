@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// getDockerContainerCredentials reads the service account token, ca.crt and
+// namespace mounted in a local Docker container using 'docker exec'. Useful
+// with kind or docker-compose, where setting CONTAINER_ROOT by hand is
+// cumbersome.
+func getDockerContainerCredentials(container string) (podCredentials, error) {
+	return readCredentials(func(file string) ([]byte, error) {
+		return runCat("docker", "exec", container, "cat", file)
+	})
+}
+
+// getCRIContainerCredentials is the same as getDockerContainerCredentials
+// but for containerd and other CRI runtimes, using 'crictl exec'. It is meant
+// to be run on the node, e.g., inside a kind node with 'docker exec'.
+func getCRIContainerCredentials(container string) (podCredentials, error) {
+	return readCredentials(func(file string) ([]byte, error) {
+		return runCat("crictl", "exec", container, "cat", file)
+	})
+}
+
+func runCat(name string, args ...string) ([]byte, error) {
+	logutil.Debugf("running '%s %s'", name, strings.Join(args, " "))
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running '%s %s': %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// kubeletKubeconfigPaths are the paths tried in order to find the kube config
+// of the kubelet on the node: kubeadm, then k3s and RKE2.
+var kubeletKubeconfigPaths = []string{
+	"/etc/kubernetes/kubelet.conf",
+	"/var/lib/rancher/k3s/agent/kubelet.kubeconfig",
+	"/var/lib/rancher/rke2/agent/kubelet.kubeconfig",
+}
+
+// useFromNode reads the kube config of the kubelet running on the given node
+// and uses it as the source kube config instead of the one given with
+// --kubeconfig, which means the generated kube config authenticates as the
+// node (i.e., 'system:node:<node>'). It is used with --from-node when
+// diagnosing the authentication or authorization of a node.
+//
+// Like 'kubectl debug node/<node>', a privileged pod that mounts the host's
+// root filesystem is created on the node. The kubelet's kube config and the
+// certificate files it refers to are read with 'cat', and the pod is deleted
+// right after.
+func useFromNode(ctx context.Context, node string) error {
+	if *server != "" {
+		return flagErrorf("--from-node can't be used with --server")
+	}
+
+	c, err := apiConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+	namespace := contextNamespace()
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	// The pod is also returned when it was created but never got to run, in
+	// which case it must be deleted too.
+	pod, err := createNodeDebugPod(ctx, cl, namespace, node)
+	if pod != "" {
+		defer func() {
+			// The context may already be cancelled, e.g. with Ctrl-C.
+			ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+			defer cancel()
+			zero := int64(0)
+			err := cl.CoreV1().Pods(namespace).Delete(ctx, pod, metav1.DeleteOptions{GracePeriodSeconds: &zero})
+			if err != nil {
+				logutil.Warnf("the debug pod %s in namespace %s couldn't be deleted, delete it by hand: %s", pod, namespace, err)
+			}
+		}()
+	}
+	if err != nil {
+		return fmt.Errorf("while processing flag --from-node: %w", err)
+	}
+
+	cat := func(file string) ([]byte, error) {
+		return execCat(c, cl, namespace, pod, "", path.Join("/host", file))
+	}
+	apiconf, err := readKubeletKubeconfig(cat)
+	if err != nil {
+		return fmt.Errorf("while processing flag --from-node: %w", err)
+	}
+	kctx := apiconf.Contexts[apiconf.CurrentContext]
+	cluster, user := apiconf.Clusters[kctx.Cluster], apiconf.AuthInfos[kctx.AuthInfo]
+
+	// On k3s and on the control plane nodes, the kubelet talks to the API
+	// server through the loopback interface.
+	if serverURL, err := url.Parse(cluster.Server); err == nil && isLoopback(serverURL.Hostname()) {
+		logutil.Debugf("the kubelet of the node %s uses the server %s, replacing it with %s", node, cluster.Server, c.Host)
+		cluster.Server = c.Host
+		if cluster.TLSServerName == "" {
+			cluster.TLSServerName = serverURL.Hostname()
+		}
+	}
+
+	return useGeneratedKubeconfig("system:node:"+node, cluster, user)
+}
+
+// createNodeDebugPod creates a privileged pod on the given node with the
+// host's root filesystem mounted at /host, waits for it to be running, and
+// returns its name. The name is also returned along with the error when the
+// pod was created but didn't get to run.
+func createNodeDebugPod(ctx context.Context, cl kubernetes.Interface, namespace, node string) (string, error) {
+	privileged := true
+	pod := &v1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubectl-incluster-node-debug-",
+			Namespace:    namespace,
+			Labels:       map[string]string{"app.kubernetes.io/managed-by": "kubectl-incluster"},
+		},
+		Spec: v1.PodSpec{
+			NodeName:      node,
+			RestartPolicy: v1.RestartPolicyNever,
+			HostPID:       true,
+			Tolerations:   []v1.Toleration{{Operator: v1.TolerationOpExists}},
+			Containers: []v1.Container{{
+				Name:            "debugger",
+				Image:           *fromNodeImage,
+				Command:         []string{"sleep", "3600"},
+				SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+				VolumeMounts:    []v1.VolumeMount{{Name: "host-root", MountPath: "/host", ReadOnly: true}},
+			}},
+			Volumes: []v1.Volume{{
+				Name:         "host-root",
+				VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/"}},
+			}},
+		},
+	}
+
+	// The kubelet's kube config can only be read from a running pod.
+	if dryRunEnabled() {
+		if !dryRunClient() {
+			_, err := cl.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{DryRun: dryRunOptions()})
+			if err != nil {
+				return "", fmt.Errorf("creating the debug pod on node %s in namespace %s: %w", node, namespace, err)
+			}
+		}
+		recordDryRun(pod, "debug pod on node "+node)
+		logutil.Warnf("--dry-run=%s: no kube config is printed since the kubelet's kube config can only be read from the debug pod", *dryRun)
+		return "", errDryRunStop
+	}
+
+	pod, err := cl.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("creating the debug pod on node %s in namespace %s: %w", node, namespace, err)
+	}
+	name := pod.Name
+	logutil.Debugf("created the debug pod %s in namespace %s on node %s", name, namespace, node)
+
+	timeout := time.Minute
+	err = wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		pod, err = cl.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("getting pod %s: %w", name, err)
+		}
+		switch pod.Status.Phase {
+		case v1.PodFailed, v1.PodSucceeded:
+			return false, fmt.Errorf("the debug pod %s in namespace %s stopped: %s", name, namespace, pod.Status.Phase)
+		}
+		return pod.Status.Phase == v1.PodRunning, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return name, fmt.Errorf("the debug pod %s in namespace %s wasn't running after %s", name, namespace, timeout)
+	}
+	if err != nil {
+		return name, err
+	}
+
+	return name, nil
+}
+
+// readKubeletKubeconfig reads the kube config of the kubelet using the given
+// function, and embeds the certificate files it refers to.
+func readKubeletKubeconfig(cat func(file string) ([]byte, error)) (*clientcmdapi.Config, error) {
+	var data []byte
+	var kubeconfigPath string
+	var errs []string
+	for _, p := range kubeletKubeconfigPaths {
+		var err error
+		data, err = cat(p)
+		if err == nil {
+			kubeconfigPath = p
+			break
+		}
+		errs = append(errs, err.Error())
+	}
+	if kubeconfigPath == "" {
+		return nil, fmt.Errorf("no kubelet kube config found on the node: %s", strings.Join(errs, "; "))
+	}
+	logutil.Debugf("found the kubelet kube config at %s", kubeconfigPath)
+
+	apiconf, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", kubeconfigPath, err)
+	}
+	kctx, ok := apiconf.Contexts[apiconf.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("the current context of %s doesn't exist", kubeconfigPath)
+	}
+	cluster, ok := apiconf.Clusters[kctx.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("the cluster %s of %s doesn't exist", kctx.Cluster, kubeconfigPath)
+	}
+	user, ok := apiconf.AuthInfos[kctx.AuthInfo]
+	if !ok {
+		return nil, fmt.Errorf("the user %s of %s doesn't exist", kctx.AuthInfo, kubeconfigPath)
+	}
+
+	// Relative paths are relative to the kube config's directory.
+	embed := func(file string) ([]byte, error) {
+		if !path.IsAbs(file) {
+			file = path.Join(path.Dir(kubeconfigPath), file)
+		}
+		return cat(file)
+	}
+	if cluster.CertificateAuthority != "" {
+		cluster.CertificateAuthorityData, err = embed(cluster.CertificateAuthority)
+		if err != nil {
+			return nil, err
+		}
+		cluster.CertificateAuthority = ""
+	}
+	if user.ClientCertificate != "" {
+		user.ClientCertificateData, err = embed(user.ClientCertificate)
+		if err != nil {
+			return nil, err
+		}
+		user.ClientCertificate = ""
+	}
+	if user.ClientKey != "" {
+		user.ClientKeyData, err = embed(user.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		user.ClientKey = ""
+	}
+
+	// With the kubelet certificate rotation, both the certificate and the key
+	// are stored in /var/lib/kubelet/pki/kubelet-client-current.pem.
+	user.ClientCertificateData = pemBlocks(user.ClientCertificateData, func(typ string) bool { return typ == "CERTIFICATE" })
+	user.ClientKeyData = pemBlocks(user.ClientKeyData, func(typ string) bool { return strings.HasSuffix(typ, "PRIVATE KEY") })
+
+	return apiconf, nil
+}
+
+// pemBlocks returns the PEM blocks of the given types.
+func pemBlocks(data []byte, keep func(typ string) bool) []byte {
+	var out []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return out
+		}
+		if keep(block.Type) {
+			out = append(out, pem.EncodeToMemory(block)...)
+		}
+	}
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// podCredentials is what is mounted in a pod's service account mount.
+type podCredentials struct {
+	Token     string
+	CA        []byte
+	Namespace string
+}
+
+// getPodCredentials uses the exec subresource to read the service account
+// token, ca.crt and namespace mounted in a running pod. The ref is of the form
+// 'namespace/pod' or 'namespace/pod/container'.
+func getPodCredentials(c *rest.Config, ref string) (podCredentials, error) {
+	splits := strings.Split(ref, "/")
+	if len(splits) != 2 && len(splits) != 3 {
+		return podCredentials{}, flagErrorf("expected value of the form 'namespace/pod[/container]', got: %s", ref)
+	}
+	namespace, pod, container := splits[0], splits[1], ""
+	if len(splits) == 3 {
+		container = splits[2]
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return podCredentials{}, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	return readCredentials(func(file string) ([]byte, error) {
+		return execCat(c, cl, namespace, pod, container, file)
+	})
+}
+
+// readCredentials reads the token, ca.crt and namespace from the default
+// service account mount using the given function.
+func readCredentials(cat func(file string) ([]byte, error)) (podCredentials, error) {
+	var creds podCredentials
+	token, err := cat(incluster.DefaultTokenMount + "/token")
+	if err != nil {
+		return podCredentials{}, err
+	}
+	creds.Token = strings.TrimSpace(string(token))
+
+	creds.CA, err = cat(incluster.DefaultTokenMount + "/ca.crt")
+	if err != nil {
+		return podCredentials{}, err
+	}
+
+	ns, err := cat(incluster.DefaultTokenMount + "/namespace")
+	if err != nil {
+		return podCredentials{}, err
+	}
+	creds.Namespace = strings.TrimSpace(string(ns))
+
+	return creds, nil
+}
+
+// execCat runs 'cat <file>' in the given pod and returns stdout. It requires
+// the container image to ship with 'cat', which isn't the case of distroless
+// images.
+func execCat(c *rest.Config, cl kubernetes.Interface, namespace, pod, container, file string) ([]byte, error) {
+	req := cl.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   []string{"cat", file},
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(c, "POST", req.URL())
+	if err != nil {
+		return nil, fmt.Errorf("creating the exec request for pod %s in namespace %s: %w", pod, namespace, err)
+	}
+
+	logutil.Debugf("running 'cat %s' in pod %s in namespace %s", file, pod, namespace)
+	var stdout, stderr bytes.Buffer
+	err = exec.Stream(remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("running 'cat %s' in pod %s in namespace %s: %w: %s", file, pod, namespace, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
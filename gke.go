@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// useGKE builds the kube config of the given GKE cluster, the same way
+// 'gcloud container clusters get-credentials' does, and uses it as the source
+// kube config instead of the one given with --kubeconfig. The server and CA
+// are fetched with the GKE API. The user is an exec stanza running
+// gke-gcloud-auth-plugin, or, with --static-token, the access token used to
+// call the GKE API. The value is of the form 'project/location/cluster'.
+//
+// The access token is minted by the metadata server when running on GCE or
+// GKE (with workload identity, it is the token of the Google service account
+// bound to the pod's service account), and by 'gcloud auth print-access-token'
+// otherwise.
+func useGKE(ctx context.Context, value string) error {
+	if *kubeconfig != "" {
+		return flagErrorf("--gke can't be used with --kubeconfig")
+	}
+	if *server != "" {
+		return flagErrorf("--gke can't be used with --server")
+	}
+	splits := strings.Split(value, "/")
+	if len(splits) != 3 || splits[0] == "" || splits[1] == "" || splits[2] == "" {
+		return flagErrorf("--gke: expected value of the form 'project/location/cluster', got: %s", value)
+	}
+	project, location, name := splits[0], splits[1], splits[2]
+
+	token, err := googleAccessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("while processing flag --gke: %w", err)
+	}
+
+	// Same as gcloud, the endpoint can be overridden, e.g. to use a private
+	// endpoint.
+	api := os.Getenv("CLOUDSDK_API_ENDPOINT_OVERRIDES_CONTAINER")
+	if api == "" {
+		api = "https://container.googleapis.com/"
+	}
+	url := strings.TrimSuffix(api, "/") + "/v1/projects/" + project + "/locations/" + location + "/clusters/" + name
+	var cluster struct {
+		Endpoint   string `json:"endpoint"`
+		MasterAuth struct {
+			ClusterCaCertificate string `json:"clusterCaCertificate"`
+		} `json:"masterAuth"`
+	}
+	if err := getGoogleAPI(ctx, url, token, &cluster); err != nil {
+		return fmt.Errorf("while processing flag --gke: getting the cluster %s: %w", value, err)
+	}
+	if cluster.Endpoint == "" {
+		return fmt.Errorf("while processing flag --gke: the cluster %s has no endpoint yet, is it still being created?", value)
+	}
+	ca, err := base64.StdEncoding.DecodeString(cluster.MasterAuth.ClusterCaCertificate)
+	if err != nil {
+		return fmt.Errorf("while processing flag --gke: decoding the CA of the cluster %s: %w", value, err)
+	}
+	logutil.Debugf("the GKE cluster %s is served at %s", value, cluster.Endpoint)
+
+	user := &clientcmdapi.AuthInfo{}
+	if *staticToken {
+		user.Token = token
+	} else {
+		user.Exec = &clientcmdapi.ExecConfig{
+			APIVersion:  "client.authentication.k8s.io/v1beta1",
+			Command:     "gke-gcloud-auth-plugin",
+			InstallHint: "Install gke-gcloud-auth-plugin for use with kubectl by following https://cloud.google.com/kubernetes-engine/docs/how-to/cluster-access-for-kubectl#install_plugin",
+		}
+		// Without the exec stanza, the generated kube config would have no
+		// credentials.
+		*keepExec = true
+	}
+
+	return useGeneratedKubeconfig("gke_"+project+"_"+location+"_"+name, &clientcmdapi.Cluster{
+		Server:                   "https://" + cluster.Endpoint,
+		CertificateAuthorityData: ca,
+	}, user)
+}
+
+// googleAccessToken returns an OAuth2 access token for the Google APIs. The
+// metadata server is tried first, then 'gcloud auth print-access-token'.
+func googleAccessToken(ctx context.Context) (string, error) {
+	token, err := metadataServerToken(ctx)
+	if err == nil {
+		logutil.Debugf("using the access token minted by the metadata server")
+		return token, nil
+	}
+	logutil.Debugf("the metadata server isn't available, falling back to gcloud: %s", err)
+
+	out, err := runCat("gcloud", "auth", "print-access-token")
+	if err != nil {
+		return "", fmt.Errorf("%w: not running on GCE or GKE and %s", incluster.ErrNoCredentials, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// metadataServerToken returns the access token of the Google service account
+// attached to the GCE instance, or bound to the pod's service account with
+// workload identity. Like the Google client libraries, $GCE_METADATA_HOST
+// overrides the address of the metadata server.
+func metadataServerToken(ctx context.Context) (string, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+
+	// Outside of GCE, the lookup of metadata.google.internal may take a while
+	// to fail.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	// The metadata server must never be reached through a proxy.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s from the metadata server", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decoding the token returned by the metadata server: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("the metadata server didn't return a token")
+	}
+	return token.AccessToken, nil
+}
+
+// getGoogleAPI sends a GET request to the given Google API URL and decodes
+// the JSON response into out.
+func getGoogleAPI(ctx context.Context, url, token string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := (&http.Client{Timeout: defaultRequestTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading the response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var googleErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &googleErr) == nil && googleErr.Error.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, googleErr.Error.Message)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding the response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// goSnippet prints a Go program that builds the rest.Config equivalent to
+// the current context of the kube config and prints the server's version
+// with it. It is meant for prototyping controllers with the identity that
+// kubectl-incluster resolved. The CA, token and client certificate are
+// embedded as constants, or referred to as files with --no-embed.
+func goSnippet(kubeconfig *clientcmdapi.Config) ([]byte, error) {
+	cluster, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if user.Exec != nil || user.AuthProvider != nil {
+		return nil, fmt.Errorf("the credentials use an exec or auth provider plugin, use a token or a client certificate instead")
+	}
+	ns := ""
+	if ctx, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]; ok {
+		ns = ctx.Namespace
+	}
+
+	var consts, fields, tls bytes.Buffer
+	constant := func(name string, data []byte) {
+		// PEM blocks never contain backquotes.
+		fmt.Fprintf(&consts, "%s = `%s`\n", name, data)
+	}
+	field := func(b *bytes.Buffer, name, value string) {
+		fmt.Fprintf(b, "%s: %s,\n", name, value)
+	}
+
+	field(&fields, "Host", fmt.Sprintf("%q", cluster.Server))
+	switch {
+	case user.Token != "":
+		fmt.Fprintf(&consts, "token = %q\n", user.Token)
+		field(&fields, "BearerToken", "token")
+	case user.TokenFile != "":
+		field(&fields, "BearerTokenFile", fmt.Sprintf("%q", user.TokenFile))
+	}
+	if user.Username != "" {
+		field(&fields, "Username", fmt.Sprintf("%q", user.Username))
+		field(&fields, "Password", fmt.Sprintf("%q", user.Password))
+	}
+	if user.Impersonate != "" || len(user.ImpersonateGroups) > 0 {
+		var groups []string
+		for _, g := range user.ImpersonateGroups {
+			groups = append(groups, fmt.Sprintf("%q", g))
+		}
+		field(&fields, "Impersonate", fmt.Sprintf("rest.ImpersonationConfig{UserName: %q, Groups: []string{%s}}", user.Impersonate, strings.Join(groups, ", ")))
+	}
+	if *qps != 0 {
+		field(&fields, "QPS", fmt.Sprint(*qps))
+	}
+	if *burst != 0 {
+		field(&fields, "Burst", fmt.Sprint(*burst))
+	}
+
+	if cluster.InsecureSkipTLSVerify {
+		field(&tls, "Insecure", "true")
+	}
+	if cluster.TLSServerName != "" {
+		field(&tls, "ServerName", fmt.Sprintf("%q", cluster.TLSServerName))
+	}
+	switch {
+	case len(cluster.CertificateAuthorityData) > 0:
+		constant("caData", cluster.CertificateAuthorityData)
+		field(&tls, "CAData", "[]byte(caData)")
+	case cluster.CertificateAuthority != "":
+		field(&tls, "CAFile", fmt.Sprintf("%q", cluster.CertificateAuthority))
+	}
+	switch {
+	case len(user.ClientCertificateData) > 0:
+		constant("certData", user.ClientCertificateData)
+		constant("keyData", user.ClientKeyData)
+		field(&tls, "CertData", "[]byte(certData)")
+		field(&tls, "KeyData", "[]byte(keyData)")
+	case user.ClientCertificate != "":
+		field(&tls, "CertFile", fmt.Sprintf("%q", user.ClientCertificate))
+		field(&tls, "KeyFile", fmt.Sprintf("%q", user.ClientKey))
+	}
+	if tls.Len() > 0 {
+		field(&fields, "TLSClientConfig", "rest.TLSClientConfig{\n"+tls.String()+"}")
+	}
+
+	imports := []string{`"fmt"`}
+	if cluster.ProxyURL != "" {
+		imports = append(imports, `"net/http"`, `"net/url"`)
+		field(&fields, "Proxy", fmt.Sprintf("func(*http.Request) (*url.URL, error) { return url.Parse(%q) }", cluster.ProxyURL))
+	}
+	if ns != "" {
+		fmt.Fprintf(&consts, "\n// namespace is the namespace of the context.\nnamespace = %q\n", ns)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by kubectl-incluster -o go. It contains credentials, don't commit it.\n\n")
+	b.WriteString("package main\n\n")
+	fmt.Fprintf(&b, "import (\n%s\n\n\"k8s.io/client-go/kubernetes\"\n\"k8s.io/client-go/rest\"\n)\n\n", strings.Join(imports, "\n"))
+	if consts.Len() > 0 {
+		fmt.Fprintf(&b, "const (\n%s)\n\n", consts.String())
+	}
+	fmt.Fprintf(&b, "func restConfig() *rest.Config {\nreturn &rest.Config{\n%s}\n}\n\n", fields.String())
+	b.WriteString(`func main() {
+cl, err := kubernetes.NewForConfig(restConfig())
+if err != nil {
+panic(err)
+}
+version, err := cl.Discovery().ServerVersion()
+if err != nil {
+panic(err)
+}
+fmt.Println(version)
+}
+`)
+
+	content, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting the Go code: %w", err)
+	}
+	return content, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// pickInteractively asks the user to pick the context when using a kube
+// config, or the service account when in cluster, and sets --context or
+// --serviceaccount accordingly. It is used with --interactive. The prompts
+// are printed to stderr so that stdout only contains the kube config.
+func pickInteractively(ctx context.Context) error {
+	if *kubeconfig == "-" {
+		return flagErrorf("--interactive can't be used with '--kubeconfig -'")
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return flagErrorf("--interactive requires stdin to be a terminal")
+	}
+	in := bufio.NewReader(os.Stdin)
+
+	if !inCluster() {
+		if *kubecontext != "" {
+			return nil
+		}
+
+		opts, err := inclusterOptions()
+		if err != nil {
+			return err
+		}
+		apicfg, err := incluster.LoadKubeconfig(opts)
+		if err != nil {
+			return fmt.Errorf("loading the kube config: %w", err)
+		}
+		var names []string
+		for name := range apicfg.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		*kubecontext, err = prompt(in, "Pick a context", names, apicfg.CurrentContext)
+		return err
+	}
+
+	if len(*serviceaccount) > 0 || len(*sa) > 0 {
+		return nil
+	}
+
+	c, err := apiConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	// Listing the namespaces often isn't allowed for a pod's service
+	// account, in which case the pod's namespace is used.
+	ns := contextNamespace()
+	if ns == "" {
+		ns = "default"
+	}
+	list, err := cl.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logutil.Debugf("can't list the namespaces, using the namespace %s: %s", ns, err)
+	} else {
+		var namespaces []string
+		for _, item := range list.Items {
+			namespaces = append(namespaces, item.Name)
+		}
+		ns, err = prompt(in, "Pick a namespace", namespaces, ns)
+		if err != nil {
+			return err
+		}
+	}
+
+	sas, err := cl.CoreV1().ServiceAccounts(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("listing the service accounts in namespace %s: %w", ns, err)
+	}
+	var names []string
+	for _, item := range sas.Items {
+		names = append(names, item.Name)
+	}
+	name, err := prompt(in, "Pick a service account in namespace "+ns, names, "default")
+	if err != nil {
+		return err
+	}
+	*serviceaccount = []string{ns + "/" + name}
+
+	return nil
+}
+
+// prompt prints the numbered choices to stderr and reads the number of the
+// choice from the given reader. An empty answer picks the default, if it is
+// one of the choices.
+func prompt(in *bufio.Reader, title string, choices []string, def string) (string, error) {
+	if len(choices) == 0 {
+		return "", fmt.Errorf("%s: nothing to pick from", title)
+	}
+
+	defIdx := -1
+	for i, choice := range choices {
+		marker := " "
+		if choice == def {
+			marker, defIdx = "*", i
+		}
+		fmt.Fprintf(os.Stderr, "%s %2d) %s\n", marker, i+1, choice)
+	}
+
+	for {
+		if defIdx != -1 {
+			fmt.Fprintf(os.Stderr, "%s [1-%d, default %d]: ", title, len(choices), defIdx+1)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s [1-%d]: ", title, len(choices))
+		}
+
+		line, err := in.ReadString('\n')
+		if err != nil && !(err == io.EOF && line != "") {
+			return "", fmt.Errorf("%s: reading the answer: %w", title, err)
+		}
+		line = strings.TrimSpace(line)
+
+		if line == "" && defIdx != -1 {
+			return choices[defIdx], nil
+		}
+		n, err := strconv.Atoi(line)
+		if err == nil && n >= 1 && n <= len(choices) {
+			return choices[n-1], nil
+		}
+		fmt.Fprintf(os.Stderr, "please enter a number between 1 and %d\n", len(choices))
+	}
+}
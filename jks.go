@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// The JKS format isn't documented outside of the JDK's sun.security.provider
+// .JavaKeyStore, which is what this follows.
+const (
+	jksMagic        = 0xFEEDFEED
+	jksVersion      = 2
+	jksTrustedEntry = 2
+)
+
+// caJKS encodes the CA certificates as a Java truststore (JKS). Each
+// certificate is a trusted certificate entry with the alias 'ca-<index>'. The
+// password is only used for the integrity check of the keystore since
+// trusted certificates aren't encrypted.
+func caJKS(certs []*x509.Certificate, password string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in the CA")
+	}
+
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		// Writing to a bytes.Buffer never fails.
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		buf.WriteString(s)
+	}
+
+	write(uint32(jksMagic))
+	write(uint32(jksVersion))
+	write(uint32(len(certs)))
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for i, cert := range certs {
+		write(uint32(jksTrustedEntry))
+		writeUTF(fmt.Sprintf("ca-%d", i))
+		write(now)
+		writeUTF("X.509")
+		write(uint32(len(cert.Raw)))
+		buf.Write(cert.Raw)
+	}
+
+	// The digest is SHA-1 over the UTF-16 password, the string "Mighty
+	// Aphrodite" and the keystore.
+	h := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+
+	return buf.Bytes(), nil
+}
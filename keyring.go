@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// keyringService is the service under which the kube configs are stored in
+// the OS keyring. The account is the name given to 'store'.
+const keyringService = "kubectl-incluster"
+
+// runStore stores the generated kube config in the OS keyring under the given
+// name, and prints a kube config that has the same cluster but whose user runs
+// 'kubectl-incluster load --exec-credential <name>' to read the credentials
+// from the keyring, so that the credentials never end up in plain text in
+// ~/.kube.
+func runStore(ctx context.Context, name string) error {
+	kubeconfig, err := resolveKubeconfig(ctx, os.Getenv("HTTPS_PROXY"))
+	if err != nil {
+		return err
+	}
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return fmt.Errorf("serializing the kube config: %w", err)
+	}
+	if err := keyringSet(name, content); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	logutil.Infof("stored the kube config in the OS keyring as %s", name)
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("store: finding the path of kubectl-incluster: %w", err)
+	}
+	for userName := range kubeconfig.AuthInfos {
+		kubeconfig.AuthInfos[userName] = &clientcmdapi.AuthInfo{
+			Exec: &clientcmdapi.ExecConfig{
+				APIVersion: "client.authentication.k8s.io/v1beta1",
+				Command:    self,
+				Args:       []string{"load", "--exec-credential", name},
+			},
+		}
+	}
+	return writeKubeconfigOutput(ctx, kubeconfig)
+}
+
+// runLoad prints the kube config stored in the OS keyring under the given
+// name, or, with --exec-credential, an ExecCredential containing its
+// credentials, which is what kubectl expects from an exec plugin.
+func runLoad(ctx context.Context, name string, execCredential bool, out io.Writer) error {
+	content, err := keyringGet(name)
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	kubeconfig, err := clientcmd.Load(content)
+	if err != nil {
+		return fmt.Errorf("load: parsing the kube config stored as %s: %w", name, err)
+	}
+	if !execCredential {
+		return writeKubeconfigOutput(ctx, kubeconfig)
+	}
+
+	_, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	status := struct {
+		Token                 string `json:"token,omitempty"`
+		ClientCertificateData string `json:"clientCertificateData,omitempty"`
+		ClientKeyData         string `json:"clientKeyData,omitempty"`
+	}{user.Token, string(user.ClientCertificateData), string(user.ClientKeyData)}
+	if status.Token == "" && status.ClientCertificateData == "" {
+		return fmt.Errorf("load: %w: the kube config stored as %s has neither a token nor a client certificate", incluster.ErrNoCredentials, name)
+	}
+
+	return json.NewEncoder(out).Encode(map[string]interface{}{
+		"apiVersion": "client.authentication.k8s.io/v1beta1",
+		"kind":       "ExecCredential",
+		"status":     status,
+	})
+}
+
+// keyringSet stores the data in the OS keyring using the CLI that ships with
+// the OS: 'security' on macOS and 'secret-tool' (Secret Service, e.g. GNOME
+// Keyring or KWallet) on Linux. The data is base64-encoded since the macOS
+// keychain CLI mangles multi-line values.
+//
+// The data is always given on stdin so that it can't be read from the
+// arguments with 'ps'. The Windows Credential Manager isn't supported since
+// it can't hold more than 2560 bytes, which is less than most kube configs.
+func keyringSet(name string, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	switch runtime.GOOS {
+	case "darwin":
+		// When -w comes last, 'security' prompts for the password twice.
+		_, err := runKeyringCLI(strings.NewReader(encoded+"\n"+encoded+"\n"), "security", "add-generic-password", "-U", "-s", keyringService, "-a", name, "-l", keyringService+": "+name, "-w")
+		if err != nil {
+			return err
+		}
+		// Make sure that the prompt didn't truncate the data.
+		stored, err := keyringGet(name)
+		if err != nil {
+			return fmt.Errorf("reading back what was stored as %s: %w", name, err)
+		}
+		if !bytes.Equal(stored, data) {
+			return fmt.Errorf("what was stored in the keychain as %s differs from the kube config, it may be too large for 'security'", name)
+		}
+		return nil
+	case "linux", "freebsd", "openbsd":
+		_, err := runKeyringCLI(strings.NewReader(encoded), "secret-tool", "store", "--label", keyringService+": "+name, "service", keyringService, "account", name)
+		return err
+	default:
+		return fmt.Errorf("the OS keyring isn't supported on %s", runtime.GOOS)
+	}
+}
+
+// keyringGet returns the data stored in the OS keyring with keyringSet.
+func keyringGet(name string) ([]byte, error) {
+	var out []byte
+	var err error
+	var notFoundCode int
+	switch runtime.GOOS {
+	case "darwin":
+		out, err = runKeyringCLI(nil, "security", "find-generic-password", "-s", keyringService, "-a", name, "-w")
+		notFoundCode = 44 // errSecItemNotFound.
+	case "linux", "freebsd", "openbsd":
+		out, err = runKeyringCLI(nil, "secret-tool", "lookup", "service", keyringService, "account", name)
+		notFoundCode = 1
+	default:
+		return nil, fmt.Errorf("the OS keyring isn't supported on %s", runtime.GOOS)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == notFoundCode {
+		out, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Older versions of secret-tool exit with 0 and print nothing when there
+	// is no match.
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, fmt.Errorf("%w: nothing is stored in the OS keyring as %s, run 'kubectl incluster store %s' first", incluster.ErrNoCredentials, name, name)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding what is stored in the OS keyring as %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// runKeyringCLI is the same as runCat except that stdin can be given. The
+// arguments aren't logged since they may contain the secret.
+func runKeyringCLI(stdin io.Reader, name string, args ...string) ([]byte, error) {
+	logutil.Debugf("running '%s %s'", name, args[0])
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running '%s %s': %w: %s", name, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// A localCluster is a kind or k3d cluster running on the local Docker
+// daemon. The container is the one that publishes the API server port on the
+// host.
+type localCluster struct {
+	Name      string
+	Provider  string // Either "kind" or "k3d".
+	Container string
+}
+
+// detectLocalClusters lists the kind and k3d clusters running in Docker. For
+// each cluster, the load balancer container is preferred over the control
+// plane container since HA clusters only publish the API server port on the
+// load balancer.
+func detectLocalClusters() ([]localCluster, error) {
+	var clusters []localCluster
+
+	kind, err := dockerPS("io.x-k8s.kind.cluster", "io.x-k8s.kind.role", "external-load-balancer", "control-plane")
+	if err != nil {
+		return nil, err
+	}
+	for name, container := range kind {
+		clusters = append(clusters, localCluster{Name: name, Provider: "kind", Container: container})
+	}
+
+	k3d, err := dockerPS("k3d.cluster", "k3d.role", "loadbalancer", "server")
+	if err != nil {
+		return nil, err
+	}
+	for name, container := range k3d {
+		clusters = append(clusters, localCluster{Name: name, Provider: "k3d", Container: container})
+	}
+
+	return clusters, nil
+}
+
+// dockerPS returns the container to use for each cluster found using the
+// given labels. The container with the preferred role wins over the one with
+// the fallback role.
+func dockerPS(clusterLabel, roleLabel, preferredRole, fallbackRole string) (map[string]string, error) {
+	out, err := exec.Command("docker", "ps",
+		"--filter", "label="+roleLabel,
+		"--format", fmt.Sprintf(`{{.Names}}\t{{.Label "%s"}}\t{{.Label "%s"}}`, clusterLabel, roleLabel),
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("while running 'docker ps': %w", err)
+	}
+
+	containers := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		container, cluster, role := fields[0], fields[1], fields[2]
+		switch {
+		case role == preferredRole:
+			containers[cluster] = container
+		case role == fallbackRole && containers[cluster] == "":
+			containers[cluster] = container
+		}
+	}
+
+	return containers, nil
+}
+
+// hostServerURL returns the URL of the API server as published on the host
+// by the given container, e.g. 'https://127.0.0.1:41234'.
+func hostServerURL(container string) (string, error) {
+	out, err := exec.Command("docker", "port", container, "6443/tcp").Output()
+	if err != nil {
+		return "", fmt.Errorf("while running 'docker port %s 6443/tcp': %w", container, err)
+	}
+
+	// The output may contain both the IPv4 and IPv6 bindings:
+	//  0.0.0.0:41234
+	//  [::]:41234
+	// The wildcard addresses are replaced with the loopback address of the
+	// same family, and the IPv4 binding is preferred when there are both.
+	var addr string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(line))
+		if err != nil {
+			continue
+		}
+		switch host {
+		case "0.0.0.0", "":
+			host = "127.0.0.1"
+		case "::":
+			host = "::1"
+		}
+		if net.ParseIP(host).To4() != nil {
+			addr = net.JoinHostPort(host, port)
+			break
+		}
+		if addr == "" {
+			addr = net.JoinHostPort(host, port)
+		}
+	}
+	if addr == "" {
+		return "", fmt.Errorf("the container %s doesn't publish the port 6443", container)
+	}
+
+	return "https://" + addr, nil
+}
+
+// forHostServer figures out the host-reachable URL of the kind or k3d cluster
+// running locally. It fails when zero or more than one cluster is found.
+func forHostServer() (string, error) {
+	clusters, err := detectLocalClusters()
+	if err != nil {
+		return "", err
+	}
+
+	switch len(clusters) {
+	case 0:
+		return "", fmt.Errorf("no kind or k3d cluster found in Docker")
+	case 1:
+	default:
+		var names []string
+		for _, cluster := range clusters {
+			names = append(names, cluster.Provider+"/"+cluster.Name)
+		}
+		return "", fmt.Errorf("several kind or k3d clusters were found (%s), use --server-override instead", strings.Join(names, ", "))
+	}
+
+	logutil.Debugf("found the %s cluster %s, its API server is published by the container %s", clusters[0].Provider, clusters[0].Name, clusters[0].Container)
+	return hostServerURL(clusters[0].Container)
+}
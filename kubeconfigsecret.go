@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// sourceKubeconfig is the content of the kube config fetched from a Secret
+// with --kubeconfig-from-secret. When set, it replaces the kube config given
+// with --kubeconfig.
+var sourceKubeconfig []byte
+
+// kubeconfigSecretKeys are the keys tried in order when the key of the Secret
+// isn't given. Cluster API uses 'value', and vcluster uses 'config'.
+var kubeconfigSecretKeys = []string{"value", "config", "kubeconfig"}
+
+// useKubeconfigFromSecret fetches the kube config stored in the given Secret
+// and uses it as the source kube config instead of the one given with
+// --kubeconfig. The flag is the one the ref comes from, e.g.
+// '--kubeconfig-from-secret' or '--cluster-api'. The flags --kubeconfig, --context, --cluster and --user
+// select the cluster the Secret is fetched from, which means the current
+// context of the kube config stored in the Secret is used. The other flags
+// (e.g., --force-token or --serviceaccount) apply to the cluster of the kube
+// config stored in the Secret.
+func useKubeconfigFromSecret(ctx context.Context, flag, ref string) error {
+	if *server != "" {
+		return flagErrorf("%s can't be used with --server", flag)
+	}
+
+	c, err := apiConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+	data, err := getKubeconfigFromSecret(ctx, c, ref, contextNamespace())
+	if err != nil {
+		return fmt.Errorf("while processing flag %s: %w", flag, err)
+	}
+
+	sourceKubeconfig = data
+	*kubecontext, overrides.Context.Cluster, overrides.Context.AuthInfo = "", "", ""
+	return nil
+}
+
+// getKubeconfigFromSecret returns the kube config stored in the given Secret.
+// The ref is of the form '[namespace/]name[#key]'. When the key is omitted,
+// the keys in kubeconfigSecretKeys are tried, or the only key of the Secret
+// is used.
+func getKubeconfigFromSecret(ctx context.Context, c *rest.Config, ref, defaultNamespace string) ([]byte, error) {
+	namespace, name, key, err := parseObjectRef(ref, defaultNamespace, kubeconfigSecretKeys[0])
+	if err != nil {
+		return nil, err
+	}
+	keyGiven := strings.Contains(ref, "#")
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+	secret, err := cl.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting secret %s in namespace %s: %w", name, namespace, err)
+	}
+
+	if !keyGiven {
+		key = ""
+		for _, k := range kubeconfigSecretKeys {
+			if _, ok := secret.Data[k]; ok {
+				key = k
+				break
+			}
+		}
+		if key == "" && len(secret.Data) == 1 {
+			for k := range secret.Data {
+				key = k
+			}
+		}
+		if key == "" {
+			var keys []string
+			for k := range secret.Data {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return nil, fmt.Errorf("none of the keys %s found in secret %s in namespace %s, pick one of %s with '#key'", strings.Join(kubeconfigSecretKeys, ", "), name, namespace, strings.Join(keys, ", "))
+		}
+	}
+
+	data, ok := secret.Data[key]
+	if !ok || len(data) == 0 {
+		return nil, fmt.Errorf("the key '%s' is missing or empty in secret %s in namespace %s", key, name, namespace)
+	}
+	if _, err := clientcmd.Load(data); err != nil {
+		return nil, fmt.Errorf("the key '%s' of secret %s in namespace %s isn't a kube config: %w", key, name, namespace, err)
+	}
+	logutil.Debugf("using the kube config stored in the key '%s' of the secret %s/%s", key, namespace, name)
+
+	return data, nil
+}
+
+// clusterAPISecretRef returns the reference to the Secret in which the
+// Cluster API providers store the kube config of the given workload cluster,
+// i.e. the key 'value' of the Secret '<cluster>-kubeconfig' in the namespace
+// of the Cluster. The cluster is of the form '[namespace/]name'.
+func clusterAPISecretRef(cluster string) (string, error) {
+	splits := strings.Split(cluster, "/")
+	if len(splits) > 2 || splits[len(splits)-1] == "" {
+		return "", flagErrorf("--cluster-api: expected value of the form '[namespace/]name', got: %s", cluster)
+	}
+	return cluster + "-kubeconfig#value", nil
+}
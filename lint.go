@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// lintDialTimeout is how long the lint subcommand waits for the TCP
+// connection to each server.
+const lintDialTimeout = 5 * time.Second
+
+// lintProblem is a problem found by the lint subcommand in a cluster or a
+// user of the kube config. Errors make the kube config unusable, warnings
+// may be intended.
+type lintProblem struct {
+	Severity string
+	Object   string
+	Message  string
+}
+
+const (
+	lintError = "error"
+	lintWarn  = "warn"
+)
+
+// lintKubeconfig checks every cluster and user of the kube config for the
+// problems kubectl-incluster is usually run to debug: referenced files that
+// can't be read, expired certificates and tokens, exec plugins missing from
+// the PATH, insecure-skip-tls-verify, and servers that can't be reached. The
+// certificates that expire within the given window are warned about.
+func lintKubeconfig(kubeconfig *clientcmdapi.Config, window time.Duration) []lintProblem {
+	var problems []lintProblem
+	report := func(severity, object, format string, a ...interface{}) {
+		problems = append(problems, lintProblem{Severity: severity, Object: object, Message: fmt.Sprintf(format, a...)})
+	}
+
+	checkCerts := func(object, what string, data []byte) {
+		certs, err := parseCertsPEM(data)
+		if err != nil {
+			report(lintError, object, "the %s can't be parsed: %s", what, err)
+			return
+		}
+		for _, cert := range certs {
+			switch {
+			case time.Now().After(cert.NotAfter):
+				report(lintError, object, "the %s '%s' has expired on %s", what, cert.Subject, cert.NotAfter.Format(time.RFC3339))
+			case time.Now().Add(window).After(cert.NotAfter):
+				report(lintWarn, object, "the %s '%s' expires soon, on %s", what, cert.Subject, cert.NotAfter.Format(time.RFC3339))
+			}
+		}
+	}
+	// readRef reads the data or the file it refers to, and reports the file
+	// when it can't be read.
+	readRef := func(object, field string, data []byte, filename string) []byte {
+		data, err := dataOrFile(data, filename)
+		if err != nil {
+			report(lintError, object, "the file given in %s can't be read: %s", field, err)
+			return nil
+		}
+		return data
+	}
+
+	// The problems are always printed in the same order.
+	var clusters, users []string
+	for name := range kubeconfig.Clusters {
+		clusters = append(clusters, name)
+	}
+	for name := range kubeconfig.AuthInfos {
+		users = append(users, name)
+	}
+	sort.Strings(clusters)
+	sort.Strings(users)
+
+	var servers []lintServer
+	for _, name := range clusters {
+		cluster, object := kubeconfig.Clusters[name], "cluster/"+name
+		if cluster.InsecureSkipTLSVerify {
+			report(lintWarn, object, "insecure-skip-tls-verify is set, the certificate of the server isn't verified")
+		}
+		if ca := readRef(object, "certificate-authority", cluster.CertificateAuthorityData, cluster.CertificateAuthority); len(ca) > 0 {
+			checkCerts(object, "CA certificate", ca)
+		}
+		if cluster.Server == "" {
+			report(lintError, object, "the server is empty")
+			continue
+		}
+		servers = append(servers, lintServer{object: object, server: cluster.Server, proxyURL: cluster.ProxyURL})
+	}
+
+	for _, name := range users {
+		user, object := kubeconfig.AuthInfos[name], "user/"+name
+		if cert := readRef(object, "client-certificate", user.ClientCertificateData, user.ClientCertificate); len(cert) > 0 {
+			checkCerts(object, "client certificate", cert)
+		}
+		readRef(object, "client-key", user.ClientKeyData, user.ClientKey)
+
+		token, err := userToken(user)
+		if err != nil {
+			report(lintError, object, "the file given in tokenFile can't be read: %s", err)
+		}
+		// Tokens that aren't JWTs (e.g., bootstrap tokens) don't say when
+		// they expire.
+		if claims, err := decodeJWT(token); err == nil && claims.Expiry != 0 {
+			if expiry := time.Unix(claims.Expiry, 0); time.Now().After(expiry) {
+				report(lintError, object, "the token has expired on %s", expiry.UTC().Format(time.RFC3339))
+			}
+		}
+
+		if user.Exec != nil {
+			if _, err := exec.LookPath(user.Exec.Command); err != nil {
+				report(lintError, object, "the exec plugin '%s' isn't in the PATH", user.Exec.Command)
+			}
+		}
+	}
+
+	return append(problems, checkServers(servers)...)
+}
+
+// lintServer is a server whose reachability is checked.
+type lintServer struct {
+	object   string
+	server   string
+	proxyURL string
+}
+
+// checkServers opens a TCP connection to each server, or to its proxy when
+// it has a proxy-url, concurrently. Only the TCP connection is checked since
+// the credentials may be what is broken.
+func checkServers(servers []lintServer) []lintProblem {
+	problems := make([]*lintProblem, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s lintServer) {
+			defer wg.Done()
+			target, via := s.server, ""
+			if s.proxyURL != "" {
+				target, via = s.proxyURL, " (the proxy-url)"
+			}
+			addr, err := dialAddress(target)
+			if err == nil {
+				var conn net.Conn
+				conn, err = net.DialTimeout("tcp", addr, lintDialTimeout)
+				if err == nil {
+					conn.Close()
+				}
+			}
+			if err != nil {
+				problems[i] = &lintProblem{Severity: lintError, Object: s.object, Message: fmt.Sprintf("%s%s can't be reached: %s", target, via, err)}
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	var found []lintProblem
+	for _, p := range problems {
+		if p != nil {
+			found = append(found, *p)
+		}
+	}
+	return found
+}
+
+// dialAddress returns the host:port of the URL, the port defaulting to the
+// one of the scheme.
+func dialAddress(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("no host in %q", rawURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// printLintProblems prints the problems as a table.
+func printLintProblems(out io.Writer, problems []lintProblem) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Severity, p.Object, p.Message)
+	}
+	return w.Flush()
+}
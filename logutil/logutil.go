@@ -1,39 +1,123 @@
+// Package logutil prints leveled logs to stderr, either as colored text or as
+// JSON. Stdout is never used so that it only contains the kube config.
 package logutil
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mgutz/ansi"
 )
 
+// Level is the severity of a log line.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses one of 'debug', 'info', 'warn' or 'error'.
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level '%s', expected one of debug, info, warn or error", s)
+}
+
 var (
-	EnableDebug = false
+	// MinLevel is the lowest level that gets printed.
+	MinLevel = LevelInfo
+
+	// JSON prints one JSON object per line with the fields 'time', 'level'
+	// and 'msg' instead of colored text.
+	JSON = false
+
+	// NoColor disables the colors. It is set when NO_COLOR is set
+	// (https://no-color.org) or when stderr isn't a terminal.
+	NoColor = os.Getenv("NO_COLOR") != "" || !isTerminal(os.Stderr)
+
+	// Output is where the logs are written.
+	Output io.Writer = os.Stderr
 
-	Yel   = ansi.ColorFunc("yellow")
-	Green = ansi.ColorFunc("green")
-	Red   = ansi.ColorFunc("red")
-	Bold  = ansi.ColorFunc("white+b")
-	Gray  = ansi.ColorFunc("black+h")
+	mu sync.Mutex
 )
 
-// Prints to stderr.
+var colors = map[Level]func(string) string{
+	LevelDebug: ansi.ColorFunc("black+h"),
+	LevelInfo:  ansi.ColorFunc("yellow"),
+	LevelWarn:  ansi.ColorFunc("magenta"),
+	LevelError: ansi.ColorFunc("red"),
+}
+
+// Debugf prints to stderr when the level is debug.
 func Debugf(format string, a ...interface{}) {
-	if !EnableDebug {
-		return
-	}
-	fmt.Fprintf(os.Stderr, "%s: ", Gray("debug"))
-	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	logf(LevelDebug, format, a...)
 }
 
-// Prints to stderr.
+// Infof prints to stderr unless the level is warn or error.
+func Infof(format string, a ...interface{}) {
+	logf(LevelInfo, format, a...)
+}
+
+// Warnf prints to stderr unless the level is error.
+func Warnf(format string, a ...interface{}) {
+	logf(LevelWarn, format, a...)
+}
+
+// Errorf always prints to stderr.
 func Errorf(format string, a ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%s: ", Red("error"))
-	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	logf(LevelError, format, a...)
 }
 
-// Prints to stderr.
-func Infof(format string, a ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%s: ", Yel("info"))
-	fmt.Fprintf(os.Stderr, format+"\n", a...)
+func logf(level Level, format string, a ...interface{}) {
+	if level < MinLevel {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+
+	// Several goroutines may log at the same time, e.g. with --output-dir.
+	mu.Lock()
+	defer mu.Unlock()
+
+	if JSON {
+		line, _ := json.Marshal(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{time.Now().Format(time.RFC3339), level.String(), msg})
+		fmt.Fprintf(Output, "%s\n", line)
+		return
+	}
+
+	prefix := level.String()
+	if !NoColor {
+		prefix = colors[level](prefix)
+	}
+	fmt.Fprintf(Output, "%s: %s\n", prefix, msg)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
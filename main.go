@@ -3,8 +3,8 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
-	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,35 +12,89 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/jaytaylor/go-hostsfile"
+	"github.com/spf13/pflag"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	certutil "k8s.io/client-go/util/cert"
-	"k8s.io/klog"
 
 	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
 )
 
+// flags are shared by all the subcommands.
+var flags = pflag.NewFlagSet("kubectl-incluster", pflag.ContinueOnError)
+
 var (
-	kubeconfig      = flag.String("kubeconfig", "", "Path to the kubeconfig file to use.")
-	kubecontext     = flag.String("context", "", "The name of the kubeconfig context to use.")
-	root            = flag.String("root", os.Getenv("CONTAINER_ROOT"), "The container root. You can also set CONTAINER_ROOT instead. If TELEPRESENCE_ROOT is set, it will default to that.")
-	deprecated      = flag.Bool("embed", false, "Deprecated since this is now the default behavior. Embeds the token and ca.crt data inside the kubeconfig instead of using file paths.")
-	replacecacert   = flag.String("replace-ca-cert", "", "Instead of using the cacert provided in /var/run/secrets or in the kube config, use this one. Useful when using a proxy like mitmproxy.")
-	replacecacertD  = flag.String("replace-cacert", "", "Deprecated, please use --replace-ca-cert instead.")
-	printClientCert = flag.Bool("print-client-cert", false, "Instead of printing the kube config, print the content of the kube config's client-certificate-data followed by the client-key-data.")
-	printCACert     = flag.Bool("print-ca-cert", false, "Instead of printing a kubeconfig, print the content of the kube config's certificate-authority-data.")
-	debug           = flag.Bool("d", false, "Print debug logs.")
-
-	serviceaccount = flag.String("serviceaccount", "", strings.ReplaceAll(
+	kubeconfig             = flags.String("kubeconfig", "", "Path to the kubeconfig file to use. Several paths separated by ':' (';' on Windows) are merged like kubectl merges the paths in $KUBECONFIG. Use '-' to read it from stdin.")
+	kubecontext            = flags.String("context", "", "The name of the kubeconfig context to use.")
+	root                   = flags.String("root", os.Getenv("CONTAINER_ROOT"), `The container root. You can also set CONTAINER_ROOT instead. If TELEPRESENCE_ROOT is set, it will default to that; when both are set and differ, use --root-index to pick one. Use 'auto' to ask the Telepresence CLI for the mount point of the active intercept. On Windows, the root can be a Windows path, e.g. 'C:\Users\me\telfs-1234'.`)
+	rootIndex              = flags.Int("root-index", 0, "When several container roots are found (e.g., both CONTAINER_ROOT and TELEPRESENCE_ROOT are set, or several Telepresence 2 mounts exist), pick the one with this number in the list printed in the error, starting at 1. Use --interactive to pick it from a list instead.")
+	deprecated             = flags.Bool("embed", false, "Deprecated since this is now the default behavior. Embeds the token and ca.crt data inside the kubeconfig instead of using file paths.")
+	noEmbed                = flags.Bool("no-embed", false, "Reference the token, CA and client certificate files by path in the generated kube config instead of embedding their content, so that a rotated token (e.g., a projected token) is picked up. The paths include the container root given with --root. Only the data that comes from a file is referenced.")
+	stripRoot              = flags.Bool("strip-root", false, "With --no-embed, remove the container root given with --root from the paths, so that the kube config works inside the container.")
+	forInCluster           = flags.Bool("for-in-cluster", false, "Reference the token and CA files that Kubernetes mounts in every pod (/var/run/secrets/kubernetes.io/serviceaccount/token and ca.crt) instead of embedding them, regardless of where they were read from. Useful when the kube config is extracted on a dev machine to be mounted back into a pod. Requires the credentials to be a token.")
+	replacecacert          = flags.String("replace-ca-cert", "", "Instead of using the cacert provided in /var/run/secrets or in the kube config, use this one. Useful when using a proxy like mitmproxy. Use '-' to read it from stdin.")
+	replaceCAFromProxy     = flags.Bool("replace-ca-cert-from-proxy", false, "Same as --replace-ca-cert but the CA is the one presented by mitmproxy, fetched through the proxy given with HTTPS_PROXY (or to the proxy subcommand) at http://mitm.it/cert/pem, which avoids using a stale copy of ~/.mitmproxy/mitmproxy-ca-cert.pem. Unlike the CA fetched on a best-effort basis when HTTPS_PROXY is set, failing to fetch it is an error.")
+	replaceCAFromURL       = flags.String("replace-ca-cert-from-url", "", "Same as --replace-ca-cert but the CA is fetched over HTTP(S) from the given URL.")
+	replaceCAFromSecret    = flags.String("replace-ca-cert-from-secret", "", "Same as --replace-ca-cert but the CA is read from the given Secret. The value is of the form '[namespace/]name[#key]'. The key defaults to 'ca.crt'.")
+	replaceCAFromConfigMap = flags.String("replace-ca-cert-from-configmap", "", "Same as --replace-ca-cert but the CA is read from the given ConfigMap. The value is of the form '[namespace/]name[#key]'. The key defaults to 'ca.crt'.")
+	tofuCA                 = flags.Bool("tofu-ca", false, "Trust on first use: connect to the API server, and use the last certificate of the chain it presents (the root, or the server certificate when it is self-signed) as the CA. The SHA-256 fingerprint is printed so that you can confirm it. Useful when the CA file isn't available locally.")
+	replacecacertD         = flags.String("replace-cacert", "", "Deprecated, please use --replace-ca-cert instead.")
+	printClientCert        = flags.Bool("print-client-cert", false, "Instead of printing the kube config, print the content of the kube config's client-key-data followed by the client-certificate-data. Use --order=cert-first to print the certificate first.")
+	printClientKey         = flags.Bool("print-client-key", false, "Instead of printing the kube config, print the content of the kube config's client-key-data only.")
+	printClientCertOnly    = flags.Bool("print-client-cert-only", false, "Instead of printing the kube config, print the content of the kube config's client-certificate-data only, without the key.")
+	printClientP12         = flags.Bool("print-client-p12", false, "Instead of printing the kube config, print the client certificate and key as a PKCS#12 bundle (.p12) protected with the password read from the environment variable given with --password-env, e.g. to import the identity into a browser or a Java keystore.")
+	printFingerprint       = flags.Bool("fingerprint", false, "Instead of printing the kube config, print the SHA-256 fingerprints of the CA and client certificates and the SHA-256 of the token, so that two environments can be checked to hold the same credentials without printing them. Use --json to print them as JSON.")
+	printCAJKS             = flags.Bool("print-ca-jks", false, "Instead of printing the kube config, print the CA certificates as a Java truststore (JKS) protected with the password read from the environment variable given with --password-env, so that JVM applications can trust the API server.")
+	passwordEnv            = flags.String("password-env", "", "The environment variable that holds the password of the PKCS#12 bundle printed by --print-client-p12 or of the truststore printed by --print-ca-jks.")
+	pemOrder               = flags.String("order", "key-first", "The order of the PEM blocks printed by --print-client-cert: 'key-first' or 'cert-first'. nginx's ssl_certificate and curl's --cert with a single file expect 'cert-first'.")
+	printCACert            = flags.Bool("print-ca-cert", false, "Instead of printing a kubeconfig, print the content of the kube config's certificate-authority-data.")
+	printNamespace         = flags.Bool("print-namespace", false, "Instead of printing a kube config, print the namespace of the generated kube config's context: the namespace of the pod when running in a pod, the namespace of the service account with --serviceaccount, --namespace if given, or the namespace of the current context. Defaults to 'default'.")
+	printOIDC              = flags.Bool("print-oidc", false, "Instead of printing a kube config, print the OIDC discovery document (/.well-known/openid-configuration) and the JWKS (/openid/v1/jwks) of the service account issuer as a JSON object with the keys 'openid-configuration' and 'jwks'. Useful to configure AWS IRSA, Vault or Dex to trust the cluster's service account tokens.")
+	debug                  = flags.BoolP("debug", "d", false, "Print debug logs. Same as --log-level=debug.")
+	verbose                = flags.BoolP("verbose", "v", false, "Same as --debug.")
+	quiet                  = flags.BoolP("quiet", "q", false, "Only print errors. Same as --log-level=error.")
+	logLevel               = flags.String("log-level", "info", "The lowest level of the logs printed to stderr: debug, info, warn or error.")
+	logFormat              = flags.String("log-format", "text", "The format of the logs printed to stderr: 'text', or 'json' for one JSON object per line with the fields 'time', 'level' and 'msg'. The colors of the text format are disabled when NO_COLOR is set or when stderr isn't a terminal.")
+	errorFormat            = flags.String("error-format", "text", "The format of the error printed to stderr when kubectl-incluster fails: 'text', or 'json' for a JSON object with the fields 'error', 'kind', 'reason' and 'exitCode'.")
+	output                 = flags.String("output", "", "Write the kube config to this file instead of stdout. The file is written atomically with the mode 0600.")
+	outputSecret           = flags.String("output-secret", "", "Write the kube config to the given Secret instead of stdout. The Secret is created or updated. The value is of the form '[namespace/]name[#key]'. The key defaults to 'kubeconfig' and the namespace to 'default'.")
+	watch                  = flags.Bool("watch", false, "Keep running after writing the kube config to --output or --output-secret, and write it again whenever the mounted ca.crt or the kube-root-ca.crt ConfigMap changes, e.g. when the cluster CA rotates, when the kubelet rotates the mounted token (or the one given with --token-file), or when the token or ca.crt of the Secret used with --serviceaccount or --from-secret changes. The old and new CA fingerprints are logged.")
+	watchInterval          = flags.Duration("watch-interval", 10*time.Second, "How often the mounted files are checked for changes with --watch.")
+	qps                    = flags.Float32("qps", 0, "The maximum number of requests per second made to the API server by kubectl-incluster itself (including the verify and whoami subcommands). Defaults to client-go's default (5). A negative value disables the client-side rate limiting. Also written as 'qps' with -o rest-config.")
+	burst                  = flags.Int("burst", 0, "The number of requests that can be made to the API server in a burst before --qps applies. Defaults to client-go's default (10), or to --qps when it is larger. Also written as 'burst' with -o rest-config.")
+	trace                  = flags.Bool("trace", false, "Log the method, URL, status and latency of every request made to the API server, e.g. by the proxy, verify and whoami subcommands.")
+	traceHeaders           = flags.Bool("trace-headers", false, "Same as --trace, and also log the request and response headers. The credentials in the Authorization and Cookie headers are redacted.")
+	otelEndpoint           = flags.String("otel-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "Send a span for every request made to the API server to this OTLP/HTTP collector, e.g. 'http://localhost:4318', so that they can be correlated with the spans of the API server or of the controller in your tracing backend. The spans are sent every 5 seconds and when kubectl-incluster exits. Defaults to $OTEL_EXPORTER_OTLP_ENDPOINT.")
+	expiryWarning          = flags.Duration("expiry-warning", 7*24*time.Hour, "Warn when the embedded client certificate or CA expires within this duration. Expired certificates are always warned about.")
+	textFlag               = flags.Bool("text", false, "With --print-ca-cert or --print-client-cert, print the subject, issuer, SANs, validity and SHA-256 fingerprint of each certificate instead of the PEM.")
+	jsonFlag               = flags.Bool("json", false, "With --print-ca-cert or --print-client-cert, print the subject, issuer, SANs, validity and SHA-256 fingerprint of each certificate as JSON instead of the PEM. With --fingerprint, print the fingerprints as JSON.")
+	server                 = flags.StringP("server", "s", "", "Skip the in-cluster and kube config detection and use this API server URL, e.g. 'https://10.0.0.1:6443'. Use it with --token (or --token-file) and --ca-file. With --root and without --token-file and --ca-file, the token and CA mounted under the root are used instead.")
+	tokenFile              = flags.String("token-file", "", "Path to the token file to use. Requires --server. Use '-' to read it from stdin.")
+	caFile                 = flags.String("ca-file", "", "Path to the CA certificate file to use. Requires --server. Use '-' to read it from stdin.")
+	caFromConfigMap        = flags.String("ca-from-configmap", "", "Fetch the CA from a ConfigMap using the Kubernetes API instead of using the mounted ca.crt or the kube config's CA. The value is of the form '[namespace/]name', e.g. 'kube-root-ca.crt' which exists in every namespace since Kubernetes 1.21. When the namespace is omitted, the pod's namespace is used, or 'default' when out-of-cluster. When no CA is available to verify the API server while fetching it, --insecure-skip-tls-verify is required.")
+	tokenMountName         = flags.String("token-mount", "", "Name or path of the service account token mount to use when in cluster, e.g. 'vault-token' or '/var/run/secrets/tokens/vault-token'. By default, /var/run/secrets/kubernetes.io/serviceaccount is used, and if it doesn't exist, the mounts listed in /proc/mounts are scanned for a token, i.e. a file named 'token' or a file that contains a JWT. A name that isn't found in /proc/mounts is looked up in /var/run/secrets/tokens.")
+	projectedToken         = flags.String("projected-token", "", "Same as --token-mount. Useful when the pod mounts several projected tokens, e.g. '--projected-token vault-token' for /var/run/secrets/tokens/vault-token.")
+
+	serviceaccount = flags.StringSlice("serviceaccount", nil, strings.ReplaceAll(
 		`Instead of using the current pod's /var/run/secrets (when in cluster)
 		or the local kubeconfig (when out-of-cluster), you can use this flag to
 		use the token and ca.crt from a given service account, for example
@@ -48,176 +102,538 @@ var (
 		token (only available using service accounts) over client certificates
 		provided in the kubeconfig, which is useful whenusing mitmproxy since
 		the token is passed as a header (HTTP) instead of a client certificate
-		(TLS).`, "\t", ""))
-	sa = flag.String("sa", "", "Shorthand for --serviceaccount.")
+		(TLS). Can be repeated or given as a comma-separated list together with
+		--output-dir to write one kube config per service account.`, "\t", ""))
+	sa                   = flags.StringSlice("sa", nil, "Shorthand for --serviceaccount.")
+	exportDir            = flags.String("export-dir", "", "Instead of printing the kube config, write the credential material as individual files to this directory: 'ca.crt', 'token' or 'client.crt' and 'client.key', 'server' and 'namespace'. The files are written with the mode 0600, and the ones that don't apply are removed. Useful for the tools that are configured with file paths.")
+	outputDir            = flags.String("output-dir", "", "Write one kube config per service account given with --serviceaccount to this directory, named 'namespace-name.kubeconfig'. The tokens are fetched concurrently.")
+	kubeconfigFromSecret = flags.String("kubeconfig-from-secret", "", "Use the kube config stored in the given Secret instead of the one given with --kubeconfig, for example the kube config of a Cluster API, vcluster or Rancher cluster. The value is of the form '[namespace/]name[#key]'. When the key is omitted, the keys 'value', 'config' and 'kubeconfig' are tried. The Secret is fetched from the cluster selected with --kubeconfig and --context; the other flags apply to the cluster of the kube config stored in the Secret.")
+	clusterAPI           = flags.String("cluster-api", "", "Use the kube config of the given Cluster API workload cluster, of the form '[namespace/]name'. It is read from the Secret '<name>-kubeconfig' created by the Cluster API providers, which means it is the same as '--kubeconfig-from-secret namespace/name-kubeconfig#value'. Use --serviceaccount to get the token of a service account of the workload cluster.")
+	vcluster             = flags.String("vcluster", "", "Use the kube config of the given vcluster, of the form '[namespace/]name', read from the Secret 'vc-<name>'. The server is replaced with the LoadBalancer or Ingress endpoint of the vcluster, or with its Service's DNS name when in cluster. Otherwise, a port-forward to the vcluster is established, and kubectl-incluster keeps running after printing the kube config until Ctrl-C is pressed.")
+	rancherServer        = flags.String("rancher-server", "", "Use the kube config of a cluster managed by Rancher, minted with the Rancher API at the given URL (e.g., 'https://rancher.example.com') like the 'Download KubeConfig' button of the Rancher UI does. Requires --rancher-token. The other flags apply to the Rancher-managed cluster.")
+	rancherToken         = flags.String("rancher-token", "", "The file containing the Rancher API key used with --rancher-server, of the form 'token-xxxxx:secret'. Use '-' to read it from stdin.")
+	rancherCluster       = flags.String("rancher-cluster", "", "The name or ID (e.g., 'c-m-abcd1234') of the Rancher-managed cluster used with --rancher-server. Can be omitted when the API key gives access to a single cluster.")
+	eks                  = flags.String("eks", "", "Use the kube config of the given EKS cluster, of the form 'name[@region]', like 'aws eks update-kubeconfig' does. The server and CA are found with 'aws eks describe-cluster', and the user runs 'aws eks get-token' as an exec plugin, or use --static-token. Requires the aws CLI.")
+	gke                  = flags.String("gke", "", "Use the kube config of the given GKE cluster, of the form 'project/location/cluster', like 'gcloud container clusters get-credentials' does. The server and CA are fetched with the GKE API using an access token minted by the metadata server when running on GCE or GKE (e.g., with workload identity), or by 'gcloud auth print-access-token' otherwise. The user runs gke-gcloud-auth-plugin as an exec plugin, or use --static-token.")
+	staticToken          = flags.Bool("static-token", false, "With --eks or --gke, resolve the token right away instead of writing an exec plugin stanza to the generated kube config. EKS tokens expire after 15 minutes, and GKE access tokens after an hour.")
+	allContexts          = flags.Bool("all-contexts", false, "Resolve every context of the kube config instead of only the current one, and print a single kube config with all of them embedded, named after the source contexts. The other flags (e.g., --force-token or --replace-ca-cert) apply to each context. Contexts that can't be resolved are skipped.")
+	minify               = flags.Bool("minify", false, "Name the context, cluster and user of the generated kube config after the ones selected in the source kube config (with --context, --cluster and --user) instead of 'kubectl-incluster', similarly to 'kubectl config view --minify --flatten'. Only works with a kube config.")
+	summary              = flags.Bool("summary", false, "Print a JSON summary of the generated kube config (server, auth type, identity decoded from the token or client certificate, token and certificate expiry, CA fingerprints and proxy) instead of the kube config, or in addition to it when --output or --output-secret is given. The schema is in summary.schema.json.")
+	redact               = flags.Bool("redact", false, "Replace the tokens, passwords, client keys and certificates of the printed kube config with placeholders that keep their structure (e.g., the header and claim names of a JWT, or the types of the PEM blocks), so that the kube config can be shared in a bug report without leaking credentials.")
+	interactive          = flags.Bool("interactive", false, "Pick the context from a list when using a kube config, or the namespace and service account when in cluster. The choices are printed to stderr and read from the terminal.")
+
+	dryRun       = flags.String("dry-run", "none", "With 'client', print the objects that would be created or updated (e.g., by --force-token, --create-secret, --output-secret or the bootstrap-token subcommand), followed by the kube config, as a multi-document YAML instead of creating them, so that the credential bundle can be reviewed or applied with GitOps. With 'server', the objects are also sent to the API server to be validated without being persisted. The kube config has no token when the token only exists once the objects are created, and isn't printed when it can't be generated without them (e.g., --from-node). One of 'none', 'client' or 'server'.")
+	createSecret = flags.Bool("create-secret", false, "When using --serviceaccount and the service account has no token Secret (the default since Kubernetes 1.24), create a Secret of type kubernetes.io/service-account-token for it instead of requesting a short-lived token. The Secret is reused on subsequent runs. Useful when you need a token that doesn't expire.")
+	bindTo       = flags.String("bind-to", "", "When using --serviceaccount, always request a token using the TokenRequest API and bind it to the given pod or Secret, of the form 'pod=[namespace/]name' or 'secret=[namespace/]name', so that the token stops being valid as soon as the object is deleted. The object must be in the namespace of the service account. Same as 'kubectl create token --bound-object-kind'.")
+
+	fromPod = flags.String("from-pod", "", "Use the token and ca.crt mounted in a running pod, for example 'namespace-1/pod-1' or 'namespace-1/pod-1/container-1'. The files are read using 'kubectl exec', which means the container image needs to have 'cat'.")
+
+	fromNode      = flags.String("from-node", "", "Use the kube config of the kubelet running on the given node, which authenticates as 'system:node:<node>'. Like 'kubectl debug node/<node>', a privileged pod that mounts the host's root filesystem is created on the node to read /etc/kubernetes/kubelet.conf (or the k3s and RKE2 equivalents) and the certificate files it refers to, and is deleted right after.")
+	fromNodeImage = flags.String("from-node-image", "busybox", "The image of the pod created on the node by --from-node. It needs to have 'cat' and 'sleep'.")
+
+	dockerContainer = flags.String("docker-container", "", "Use the token and ca.crt mounted in a local Docker container, for example when using kind or docker-compose. The files are read using 'docker exec'.")
+	criContainer    = flags.String("cri-container", "", "Same as --docker-container but for containerd and other CRI runtimes. The files are read using 'crictl exec', which means you need to run this on the node.")
+
+	useDNS  = flags.Bool("use-dns", false, "When in cluster, use the cluster DNS name 'kubernetes.default.svc' as the server instead of the IP given in KUBERNETES_SERVICE_HOST. Useful when the ClusterIP isn't reachable from where the kube config is used.")
+	hostEnv = flags.String("host-env", "KUBERNETES_SERVICE_HOST", "The name of the env var that holds the API server's host when in cluster. Useful when a custom injector or a service mesh renames or proxies KUBERNETES_SERVICE_HOST.")
+	portEnv = flags.String("port-env", "KUBERNETES_SERVICE_PORT", "The name of the env var that holds the API server's port when in cluster, see --host-env.")
+
+	serverOverride = flags.String("server-override", "", "Replace the server URL in the generated kube config, e.g. 'https://127.0.0.1:6443' when using a port-forward, while keeping the credentials. Unless --tls-server-name is given, the tls-server-name is set to the original host so that the certificate validation still passes.")
+	viaPortForward = flags.String("via-port-forward", "", "Establish a port-forward to the given pod or service, of the form '[namespace/][pod/|svc/]name:port', and use 'https://127.0.0.1:<local port>' as the server, for example to reach an API server or an aggregated API server only exposed inside the cluster. Unless --tls-server-name is given, the tls-server-name is set to the Service's DNS name, or to the original host for a pod. kubectl-incluster keeps running after printing the kube config until Ctrl-C is pressed.")
+	tlsServerName  = flags.String("tls-server-name", "", "The server name to use when validating the API server's certificate. It is written as 'tls-server-name' in the generated kube config.")
+
+	forHost = flags.Bool("for-host", false, "When the cluster is a kind or k3d cluster, replace the server (e.g., the ClusterIP when run from inside a kind node) with the port published by Docker on the host, so that the kube config works from the host machine.")
+
+	keepExec = flags.Bool("keep-exec", false, "Copy the exec or auth-provider configuration of the kube config's user to the generated kube config instead of dropping it. Without it, the generated kube config has no credentials when the user relies on an exec plugin (e.g., EKS or GKE).")
+
+	as       = flags.String("as", "", "Username to impersonate. It is written as 'as' in the generated kube config's user.")
+	asGroups = flags.StringArray("as-group", nil, "Group to impersonate. Can be repeated. It is written as 'as-groups' in the generated kube config's user.")
+	asUID    = flags.String("as-uid", "", "UID to impersonate. Not supported yet: the kube config field 'as-uid' requires a newer client-go.")
+
+	namespace = flags.StringP("namespace", "n", "", "The namespace to set in the generated kube config's context. By default, the namespace of the service account is used (i.e., the mounted 'namespace' file when in cluster), or the namespace of the kube config's context.")
+
+	forceToken            = flags.Bool("force-token", false, "When the credentials aren't a token (e.g., a client certificate), create or reuse the service account given with --force-token-serviceaccount, bind it to the ClusterRole given with --force-token-clusterrole, and use its token instead. Useful with mitmproxy since client certificates can't go through a proxy that inspects the HTTP traffic.")
+	forceTokenSA          = flags.String("force-token-serviceaccount", "kube-system/kubectl-incluster", "The service account created or reused by --force-token, of the form 'namespace/name'.")
+	forceTokenClusterRole = flags.String("force-token-clusterrole", "cluster-admin", "The ClusterRole the service account of --force-token is bound to.")
+	clientCertFromCSRUser = flags.String("client-cert-from-csr", "", "Generate a private key and get a client certificate for the given user issued with a CertificateSigningRequest using the 'kubernetes.io/kube-apiserver-client' signer, and use it instead of the current credentials. Use --group to set the user's groups. The CSR must be approved, either with --approve-csr or with 'kubectl certificate approve'.")
+	csrGroups             = flags.StringArray("group", nil, "A group of the user given with --client-cert-from-csr or --client-cert-from-cert-manager. Can be repeated.")
+	approveCSR            = flags.Bool("approve-csr", false, "Approve the CertificateSigningRequest created by --client-cert-from-csr right away. Requires the permission to update 'certificatesigningrequests/approval'.")
+	csrTimeout            = flags.Duration("csr-timeout", time.Minute, "How long to wait for the CertificateSigningRequest created by --client-cert-from-csr to be approved and issued, or for the Certificate created by --client-cert-from-cert-manager to be ready.")
+	fromCertManager       = flags.String("client-cert-from-cert-manager", "", "Create (or reuse) a cert-manager Certificate for a client identity, wait for it to be issued, and use the tls.crt and tls.key of its Secret as the client certificate. The value is of the form 'issuer=[namespace/]name,user=alice' or 'clusterissuer=name,user=alice'. Use --group to set the user's groups.")
+	clientCertFromSecret  = flags.String("client-cert-from-secret", "", "Use the tls.crt and tls.key of the given Secret of type kubernetes.io/tls as the client certificate, for example 'team-a/alice'. When the namespace is omitted, the current namespace is used.")
+	vaultLogin            = flags.String("vault-login", "", "Log into Vault using the Kubernetes auth method with the service account token and print the Vault token instead of the kube config. The value is of the form 'role=myrole[,addr=https://vault:8200][,mount=kubernetes][,kubeconfig=secret/data/path]'. With kubeconfig=path, the 'kubeconfig' field of the Vault secret at that path is printed instead of the Vault token. The address defaults to $VAULT_ADDR.")
+
+	openShift     = flags.Bool("openshift", false, "Use the OpenShift conventions: the context, cluster and user of the generated kube config are named like 'oc login' names them (e.g., 'default/api-crc-testing:6443/developer'). With --openshift-user, a token is requested from the OpenShift OAuth server. Fails when the cluster isn't OpenShift.")
+	openShiftUser = flags.String("openshift-user", "", "With --openshift, request a token for the given user from the OpenShift OAuth server, like 'oc login -u' does, and use it instead of the current credentials. The password is read from $OPENSHIFT_PASSWORD.")
+
+	validateToken = flags.Bool("validate-token", false, "Submit the resolved token to the TokenReview API and print the user it authenticates as, its audiences and its expiry to stderr before printing the kube config. Fails when the token is invalid or expired. Creating TokenReviews requires a permission such as the ClusterRole 'system:auth-delegator'; without it, only the expiry of the token is checked.")
+
+	fromSecret = flags.String("from-secret", "", "Use the token from the given Secret of type kubernetes.io/service-account-token, for example 'namespace-1/secret-1'. Unlike --serviceaccount, the ServiceAccount object isn't looked up, which is useful when its .secrets list is empty but a manually created token Secret exists.")
 )
 
-func main() {
-	flag.Parse()
+// overrides holds the standard kubectl flags --cluster, --user, --token,
+// --insecure-skip-tls-verify and --request-timeout. They have the same
+// semantics as in kubectl.
+var overrides = &clientcmd.ConfigOverrides{}
+
+func init() {
+	names := clientcmd.RecommendedConfigOverrideFlags("")
+	clientcmd.BindOverrideFlags(overrides, flags, clientcmd.ConfigOverrideFlags{
+		AuthOverrideFlags: clientcmd.AuthOverrideFlags{
+			Token: names.AuthOverrideFlags.Token,
+		},
+		ClusterOverrideFlags: clientcmd.ClusterOverrideFlags{
+			InsecureSkipTLSVerify: names.ClusterOverrideFlags.InsecureSkipTLSVerify,
+		},
+		ContextOverrideFlags: clientcmd.ContextOverrideFlags{
+			ClusterName:  names.ContextOverrideFlags.ClusterName,
+			AuthInfoName: names.ContextOverrideFlags.AuthInfoName,
+		},
+		Timeout: names.Timeout,
+	})
+
+	_ = flags.MarkDeprecated("embed", "it is now turned on by default")
+	_ = flags.MarkDeprecated("replace-cacert", "please use --replace-ca-cert instead")
+}
 
-	if *debug {
-		logutil.EnableDebug = true
+// configureLogging applies --debug, --verbose, --quiet, --log-level and
+// --log-format, and checks --error-format. The logs always go to stderr so
+// that stdout only contains the kube config.
+func configureLogging() error {
+	level, err := logutil.ParseLevel(*logLevel)
+	if err != nil {
+		return flagErrorf("--log-level: %w", err)
+	}
+	switch {
+	case *debug || *verbose:
+		level = logutil.LevelDebug
+	case *quiet:
+		level = logutil.LevelError
 	}
+	logutil.MinLevel = level
 
-	if *deprecated {
-		logutil.Infof("--embed is deprecated since it is now turned on by default")
+	switch *logFormat {
+	case "text":
+	case "json":
+		logutil.JSON = true
+	default:
+		return flagErrorf("--log-format: expected 'text' or 'json', got: %s", *logFormat)
 	}
 
-	if *replacecacertD != "" {
-		logutil.Infof("--replace-cacert is deprecated, please use --replace-ca-cert instead")
-		*replacecacert = *replacecacertD
+	if *errorFormat != "text" && *errorFormat != "json" {
+		return flagErrorf("--error-format: expected 'text' or 'json', got: %s", *errorFormat)
+	}
+	return nil
+}
+
+// defaultRequestTimeout is the timeout of the API calls made by
+// kubectl-incluster itself when --request-timeout isn't given. The generated
+// kube config doesn't have a timeout.
+const defaultRequestTimeout = 30 * time.Second
+
+func main() {
+	// The first SIGINT or SIGTERM cancels the API calls in flight. A second
+	// one kills the process since the default behavior is restored.
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		logutil.Infof("received %s, cancelling", sig)
+		cancel()
+	}()
+
+	cmd := newRootCmd()
+	cmd.SetArgs(legacyArgs(flags, os.Args[1:]))
+	err := cmd.ExecuteContext(ctx)
+	cancel()
+	flushOTelSpans()
+	// With --dry-run, some flows can't go as far as generating the kube
+	// config, only the objects they would have created are printed.
+	if errors.Is(err, errDryRunStop) {
+		err = printDryRunManifests(os.Stdout)
+	}
+	if err != nil {
+		code, _ := classifyError(err)
+		if *errorFormat == "json" {
+			printErrorJSON(os.Stderr, err)
+		} else {
+			logutil.Errorf("%s", err)
+		}
+		os.Exit(code)
 	}
+}
 
-	// Defaults to TELEPRESENCE_ROOT only if --root is not passed.
-	if os.Getenv("TELEPRESENCE_ROOT") != "" && *root == "" {
-		*root = os.Getenv("TELEPRESENCE_ROOT")
+// legacyArgs rewrites the long flags given with a single dash (e.g.,
+// '-kubeconfig') into their double-dash form. kubectl-incluster used to rely
+// on Go's flag package which accepts both, and we don't want to break the
+// scripts that still use the single-dash form.
+func legacyArgs(flags *pflag.FlagSet, args []string) []string {
+	var rewritten []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(rewritten, args[i:]...)
+		}
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			name := strings.SplitN(arg[1:], "=", 2)[0]
+			if len(name) > 1 && flags.Lookup(name) != nil {
+				arg = "-" + arg
+			}
+		}
+		rewritten = append(rewritten, arg)
 	}
+	return rewritten
+}
+
+// resolveConfig processes the flags and returns the rest config holding the
+// credentials to use and the namespace to set in the kube config's context.
+// When the proxy is given, the CA presented by mitmproxy replaces the
+// cluster's CA.
+func resolveConfig(ctx context.Context, proxy string) (c *rest.Config, ns string, _ error) {
+	c, ns, _, err := resolveConfigAndTokenSecret(ctx, proxy)
+	return c, ns, err
+}
 
-	proxy := os.Getenv("HTTPS_PROXY")
+// resolveConfigAndTokenSecret is like resolveConfig, and also returns the
+// Secret the token was read from with --serviceaccount, --from-secret or
+// --force-token, of the form 'namespace/name'. It is empty when the token
+// wasn't read from a Secret.
+func resolveConfigAndTokenSecret(ctx context.Context, proxy string) (c *rest.Config, ns, tokenSecret string, _ error) {
+	if *replacecacertD != "" {
+		*replacecacert = *replacecacertD
+	}
 
 	var proxyCACert string
 	var err error
+	if *replaceCAFromProxy && proxy == "" {
+		return nil, "", "", flagErrorf("--replace-ca-cert-from-proxy requires HTTPS_PROXY to be set")
+	}
 	if proxy != "" {
-		proxyCACert, err = fetchCACertFromMitmproxy(proxy)
-		if err != nil {
+		proxyCACert, err = fetchCACertFromMitmproxy(ctx, proxy)
+		if err == nil && *replaceCAFromProxy {
+			_, err = parseCertsPEM([]byte(proxyCACert))
+		}
+		switch {
+		case err != nil && *replaceCAFromProxy:
+			return nil, "", "", fmt.Errorf("while processing flag --replace-ca-cert-from-proxy: fetching the CA certificate from the proxy %s: %w", proxy, err)
+		case err != nil:
 			logutil.Debugf("fetching the CA certificate from mitmproxy: %s", err)
+			proxyCACert = ""
+		}
+	}
+
+	stdinFlags := 0
+	for _, f := range []string{*kubeconfig, *replacecacert, *tokenFile, *caFile} {
+		if f == "-" {
+			stdinFlags++
+		}
+	}
+	if stdinFlags > 1 {
+		return nil, "", "", flagErrorf("only one of --kubeconfig, --replace-ca-cert, --token-file and --ca-file can be '-' (stdin)")
+	}
+
+	replaceFlags := 0
+	for _, f := range []string{*replacecacert, *replaceCAFromURL, *replaceCAFromSecret, *replaceCAFromConfigMap} {
+		if f != "" {
+			replaceFlags++
 		}
 	}
+	if *tofuCA {
+		replaceFlags++
+	}
+	if *replaceCAFromProxy {
+		replaceFlags++
+	}
+	if replaceFlags > 1 {
+		return nil, "", "", flagErrorf("only one of --replace-ca-cert, --replace-ca-cert-from-url, --replace-ca-cert-from-secret, --replace-ca-cert-from-configmap, --replace-ca-cert-from-proxy and --tofu-ca can be given")
+	}
+	if *tofuCA && overrides.ClusterInfo.InsecureSkipTLSVerify {
+		return nil, "", "", flagErrorf("--tofu-ca and --insecure-skip-tls-verify can't be used together")
+	}
+	// kubectl refuses a kube config with both a CA and
+	// insecure-skip-tls-verify.
+	if replaceFlags > 0 && overrides.ClusterInfo.InsecureSkipTLSVerify {
+		return nil, "", "", flagErrorf("--replace-ca-cert, --replace-ca-cert-from-url, --replace-ca-cert-from-secret, --replace-ca-cert-from-configmap and --replace-ca-cert-from-proxy can't be used with --insecure-skip-tls-verify")
+	}
+
+	if *server == "" && (*tokenFile != "" || *caFile != "") {
+		return nil, "", "", flagErrorf("--token-file and --ca-file can only be used with --server")
+	}
 
-	c, err := RestConfig(*kubeconfig, *kubecontext, "kubectl-incluster")
+	c, err = loadConfig(ctx)
 	if err != nil {
-		logutil.Errorf("loading: %s", err)
-		os.Exit(1)
+		return nil, "", "", fmt.Errorf("loading: %w", err)
+	}
+	ns = contextNamespace()
+	if proxy != "" {
+		warnProxyChain(c, proxy)
 	}
 
 	// The flag --serviceaccount takes precedence over the --sa flag.
-	if *sa != "" && *serviceaccount == "" {
+	if len(*sa) > 0 && len(*serviceaccount) == 0 {
 		*serviceaccount = *sa
 	}
+	if len(*serviceaccount) > 1 {
+		return nil, "", "", flagErrorf("several service accounts given with --serviceaccount, use --output-dir to write one kube config per service account")
+	}
 
-	if *serviceaccount != "" {
-		// We don't use the above 'c' because 'c' is meant to be customized (the
-		// CA cert is changed, etc.). Here, we want the "unmodified" config so
-		// that we can connect to the Kubernetes API.
-		untouched, err := RestConfig(*kubeconfig, *kubecontext, "kubectl-incluster")
+	if len(*serviceaccount) == 1 {
+		untouched, err := apiConfig(ctx)
 		if err != nil {
-			logutil.Errorf("loading: %s", err)
-			os.Exit(1)
+			return nil, "", "", fmt.Errorf("loading: %w", err)
+		}
+
+		token, secretRef, err := getServiceAccount(ctx, untouched, (*serviceaccount)[0])
+		if err != nil {
+			return nil, "", "", fmt.Errorf("while processing flag --serviceaccount: %w", err)
+		}
+
+		useToken(c, token)
+		tokenSecret = secretRef
+		ns = strings.Split((*serviceaccount)[0], "/")[0]
+	}
+
+	if *fromSecret != "" {
+		if len(*serviceaccount) > 0 {
+			return nil, "", "", flagErrorf("--from-secret and --serviceaccount can't be used together")
 		}
 
-		// Chicken and egg: the whole purpose of kubectl incluster is to create
-		// a kubeconfig that will work when used for MITM proxying over the HTTP
-		// proxy protocol, i.e., when using HTTPS_PROXY and HTTP_PROXY. For
-		// that, kubectl incluster needs to talk to the Kubernetes API, which is
-		// impossible since HTTPS_PROXY is enabled but without the correct
-		// adjustments to the kubeconfig. So we disable HTTPS_PROXY here.
-		//
-		// We can't just 'os.Unsetenv("HTTPS_PROXY")' because the default
-		// http.Transport loads HTTPS_PROXY before this code runs.
-		untouched.Proxy = func(r *http.Request) (*url.URL, error) {
-			return nil, nil
+		untouched, err := apiConfig(ctx)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("loading: %w", err)
 		}
 
-		token, err := getServiceAccount(untouched)
+		token, secretRef, err := getTokenFromSecret(ctx, untouched, *fromSecret)
 		if err != nil {
-			logutil.Errorf("while processing flag --serviceaccount: %s", err)
-			os.Exit(1)
+			return nil, "", "", fmt.Errorf("while processing flag --from-secret: %w", err)
 		}
 
-		c.BearerToken = token
-		c.KeyData = nil
-		c.KeyFile = ""
-		c.CertData = nil
-		c.CertFile = ""
+		useToken(c, token)
+		tokenSecret = secretRef
+		ns = strings.Split(*fromSecret, "/")[0]
 	}
 
-	if proxy != "" {
-		// Now, let's check whether the proxy supports streaming. This check is
-		// performed because mitmproxy doesn't stream reponses by default, which
-		// blocks Kubernetes' watching mechanism.
-
-		// Create a temporary server that listens on a random port.
-		logutil.Debugf("creating a temporary server to test whether the proxy supports streaming")
-		srv := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-			logutil.Debugf("client connected, temporary server sending 'DONE'")
-			w.Write([]byte("DONE"))
-
-			if fl, ok := w.(http.Flusher); ok {
-				fl.Flush()
-			}
+	if *fromPod != "" {
+		if len(*serviceaccount) > 0 || *fromSecret != "" {
+			return nil, "", "", flagErrorf("--from-pod can't be used with --serviceaccount or --from-secret")
+		}
 
-			// Pretend that the server is streaming data.
-			time.Sleep(10 * time.Minute)
-		}))
-		l, err := net.Listen("tcp", ":0")
+		untouched, err := apiConfig(ctx)
 		if err != nil {
-			logutil.Errorf("creating a temporary server: %s", err)
-			os.Exit(1)
+			return nil, "", "", fmt.Errorf("loading: %w", err)
 		}
-		go func() {
-			_ = http.Serve(l, srv)
-		}()
 
-		// Create a temporary client that connects to the temporary server.
-		client := &http.Client{
-			Transport: &http.Transport{
-				Proxy: func(r *http.Request) (*url.URL, error) {
-					return url.Parse(proxy)
-				},
-			},
+		creds, err := getPodCredentials(untouched, *fromPod)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("while processing flag --from-pod: %w", err)
+		}
+
+		useToken(c, creds.Token)
+		c.TLSClientConfig.CAData = creds.CA
+		c.TLSClientConfig.CAFile = ""
+		ns = creds.Namespace
+	}
+
+	if *dockerContainer != "" || *criContainer != "" {
+		if len(*serviceaccount) > 0 || *fromSecret != "" || *fromPod != "" {
+			return nil, "", "", flagErrorf("--docker-container and --cri-container can't be used with --serviceaccount, --from-secret or --from-pod")
 		}
 
-		// The query parameter 'watch=true' is what I use in the mitmproxy
-		// script to enable response streaming.
-		req, err := http.NewRequest("GET", "http://"+l.Addr().String()+"?watch=true", nil)
+		var creds podCredentials
+		if *dockerContainer != "" {
+			creds, err = getDockerContainerCredentials(*dockerContainer)
+		} else {
+			creds, err = getCRIContainerCredentials(*criContainer)
+		}
 		if err != nil {
-			panic(err)
+			return nil, "", "", fmt.Errorf("while reading the credentials from the container: %w", err)
 		}
 
-		ctx, cancel := context.WithTimeout(req.Context(), 100*time.Millisecond)
-		defer cancel()
+		useToken(c, creds.Token)
+		c.TLSClientConfig.CAData = creds.CA
+		c.TLSClientConfig.CAFile = ""
+		ns = creds.Namespace
+	}
 
-		resp, err := client.Do(req.WithContext(ctx))
-		operr := &net.OpError{}
-		if errors.As(err, &operr) && operr.Op == "proxyconnect" {
-			logutil.Errorf("the env var HTTPS_PROXY is set to %q, but the proxy doesn't seem to be running: %s", proxy, operr.Err)
-			os.Exit(1)
-		}
-		if errors.Is(err, context.DeadlineExceeded) {
-			logutil.Errorf(strings.ReplaceAll(`the proxy does not supports streaming responses.
-				If you are using mitmproxy, you can enable streaming by using a custom script with the flag '-s':
-				    mitmproxy -s <(curl -L https://raw.githubusercontent.com/maelvls/kubectl-incluster/main/watch-stream.py)`, "\t", ""))
-			os.Exit(1)
+	if *forceToken {
+		if c.BearerToken != "" || c.BearerTokenFile != "" {
+			logutil.Debugf("--force-token: the credentials already are a token")
+		} else {
+			untouched, err := apiConfig(ctx)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("loading: %w", err)
+			}
+
+			token, secretRef, err := forceTokenServiceAccount(ctx, untouched, *forceTokenSA, *forceTokenClusterRole)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("while processing flag --force-token: %w", err)
+			}
+
+			useToken(c, token)
+			tokenSecret = secretRef
 		}
+	}
+
+	if *openShiftUser != "" && !*openShift {
+		return nil, "", "", flagErrorf("--openshift-user can only be used with --openshift")
+	}
+	if *openShift {
+		untouched, err := apiConfig(ctx)
 		if err != nil {
-			logutil.Errorf("checking whether proxy supports response streaming using a fake streaming server: %s", err)
-			os.Exit(1)
+			return nil, "", "", fmt.Errorf("loading: %w", err)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			logutil.Errorf("checking whether proxy supports response streaming using a fake streaming server: the fake server returned a non-200 status code: %d", resp.StatusCode)
-			os.Exit(1)
+		meta, err := openShiftOAuthMetadata(ctx, untouched)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("while processing flag --openshift: %w", err)
 		}
 
-		buf := make([]byte, 1024)
-		for {
-			d, err := resp.Body.Read(buf)
-			logutil.Debugf("checking whether proxy supports response streaming: read %d bytes from the temporary server: %s", d, string(buf))
-			if err == io.EOF {
-				break
+		if *openShiftUser != "" {
+			if len(*serviceaccount) > 0 || *fromSecret != "" || *forceToken {
+				return nil, "", "", flagErrorf("--openshift-user can't be used with --serviceaccount, --from-secret or --force-token")
 			}
+			password, err := openShiftPassword()
 			if err != nil {
-				logutil.Errorf("checking whether proxy supports response streaming: while reading the response body: %s", err)
-				os.Exit(1)
+				return nil, "", "", err
 			}
-			if bytes.Contains(buf, []byte("DONE")) {
-				logutil.Debugf("the proxy supports streaming responses")
-				break
+			token, err := openShiftLogin(ctx, untouched, meta, *openShiftUser, password)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("while processing flag --openshift-user: %w", err)
+			}
+
+			useToken(c, token)
+		}
+	}
+
+	if *clientCertFromCSRUser == "" && *approveCSR {
+		return nil, "", "", flagErrorf("--approve-csr can only be used with --client-cert-from-csr")
+	}
+	if *clientCertFromCSRUser == "" && *fromCertManager == "" && len(*csrGroups) > 0 {
+		return nil, "", "", flagErrorf("--group can only be used with --client-cert-from-csr or --client-cert-from-cert-manager")
+	}
+
+	if *clientCertFromCSRUser != "" {
+		if *forceToken {
+			return nil, "", "", flagErrorf("--client-cert-from-csr and --force-token can't be used together")
+		}
+
+		untouched, err := apiConfig(ctx)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("loading: %w", err)
+		}
+
+		cert, key, err := clientCertFromCSR(ctx, untouched, *clientCertFromCSRUser, *csrGroups, *approveCSR, *csrTimeout)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("while processing flag --client-cert-from-csr: %w", err)
+		}
+
+		useClientCert(c, cert, key)
+	}
+
+	if *fromCertManager != "" {
+		if *forceToken || *clientCertFromCSRUser != "" {
+			return nil, "", "", flagErrorf("--client-cert-from-cert-manager can't be used with --force-token or --client-cert-from-csr")
+		}
+
+		untouched, err := apiConfig(ctx)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("loading: %w", err)
+		}
+
+		cert, key, err := clientCertFromCertManager(ctx, untouched, *fromCertManager, ns, *csrGroups, *csrTimeout)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("while processing flag --client-cert-from-cert-manager: %w", err)
+		}
+
+		useClientCert(c, cert, key)
+	}
+
+	if *clientCertFromSecret != "" {
+		if *forceToken || *clientCertFromCSRUser != "" || *fromCertManager != "" {
+			return nil, "", "", flagErrorf("--client-cert-from-secret can't be used with --force-token, --client-cert-from-csr or --client-cert-from-cert-manager")
+		}
+
+		untouched, err := apiConfig(ctx)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("loading: %w", err)
+		}
+
+		cert, key, err := getClientCertFromSecret(ctx, untouched, *clientCertFromSecret, ns)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("while processing flag --client-cert-from-secret: %w", err)
+		}
+
+		useClientCert(c, cert, key)
+	}
+
+	if *namespace != "" {
+		ns = *namespace
+	}
+
+	if *caFromConfigMap != "" {
+		untouched, err := apiConfig(ctx)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("loading: %w", err)
+		}
+
+		ca, err := getCAFromConfigMap(ctx, untouched, *caFromConfigMap, ns)
+		switch {
+		case errors.Is(err, incluster.ErrNoCredentials):
+			return nil, "", "", fmt.Errorf("while processing flag --ca-from-configmap: %w, use --insecure-skip-tls-verify to fetch it anyway", err)
+		case err != nil:
+			return nil, "", "", fmt.Errorf("while processing flag --ca-from-configmap: %w", err)
+		}
+
+		// --insecure-skip-tls-verify was only needed to fetch the CA, and
+		// kubectl refuses a kube config with both.
+		c.TLSClientConfig.CAData = ca
+		c.TLSClientConfig.CAFile = ""
+		c.TLSClientConfig.Insecure = false
+	}
+
+	if proxy != "" {
+		if err := checkProxyStreaming(proxy); err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	if *asUID != "" {
+		return nil, "", "", flagErrorf("--as-uid isn't supported yet since the client-go version used by kubectl-incluster doesn't know about the kube config field 'as-uid'")
+	}
+	if *as != "" {
+		c.Impersonate.UserName = *as
+	}
+	if len(*asGroups) > 0 {
+		c.Impersonate.Groups = *asGroups
+	}
+
+	if *forHost {
+		if *serverOverride != "" {
+			return nil, "", "", flagErrorf("--for-host and --server-override can't be used together")
+		}
+
+		host, err := forHostServer()
+		if err != nil {
+			return nil, "", "", fmt.Errorf("while processing flag --for-host: %w", err)
+		}
+		logutil.Debugf("replacing the server %s with %s", c.Host, host)
+		c.Host = host
+	}
+
+	if *serverOverride != "" {
+		if c.TLSClientConfig.ServerName == "" {
+			original, err := url.Parse(c.Host)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("parsing the server URL %q: %w", c.Host, err)
 			}
+			c.TLSClientConfig.ServerName = original.Hostname()
 		}
-		l.Close()
-		resp.Body.Close()
+		logutil.Debugf("replacing the server %s with %s", c.Host, *serverOverride)
+		c.Host = *serverOverride
+	}
+	if *tlsServerName != "" {
+		c.TLSClientConfig.ServerName = *tlsServerName
 	}
 
 	// Go skips the HTTPS_PROXY env var if the host is a localhost address
@@ -228,11 +644,10 @@ func main() {
 
 		addrs, err := hostsfile.ReverseLookup("127.0.0.1")
 		if err != nil {
-			logutil.Infof(strings.ReplaceAll(
+			return nil, "", "", fmt.Errorf(strings.ReplaceAll(
 				`while trying to figure out whether you will have a problem with
 				Go ignoring HTTPS_PROXY when the host is "127.0.0.1" or "localhost",
-				we encountered an error while reading /etc/hosts: %s.`, "\t", ""), err)
-			os.Exit(1)
+				we encountered an error while reading /etc/hosts: %w.`, "\t", ""), err)
 		}
 		logutil.Debugf("aliases found for 127.0.0.1: %s", addrs)
 
@@ -245,11 +660,11 @@ func main() {
 		}
 
 		if alias == "" {
-			logutil.Infof(strings.ReplaceAll(
+			logutil.Warnf("%s", strings.ReplaceAll(
 				`no 127.0.0.1 alias found in /etc/hosts other than "localhost". If
 				you run a Go program which tries to dial "127.0.0.1" or "localhost", Go
 				will ignore the HTTPS_PROXY env var.
-				
+
 				To fix this issue, run the following command:
 				    sudo tee -a /etc/hosts <<<"127.0.0.1 me"`, "\t", ""))
 		}
@@ -263,308 +678,1263 @@ func main() {
 		c.TLSClientConfig.CAData = []byte(proxyCACert)
 	}
 
-	switch {
-	case *printClientCert:
-		pem, err := clientCertPEMFromRestConfig(c)
-		if err != nil {
-			logutil.Errorf("building the PEM bundle with the client-certificate-data and client-key-data: %s", err)
-			os.Exit(1)
-		}
-		fmt.Printf("%s", pem)
-	case *printCACert:
-		pem, err := caCertPEMFromRestConfig(c)
-		if err != nil {
-			logutil.Errorf("building the PEM bundle with the ca-certificate-data: %s", err)
-			os.Exit(1)
+	if *replaceCAFromURL != "" || *replaceCAFromSecret != "" || *replaceCAFromConfigMap != "" {
+		var ca []byte
+		switch {
+		case *replaceCAFromURL != "":
+			ca, err = getCAFromURL(ctx, *replaceCAFromURL)
+		case *replaceCAFromSecret != "":
+			var untouched *rest.Config
+			untouched, err = apiConfig(ctx)
+			if err == nil {
+				ca, err = getCAFromSecret(ctx, untouched, *replaceCAFromSecret, ns)
+			}
+		default:
+			var untouched *rest.Config
+			untouched, err = apiConfig(ctx)
+			if err == nil {
+				ca, err = getCAFromConfigMap(ctx, untouched, *replaceCAFromConfigMap, ns)
+			}
 		}
-		fmt.Printf("%s", pem)
-	default:
-		kubeconfig, err := kubeconfigFromRestConfig(c, *replacecacert, proxyCACert)
 		if err != nil {
-			logutil.Errorf("building the kubeconfig: %s", err)
-			os.Exit(1)
+			return nil, "", "", fmt.Errorf("fetching the replacement CA: %w", err)
 		}
 
-		err = clientcmd.WriteToFile(*kubeconfig, "/dev/stdout")
-		if err != nil {
-			logutil.Errorf("writing: %s", err)
-			os.Exit(1)
+		// The kube config's cluster may skip the TLS verification, which
+		// kubectl refuses along with a CA.
+		if c.TLSClientConfig.Insecure {
+			logutil.Infof("the kube config's insecure-skip-tls-verify is dropped since the CA is replaced")
 		}
+		c.TLSClientConfig.CAData = ca
+		c.TLSClientConfig.CAFile = ""
+		c.TLSClientConfig.Insecure = false
 	}
-}
 
-func fetchCACertFromMitmproxy(proxy string) (pem string, _ error) {
-	proxyURL, _ := url.Parse(proxy)
-	client := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyURL(proxyURL),
-		},
-	}
-	resp, err := client.Get("http://mitm.it/cert/pem")
-	if err != nil {
-		return "", fmt.Errorf("while trying to fetch the CA cert at GET mitm.it/cert/pem: %s", err)
-	}
-	defer resp.Body.Close()
+	if *tofuCA {
+		ca, err := trustOnFirstUse(ctx, c.Host, c.TLSClientConfig.ServerName)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("while processing flag --tofu-ca: %w", err)
+		}
 
-	if resp.Header.Get("Content-Type") != "application/x-x509-ca-cert" {
-		logutil.Errorf("unexpected content type of GET mitm.it/cert/pem: %s", resp.Header.Get("Content-Type"))
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("while reading the body of GET mitm.it/cert/pem: %s", err)
+		c.TLSClientConfig.CAData = ca
+		c.TLSClientConfig.CAFile = ""
 	}
 
-	return string(body), nil
+	return c, ns, tokenSecret, nil
 }
 
-func getServiceAccount(c *rest.Config) (token string, _ error) {
-	splits := strings.Split(*serviceaccount, "/")
-	if len(splits) != 2 {
-		return "", fmt.Errorf("--serviceaccount: expected value of the form 'namespace/serviceaccount', got: %s", *serviceaccount)
-	}
-
-	namespace := splits[0]
-	name := splits[1]
+// resolveKubeconfig returns the kube config that kubectl-incluster prints,
+// built out of the flags. The proxy may be empty.
+func resolveKubeconfig(ctx context.Context, proxy string) (*clientcmdapi.Config, error) {
+	kubeconfig, _, err := resolveKubeconfigAndTokenSecret(ctx, proxy)
+	return kubeconfig, err
+}
 
-	cl, err := kubernetes.NewForConfig(c)
+// resolveKubeconfigAndTokenSecret is like resolveKubeconfig, and also returns
+// the Secret the token was read from (see resolveConfigAndTokenSecret).
+func resolveKubeconfigAndTokenSecret(ctx context.Context, proxy string) (_ *clientcmdapi.Config, tokenSecret string, _ error) {
+	c, ns, tokenSecret, err := resolveConfigAndTokenSecret(ctx, proxy)
 	if err != nil {
-		return "", fmt.Errorf("while processing flag --serviceaccount: creating Kubernetes client: %s", err)
+		return nil, "", err
+	}
+	if *validateToken {
+		if err := reviewToken(ctx, c); err != nil {
+			return nil, "", err
+		}
 	}
 
-	serviceaccount, err := cl.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	kubeconfig, err := kubeconfigFromConfig(ctx, c, ns)
 	if err != nil {
-		return "", fmt.Errorf("getting serviceaccount %s in namespace %s: %v", name, namespace, err)
+		return nil, "", err
 	}
+	return kubeconfig, tokenSecret, nil
+}
 
-	// By default, we try to use the default service account token. Since
-	// Kubernetes 1.20, the default service account token is not created, so we
-	// try to generate a token instead.
-	if len(serviceaccount.Secrets) < 1 {
-		logutil.Debugf("serviceaccount %s has no default service account secret, now trying to generate a token", serviceaccount.GetName())
-		token, err := cl.CoreV1().ServiceAccounts(namespace).CreateToken(context.TODO(), name, &authenticationv1.TokenRequest{}, metav1.CreateOptions{})
-		if err != nil {
-			return "", fmt.Errorf("failed to generate a token for serviceaccount %s in namespace %s: %v", name, namespace, err)
+// kubeconfigFromConfig builds the kube config out of the resolved rest config
+// and namespace.
+func kubeconfigFromConfig(ctx context.Context, c *rest.Config, ns string) (*clientcmdapi.Config, error) {
+	var err error
+	opts := incluster.KubeconfigOptions{
+		Namespace: ns,
+		KeepExec:  *keepExec,
+		NoEmbed:   *noEmbed,
+	}
+	if *stripRoot {
+		if !*noEmbed {
+			return nil, flagErrorf("--strip-root can only be used with --no-embed")
 		}
-		return token.Status.Token, nil
+		opts.StripRoot = *root
 	}
-
-	var secret *v1.Secret
-	for _, secretRef := range serviceaccount.Secrets {
-		secret, err = cl.CoreV1().Secrets(namespace).Get(context.TODO(), secretRef.Name, metav1.GetOptions{})
+	if (*noEmbed || *forInCluster) && outputFormat != "" && outputFormat != "kubeconfig" && outputFormat != "sops" && outputFormat != "go" {
+		return nil, flagErrorf("--no-embed and --for-in-cluster only work with --output-format=kubeconfig, --output-format=sops and --output-format=go")
+	}
+	if *replacecacert != "" {
+		opts.CAData, err = readFile(*replacecacert)
 		if err != nil {
-			return "", fmt.Errorf("failed to get the secret %s in namespace %s: %v", secretRef.Name, namespace, err)
-		}
-
-		if secret.Type == v1.SecretTypeServiceAccountToken {
-			break
+			return nil, fmt.Errorf("building the kubeconfig: reading CA file: %w", err)
 		}
 	}
 
-	if secret == nil {
-		return "", fmt.Errorf("serviceaccount %s has no secret type %s", name, v1.SecretTypeServiceAccountToken)
+	if !*keepExec && (c.ExecProvider != nil || c.AuthProvider != nil) {
+		logutil.Warnf("the kube config's user relies on an exec plugin or an auth provider which won't be part of the generated kube config, use --keep-exec to keep it")
 	}
 
-	tokenBytes, ok := secret.Data["token"]
-	if !ok {
-		return "", fmt.Errorf("key 'token' not found in %s", secret.GetName())
+	if c.TLSClientConfig.Insecure {
+		logutil.Warnf("the generated kube config skips the verification of the API server's certificate (insecure-skip-tls-verify), only use it for debugging")
 	}
 
-	return string(tokenBytes), nil
-}
-
-// The PEM-encoded private key is displayed first.
-func clientCertPEMFromRestConfig(restconf *rest.Config) ([]byte, error) {
-	var clientPEM []byte
-
-	if restconf.TLSClientConfig.KeyFile != "" {
-		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.KeyFile)
-		if err != nil {
-			return nil, fmt.Errorf("reading client key file: %w", err)
+	kubeconfig, err := incluster.KubeconfigFromRestConfig(ctx, c, opts)
+	if err != nil {
+		return nil, fmt.Errorf("building the kubeconfig: %w", err)
+	}
+	if *minify {
+		if err := minifyNames(kubeconfig); err != nil {
+			return nil, fmt.Errorf("while processing flag --minify: %w", err)
 		}
-
-		clientPEM = append(clientPEM, bytes...)
-	} else if len(restconf.TLSClientConfig.KeyData) > 0 {
-		clientPEM = append(clientPEM, restconf.TLSClientConfig.KeyData...)
-	} else if restconf.BearerTokenFile != "" {
-		return nil, fmt.Errorf("cannot produce a PEM client certificate bundle when the kube config uses a token")
 	}
+	if *openShift {
+		if *minify {
+			return nil, flagErrorf("--openshift and --minify can't be used together")
+		}
 
-	if len(restconf.TLSClientConfig.CertData) > 0 {
-		clientPEM = append(clientPEM, restconf.TLSClientConfig.CertData...)
-	} else if restconf.TLSClientConfig.CertFile != "" {
-		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.CertFile)
+		// The generated kube config may not work from here (e.g., with
+		// mitmproxy's CA), which is why the untouched transport is used.
+		untouched := rest.CopyConfig(c)
+		untouched.Proxy = func(*http.Request) (*url.URL, error) { return nil, nil }
+		if untouched.Timeout == 0 {
+			untouched.Timeout = defaultRequestTimeout
+		}
+		username, err := openShiftUsername(ctx, untouched)
 		if err != nil {
-			return nil, fmt.Errorf("reading client certificate file: %w", err)
+			return nil, fmt.Errorf("while processing flag --openshift: %w", err)
+		}
+		if err := openShiftNames(kubeconfig, username); err != nil {
+			return nil, fmt.Errorf("while processing flag --openshift: %w", err)
 		}
-
-		clientPEM = append(clientPEM, bytes...)
 	}
-
-	return clientPEM, nil
-}
-
-func caCertPEMFromRestConfig(restconf *rest.Config) ([]byte, error) {
-	if len(restconf.TLSClientConfig.CAData) > 0 {
-		return restconf.TLSClientConfig.CAData, nil
-	} else if restconf.TLSClientConfig.CAFile != "" {
-		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.CAFile)
-		if err != nil {
-			return nil, fmt.Errorf("reading client certificate file: %w", err)
+	if *forInCluster {
+		if err := forInClusterPaths(kubeconfig); err != nil {
+			return nil, fmt.Errorf("while processing flag --for-in-cluster: %w", err)
 		}
-
-		return bytes, nil
 	}
+	warnExpiry(kubeconfig, *expiryWarning)
 
-	return nil, fmt.Errorf("no ca-certificate-data nor ca-certificate-file")
+	return kubeconfig, nil
 }
 
-// When embed is true, the ca certificate and token are embedded in the
-// kube config as a base64 string. Otherwise, the paths to the token and to
-// the ca file are used in the kube config.
-// https://github.com/kubernetes/client-go/issues/711
-func kubeconfigFromRestConfig(restconf *rest.Config, replaceCACertFile, replaceCAData string) (*clientcmdapi.Config, error) {
-	apiconf := clientcmdapi.NewConfig()
+// checkProxyStreaming checks whether the proxy supports streaming. This check
+// is performed because mitmproxy doesn't stream reponses by default, which
+// blocks Kubernetes' watching mechanism.
+func checkProxyStreaming(proxy string) error {
+	// Create a temporary server that listens on a random port.
+	logutil.Debugf("creating a temporary server to test whether the proxy supports streaming")
+	srv := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		logutil.Debugf("client connected, temporary server sending 'DONE'")
+		w.Write([]byte("DONE"))
+
+		if fl, ok := w.(http.Flusher); ok {
+			fl.Flush()
+		}
 
-	apiconf.Clusters["kubectl-incluster"] = &clientcmdapi.Cluster{
-		Server: restconf.Host,
+		// Pretend that the server is streaming data.
+		time.Sleep(10 * time.Minute)
+	}))
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return fmt.Errorf("creating a temporary server: %w", err)
 	}
+	defer l.Close()
+	go func() {
+		_ = http.Serve(l, srv)
+	}()
 
-	apiconf.Clusters["kubectl-incluster"].CertificateAuthorityData = restconf.TLSClientConfig.CAData
-	if replaceCACertFile != "" {
-		restconf.TLSClientConfig.CAFile = replaceCACertFile
+	// Create a temporary client that connects to the temporary server.
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return url.Parse(proxy)
+			},
+		},
 	}
-	if restconf.TLSClientConfig.CAFile != "" {
-		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.CAFile)
-		if err != nil {
-			return nil, fmt.Errorf("reading CA file: %w", err)
-		}
-		apiconf.Clusters["kubectl-incluster"].CertificateAuthorityData = bytes
+
+	// The query parameter 'watch=true' is what I use in the mitmproxy
+	// script to enable response streaming.
+	req, err := http.NewRequest("GET", "http://"+l.Addr().String()+"?watch=true", nil)
+	if err != nil {
+		panic(err)
 	}
 
-	apiconf.AuthInfos["kubectl-incluster"] = &clientcmdapi.AuthInfo{}
+	ctx, cancel := context.WithTimeout(req.Context(), 100*time.Millisecond)
+	defer cancel()
 
-	apiconf.AuthInfos["kubectl-incluster"].ClientCertificateData = restconf.TLSClientConfig.CertData
-	if restconf.TLSClientConfig.CertFile != "" {
-		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.CertFile)
-		if err != nil {
-			return nil, fmt.Errorf("reading client certificate file: %w", err)
-		}
-		apiconf.AuthInfos["kubectl-incluster"].ClientCertificateData = bytes
+	resp, err := client.Do(req.WithContext(ctx))
+	operr := &net.OpError{}
+	if errors.As(err, &operr) && operr.Op == "proxyconnect" {
+		return fmt.Errorf("the proxy is set to %q, but the proxy doesn't seem to be running: %w", proxy, operr.Err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errors.New(strings.ReplaceAll(`the proxy does not supports streaming responses.
+			If you are using mitmproxy, you can enable streaming by using a custom script with the flag '-s':
+			    mitmproxy -s <(curl -L https://raw.githubusercontent.com/maelvls/kubectl-incluster/main/watch-stream.py)`, "\t", ""))
 	}
+	if err != nil {
+		return fmt.Errorf("checking whether proxy supports response streaming using a fake streaming server: %w", err)
+	}
+	defer resp.Body.Close()
 
-	apiconf.AuthInfos["kubectl-incluster"].ClientKeyData = restconf.TLSClientConfig.KeyData
-	if restconf.TLSClientConfig.KeyFile != "" {
-		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.KeyFile)
-		if err != nil {
-			return nil, fmt.Errorf("reading client key file: %w", err)
-		}
-		apiconf.AuthInfos["kubectl-incluster"].ClientKeyData = bytes
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("checking whether proxy supports response streaming using a fake streaming server: the fake server returned a non-200 status code: %d", resp.StatusCode)
 	}
 
-	apiconf.AuthInfos["kubectl-incluster"].Token = restconf.BearerToken
-	if restconf.BearerTokenFile != "" {
-		bytes, err := ioutil.ReadFile(restconf.BearerTokenFile)
+	buf := make([]byte, 1024)
+	for {
+		d, err := resp.Body.Read(buf)
+		logutil.Debugf("checking whether proxy supports response streaming: read %d bytes from the temporary server: %s", d, string(buf))
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return nil, fmt.Errorf("reading token file: %w", err)
+			return fmt.Errorf("checking whether proxy supports response streaming: while reading the response body: %w", err)
+		}
+		if bytes.Contains(buf, []byte("DONE")) {
+			logutil.Debugf("the proxy supports streaming responses")
+			break
 		}
-
-		apiconf.AuthInfos["kubectl-incluster"].Token = string(bytes)
 	}
 
-	apiconf.CurrentContext = "kubectl-incluster"
-	apiconf.Contexts["kubectl-incluster"] = clientcmdapi.NewContext()
-	apiconf.Contexts["kubectl-incluster"].Cluster = "kubectl-incluster"
-	apiconf.Contexts["kubectl-incluster"].AuthInfo = "kubectl-incluster"
-
-	return apiconf, nil
+	return nil
 }
 
-// RestConfig creates a clientset by first trying to find the in-cluster config
-// (i.e., in a Kubernetes pod). Otherwise, it loads the kube config from the
-// given kubeconfig path. If the kubeconfig variable if left empty, the kube
-// config will be loaded from $KUBECONFIG or by default ~/.kube/config.
-//
-// The context is useful for selecting which entry of the kube config you want
-// to use. If context is left empty, the default context of the kube config is
-// used.
-//
-// The userAgent can be for example "controller/v0.1.4/0848c95".
-func RestConfig(kubeconfig, kubecontext, userAgent string) (*rest.Config, error) {
-	var cfg *rest.Config
-	var err error
-
-	if kubeconfig != "" {
-		logutil.Debugf("using you local kube config since --kubeconfig was passed")
-		cfg, err = outClusterConfig(kubeconfig, kubecontext)
-		if err != nil {
-			return nil, fmt.Errorf("error loading kube config: %w", err)
+// writeKubeconfigOutput writes the kube config (or the format given with
+// --output-format) to the file given with --output and the kube
+// config to the Secret given with --output-secret, or to stdout when neither
+// is given.
+func writeKubeconfigOutput(ctx context.Context, kubeconfig *clientcmdapi.Config) error {
+	if *outputSecret != "" {
+		if *redact {
+			return flagErrorf("--redact can't be used with --output-secret")
 		}
-	}
-
-	cfg, err = InClusterConfig()
-	if err != nil {
-		logutil.Debugf("in-cluster config was not found, now trying with your local kube config")
-		cfg, err = outClusterConfig("", kubecontext)
-		if err != nil {
-			return nil, fmt.Errorf("error loading kube config: %w", err)
+		if err := writeKubeconfigSecret(ctx, kubeconfig, *outputSecret); err != nil {
+			return fmt.Errorf("while processing flag --output-secret: %w", err)
+		}
+		// With --dry-run, the Secret is printed instead.
+		if *output == "" && len(dryRunObjects) > 0 {
+			return printDryRunManifests(os.Stdout)
+		}
+		if *output == "" {
+			return nil
 		}
-	} else {
-		logutil.Debugf("in-cluster config found")
 	}
 
-	cfg.UserAgent = userAgent
-
-	return cfg, nil
-}
-
-func outClusterConfig(kubeconfig, kubecontext string) (*rest.Config, error) {
-	loadRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	loadRules.ExplicitPath = kubeconfig
-
-	apicfg, err := loadRules.Load()
+	content, err := formatKubeconfig(kubeconfig, *output)
 	if err != nil {
-		return nil, fmt.Errorf("error loading kubeconfig: %v", err)
+		return err
 	}
 
-	if kubecontext == "" && apicfg.CurrentContext == "" {
-		return nil, fmt.Errorf("no context was provided and no current context was found in the kubeconfig")
+	// We don't use clientcmd.WriteToFile with /dev/stdout since /dev/stdout
+	// doesn't exist on Windows.
+	if *output != "" {
+		err = writeKubeconfigFile(content, *output)
+	} else {
+		_, err = os.Stdout.Write(content)
+	}
+	if err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+	return nil
+}
+
+// formatKubeconfig serializes the kube config in the format given with
+// --output-format, redacted with --redact. The filename is where the output
+// is written, or empty for stdout.
+func formatKubeconfig(kubeconfig *clientcmdapi.Config, filename string) ([]byte, error) {
+	if *redact {
+		kubeconfig = redactKubeconfig(kubeconfig)
+	}
+	if outputTemplate != "" && outputFormat != "go-template" {
+		return nil, flagErrorf("--template requires -o go-template")
+	}
+	if len(outputRegistries) > 0 && outputFormat != "dockerconfig" {
+		return nil, flagErrorf("--registry requires -o dockerconfig")
 	}
 
-	return clientcmd.NewDefaultClientConfig(*apicfg, &clientcmd.ConfigOverrides{
-		CurrentContext: kubecontext,
-	}).ClientConfig()
+	var content []byte
+	var err error
+	switch outputFormat {
+	case "", "kubeconfig":
+		content, err = clientcmd.Write(*kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("serializing the kube config: %w", err)
+		}
+		if len(dryRunObjects) > 0 {
+			content, err = withDryRunObjects(content)
+		}
+	case "argocd":
+		content, err = argoCDClusterSecret(kubeconfig)
+	case "terraform":
+		content, err = terraformProviders(kubeconfig)
+	case "rest-config":
+		content, err = restConfigOutput(kubeconfig)
+	case "sops":
+		content, err = clientcmd.Write(*kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("serializing the kube config: %w", err)
+		}
+		content, err = sopsEncrypt(content, filename)
+	case "tarball":
+		content, err = kubeconfigTarball(kubeconfig)
+	case "go-template":
+		content, err = templateOutput(kubeconfig, outputTemplate)
+	case "dockerconfig":
+		content, err = dockerConfig(kubeconfig, outputRegistries)
+	case "go":
+		content, err = goSnippet(kubeconfig)
+	default:
+		return nil, flagErrorf("--output-format: expected one of %s, got: %s", strings.Join(outputFormats, ", "), outputFormat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while processing flag --output-format: %w", err)
+	}
+	if len(dryRunObjects) > 0 && outputFormat != "" && outputFormat != "kubeconfig" {
+		return nil, flagErrorf("--dry-run only works with --output-format=kubeconfig")
+	}
+	return content, nil
 }
 
-// InClusterConfig is the vendored version of rest.InClusterConfig:
-// https://github.com/kubernetes/client-go/blob/fb61a7c/rest/config.go
-func InClusterConfig() (*rest.Config, error) {
-	var (
-		tokenFile  = *root + "/var/run/secrets/kubernetes.io/serviceaccount/token"
-		rootCAFile = *root + "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
-	)
-	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
-	if len(host) == 0 || len(port) == 0 {
-		return nil, rest.ErrNotInCluster
+// currentClusterAndUser returns the cluster and user of the kube config's
+// current context.
+func currentClusterAndUser(kubeconfig *clientcmdapi.Config) (*clientcmdapi.Cluster, *clientcmdapi.AuthInfo, error) {
+	ctx, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if !ok {
+		return nil, nil, fmt.Errorf("the kube config has no current context")
 	}
+	cluster, ok := kubeconfig.Clusters[ctx.Cluster]
+	if !ok {
+		return nil, nil, fmt.Errorf("the cluster %s doesn't exist in the kube config", ctx.Cluster)
+	}
+	user, ok := kubeconfig.AuthInfos[ctx.AuthInfo]
+	if !ok {
+		return nil, nil, fmt.Errorf("the user %s doesn't exist in the kube config", ctx.AuthInfo)
+	}
+	return cluster, user, nil
+}
 
-	token, err := ioutil.ReadFile(tokenFile)
+// userToken returns the token of the user, reading the token file when the
+// token isn't embedded. It returns an empty string when the user has no
+// token.
+func userToken(user *clientcmdapi.AuthInfo) (string, error) {
+	if user.Token != "" || user.TokenFile == "" {
+		return user.Token, nil
+	}
+	data, err := ioutil.ReadFile(user.TokenFile)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("reading the token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeKubeconfigSecret creates or updates the given Secret with the kube
+// config. The ref is of the form '[namespace/]name[#key]'. The other keys of
+// an existing Secret are left untouched, which means the Secret can be, for
+// example, an Argo CD cluster Secret or a CI runner's Secret.
+func writeKubeconfigSecret(ctx context.Context, kubeconfig *clientcmdapi.Config, ref string) error {
+	namespace, name, key, err := parseObjectRef(ref, "", "kubeconfig")
+	if err != nil {
+		return err
+	}
+
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return fmt.Errorf("serializing the kube config: %w", err)
+	}
+
+	c, err := apiConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	secret, err := cl.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		secret = &v1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "kubectl-incluster"},
+			},
+			Data: map[string][]byte{key: content},
+		}
+		if !dryRunClient() {
+			_, err = cl.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{DryRun: dryRunOptions()})
+			if err != nil {
+				return fmt.Errorf("creating secret %s in namespace %s: %w", name, namespace, err)
+			}
+		}
+		if dryRunEnabled() {
+			recordDryRun(secret, "secret "+namespace+"/"+name)
+			return nil
+		}
+		logutil.Infof("wrote the kube config to the key '%s' of the new secret %s/%s", key, namespace, name)
+	case err != nil:
+		return fmt.Errorf("getting secret %s in namespace %s: %w", name, namespace, err)
+	default:
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[key] = content
+		if !dryRunClient() {
+			_, err = cl.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{DryRun: dryRunOptions()})
+			if err != nil {
+				return fmt.Errorf("updating secret %s in namespace %s: %w", name, namespace, err)
+			}
+		}
+		if dryRunEnabled() {
+			recordDryRun(secretManifest(secret), "secret "+namespace+"/"+name)
+			return nil
+		}
+		logutil.Infof("wrote the kube config to the key '%s' of the existing secret %s/%s", key, namespace, name)
 	}
 
-	tlsClientConfig := rest.TLSClientConfig{}
+	return nil
+}
+
+// secretManifest returns the Secret without the fields set by the API
+// server, so that it can be applied.
+func secretManifest(secret *v1.Secret) *v1.Secret {
+	return &v1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        secret.Name,
+			Namespace:   secret.Namespace,
+			Labels:      secret.Labels,
+			Annotations: secret.Annotations,
+		},
+		Type: secret.Type,
+		Data: secret.Data,
+	}
+}
+
+// writeKubeconfigFile writes the kube config atomically with the mode 0600,
+// regardless of the umask.
+func writeKubeconfigFile(content []byte, filename string) error {
+	if err := writeFileAtomic(content, filename); err != nil {
+		return err
+	}
+	logutil.Debugf("kube config written to %s", filename)
+	return nil
+}
 
-	if _, err := certutil.NewPool(rootCAFile); err != nil {
-		klog.Errorf("Expected to load root CA config from %s, but got err: %v", rootCAFile, err)
+// writeFileAtomic writes the file with the mode 0600, regardless of the
+// umask. The file is first written to a temporary file in the same
+// directory, and then renamed.
+func writeFileAtomic(content []byte, filename string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("creating a temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// ioutil.TempFile already uses 0600, but let's be explicit about it.
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("setting the permissions on %s: %w", tmp.Name(), err)
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("renaming %s to %s: %w", tmp.Name(), filename, err)
+	}
+	return nil
+}
+
+// printPEM prints the given PEM bundle as-is, or decoded when --text or
+// --json is given.
+func printPEM(out io.Writer, pem []byte) error {
+	var err error
+	switch {
+	case *textFlag:
+		err = printCertsText(out, pem)
+	case *jsonFlag:
+		err = printCertsJSON(out, pem)
+	default:
+		_, err = fmt.Fprintf(out, "%s", pem)
+	}
+	if err != nil {
+		return fmt.Errorf("decoding the certificates: %w", err)
+	}
+	return nil
+}
+
+var (
+	stdinOnce sync.Once
+	stdin     []byte
+	stdinErr  error
+)
+
+// readFile is the same as ioutil.ReadFile except that "-" means stdin. Stdin is
+// only read once.
+func readFile(name string) ([]byte, error) {
+	if name != "-" {
+		return ioutil.ReadFile(name)
+	}
+
+	stdinOnce.Do(func() {
+		stdin, stdinErr = ioutil.ReadAll(os.Stdin)
+	})
+	if stdinErr != nil {
+		return nil, fmt.Errorf("reading stdin: %w", stdinErr)
+	}
+	return stdin, nil
+}
+
+// loadConfig returns the rest config built from the --server, --token-file
+// and --ca-file flags when --server is given. Otherwise, the in-cluster config
+// or the kube config is used.
+func loadConfig(ctx context.Context) (*rest.Config, error) {
+	if *burst < 0 {
+		return nil, flagErrorf("--burst: expected a positive number, got: %d", *burst)
+	}
+	if *qps > 0 && *burst == 0 {
+		*burst = incluster.DefaultBurst(*qps)
+	}
+
+	var c *rest.Config
+	var err error
+	if *server != "" && !serverWithMount() {
+		logutil.Debugf("using --server, skipping the in-cluster and kube config detection")
+		c, err = manualConfig(*server, *tokenFile, *caFile)
+		if err == nil {
+			c.QPS, c.Burst = *qps, *burst
+		}
 	} else {
-		tlsClientConfig.CAFile = rootCAFile
+		var opts incluster.Options
+		opts, err = inclusterOptions()
+		if err == nil && serverWithMount() {
+			logutil.Debugf("using --server with the token mounted under --root")
+			opts.Server = *server
+			c, err = incluster.InClusterConfig(ctx, opts)
+			if err == nil {
+				c.UserAgent, c.QPS, c.Burst = opts.UserAgent, opts.QPS, opts.Burst
+			}
+		} else if err == nil {
+			c, err = incluster.RestConfig(ctx, opts)
+		}
+
+		switch {
+		case errors.Is(err, incluster.ErrTokenMount) && *projectedToken != "":
+			err = fmt.Errorf("while processing flag --projected-token: %w", err)
+		case errors.Is(err, incluster.ErrTokenMount) && *tokenMountName != "":
+			err = fmt.Errorf("while processing flag --token-mount: %w", err)
+		case errors.Is(err, incluster.ErrTokenMount):
+			err = fmt.Errorf("%w, use --token-mount to pick one", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyOverrides(c); err != nil {
+		return nil, err
+	}
+	traceConfig(c)
+	otelConfig(c)
+	return c, nil
+}
+
+// inclusterOptions returns the options given to the incluster package based
+// on the flags.
+func inclusterOptions() (incluster.Options, error) {
+	opts := incluster.Options{
+		Kubeconfig: *kubeconfig,
+		Context:    *kubecontext,
+		Cluster:    overrides.Context.Cluster,
+		User:       overrides.Context.AuthInfo,
+		Root:       *root,
+		TokenMount: *tokenMountName,
+		UseDNS:     *useDNS,
+		HostEnv:    *hostEnv,
+		PortEnv:    *portEnv,
+		UserAgent:  "kubectl-incluster",
+		QPS:        *qps,
+		Burst:      *burst,
+	}
+
+	if *hostEnv == "" || *portEnv == "" {
+		return incluster.Options{}, flagErrorf("--host-env and --port-env can't be empty")
+	}
+
+	if *projectedToken != "" {
+		if *tokenMountName != "" && *tokenMountName != *projectedToken {
+			return incluster.Options{}, flagErrorf("--projected-token and --token-mount can't be used together")
+		}
+		opts.TokenMount = *projectedToken
+	}
+
+	if *kubeconfig == "-" {
+		bytes, err := readFile(*kubeconfig)
+		if err != nil {
+			return incluster.Options{}, err
+		}
+		if len(strings.TrimSpace(string(bytes))) == 0 {
+			return incluster.Options{}, fmt.Errorf("--kubeconfig: nothing was read from stdin")
+		}
+		opts.Kubeconfig = ""
+		opts.KubeconfigData = decodeBase64Kubeconfig(bytes)
+	}
+	if sourceKubeconfig != nil {
+		opts.Kubeconfig = ""
+		opts.KubeconfigData = sourceKubeconfig
+	}
+
+	return opts, nil
+}
+
+// decodeBase64Kubeconfig decodes the kube config when it is base64-encoded,
+// which is what 'kubectl get secret -o jsonpath={.data.value}' prints. A
+// kube config always contains a colon, which base64 never does.
+func decodeBase64Kubeconfig(data []byte) []byte {
+	if bytes.ContainsRune(data, ':') {
+		return data
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return data
+	}
+	logutil.Debugf("the kube config read from stdin is base64-encoded, decoding it")
+	return decoded
+}
+
+// applyOverrides applies --token, --insecure-skip-tls-verify and
+// --request-timeout to the given rest config. When the kube config is used,
+// clientcmd already applies them, but the in-cluster config and --server
+// don't go through clientcmd.
+func applyOverrides(c *rest.Config) error {
+	if overrides.AuthInfo.Token != "" {
+		useToken(c, overrides.AuthInfo.Token)
+	}
+
+	if overrides.ClusterInfo.InsecureSkipTLSVerify {
+		c.TLSClientConfig.Insecure = true
+		c.TLSClientConfig.CAData = nil
+		c.TLSClientConfig.CAFile = ""
+	}
+
+	// Like kubectl, --request-timeout accepts a duration ('1m') as well as a
+	// number of seconds ('60'). Zero means no timeout.
+	if overrides.Timeout != "" && overrides.Timeout != "0" {
+		timeout, err := time.ParseDuration(overrides.Timeout)
+		if err != nil {
+			secs, secsErr := strconv.Atoi(overrides.Timeout)
+			if secsErr != nil {
+				return flagErrorf("invalid --request-timeout %q, it must be a duration such as '1s' or '2m': %w", overrides.Timeout, err)
+			}
+			timeout = time.Duration(secs) * time.Second
+		}
+		c.Timeout = timeout
+	}
+
+	return nil
+}
+
+// manualConfig builds a rest config out of exactly the given server, token
+// file and CA file. Useful for air-gapped setups or when the service account
+// token is mounted somewhere unusual.
+func manualConfig(server, tokenFile, caFile string) (*rest.Config, error) {
+	cfg := &rest.Config{
+		Host:      server,
+		UserAgent: "kubectl-incluster",
+	}
+
+	if tokenFile != "" {
+		token, err := readFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading token file: %w", err)
+		}
+		cfg.BearerToken = strings.TrimSpace(string(token))
+	}
+
+	if caFile != "" {
+		ca, err := readFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		if _, err := certutil.ParseCertsPEM(ca); err != nil {
+			return nil, fmt.Errorf("loading CA file %s: %w", caFile, err)
+		}
+		cfg.TLSClientConfig.CAData = ca
+	}
+
+	return cfg, nil
+}
+
+// apiConfig returns a rest config meant to be used for talking to the
+// Kubernetes API. We don't use the config that gets printed since it is meant
+// to be customized (the CA cert is changed, etc.). Here, we want the
+// "unmodified" config so that we can connect to the Kubernetes API.
+func apiConfig(ctx context.Context) (*rest.Config, error) {
+	c, err := loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chicken and egg: the whole purpose of kubectl incluster is to create
+	// a kubeconfig that will work when used for MITM proxying over the HTTP
+	// proxy protocol, i.e., when using HTTPS_PROXY and HTTP_PROXY. For
+	// that, kubectl incluster needs to talk to the Kubernetes API, which is
+	// impossible since HTTPS_PROXY is enabled but without the correct
+	// adjustments to the kubeconfig. So we disable HTTPS_PROXY here.
+	//
+	// We can't just 'os.Unsetenv("HTTPS_PROXY")' because the default
+	// http.Transport loads HTTPS_PROXY before this code runs.
+	c.Proxy = func(r *http.Request) (*url.URL, error) {
+		return nil, nil
+	}
+
+	// Without --request-timeout, the API calls made by kubectl-incluster
+	// itself would hang forever when the server is unreachable.
+	if c.Timeout == 0 {
+		c.Timeout = defaultRequestTimeout
+	}
+
+	return c, nil
+}
+
+// inCluster returns true when the in-cluster config is used, i.e., when
+// running in a pod (or when a token is mounted under --root) and no kube
+// config is given.
+func inCluster() bool {
+	if *kubeconfig != "" || sourceKubeconfig != nil {
+		return false
+	}
+	return os.Getenv(*hostEnv) != "" || tokenUnderRoot()
+}
+
+// tokenUnderRoot returns true when --root is given and a service account
+// token is mounted under it. It lets the in-cluster config be used without
+// KUBERNETES_SERVICE_HOST, e.g. with nsenter or when Telepresence doesn't
+// forward the env vars.
+func tokenUnderRoot() bool {
+	if *root == "" {
+		return false
+	}
+	opts, err := inclusterOptions()
+	if err != nil {
+		return false
+	}
+	tokenPath, _, err := incluster.TokenPaths(opts)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(incluster.InRoot(*root, tokenPath))
+	return err == nil
+}
+
+// serverWithMount returns true when --server is given along with --root but
+// without --token-file and --ca-file, in which case the token and CA mounted
+// under the root are used with that server.
+func serverWithMount() bool {
+	return *server != "" && *tokenFile == "" && *caFile == "" && tokenUnderRoot()
+}
+
+// contextNamespace returns the namespace of the loaded credentials. When in
+// cluster, the 'namespace' file mounted next to the token is used. Otherwise,
+// the namespace of the kube config's context is used. It returns an empty
+// string when no namespace can be found.
+func contextNamespace() string {
+	if *server != "" && !serverWithMount() {
+		return ""
+	}
+
+	opts, err := inclusterOptions()
+	if err != nil {
+		return ""
+	}
+
+	if serverWithMount() || inCluster() {
+		tokenPath, _, err := incluster.TokenPaths(opts)
+		if err == nil {
+			bytes, err := ioutil.ReadFile(incluster.InRoot(*root, path.Dir(tokenPath)+"/namespace"))
+			if err == nil {
+				return strings.TrimSpace(string(bytes))
+			}
+			logutil.Debugf("while reading the namespace file: %s", err)
+		}
+	}
+
+	apicfg, err := incluster.LoadKubeconfig(opts)
+	if err != nil {
+		return ""
+	}
+
+	name := apicfg.CurrentContext
+	if *kubecontext != "" {
+		name = *kubecontext
+	}
+	if ctx, ok := apicfg.Contexts[name]; ok {
+		return ctx.Namespace
+	}
+
+	return ""
+}
+
+func fetchCACertFromMitmproxy(ctx context.Context, proxy string) (pem string, _ error) {
+	proxyURL, _ := url.Parse(proxy)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(proxyURL),
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://mitm.it/cert/pem", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("while trying to fetch the CA cert at GET mitm.it/cert/pem: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "application/x-x509-ca-cert" {
+		logutil.Warnf("unexpected content type of GET mitm.it/cert/pem: %s", resp.Header.Get("Content-Type"))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("while reading the body of GET mitm.it/cert/pem: %w", err)
+	}
+
+	return string(body), nil
+}
+
+func getServiceAccount(ctx context.Context, c *rest.Config, ref string) (token, secretRef string, _ error) {
+	splits := strings.Split(ref, "/")
+	if len(splits) != 2 {
+		return "", "", flagErrorf("--serviceaccount: expected value of the form 'namespace/serviceaccount', got: %s", ref)
+	}
+
+	namespace := splits[0]
+	name := splits[1]
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return "", "", fmt.Errorf("while processing flag --serviceaccount: creating Kubernetes client: %w", err)
+	}
+
+	return serviceAccountToken(ctx, cl, namespace, name)
+}
+
+// serviceAccountToken returns the token of the given service account. The
+// token Secret is used when there is one. Otherwise, a Secret is created when
+// --create-secret is given, or a token is requested using the TokenRequest
+// API. The secretRef is the Secret the token was read from, of the form
+// 'namespace/name', and is empty when the token was requested.
+func serviceAccountToken(ctx context.Context, cl kubernetes.Interface, namespace, name string) (token, secretRef string, _ error) {
+	serviceaccount, err := cl.CoreV1().ServiceAccounts(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("getting serviceaccount %s in namespace %s: %w", name, namespace, err)
+	}
+
+	// By default, we try to use the default service account token. Since
+	// Kubernetes 1.20, the default service account token is not created, so we
+	// try to generate a token instead.
+	if *bindTo != "" {
+		if *createSecret {
+			return "", "", flagErrorf("--bind-to and --create-secret can't be used together")
+		}
+		logutil.Debugf("requesting a token bound to %s for serviceaccount %s since --bind-to was passed", *bindTo, name)
+		token, err = requestToken(ctx, cl, namespace, name)
+		return token, "", err
+	}
+
+	// On OpenShift, the secrets also include the image pull secret, and
+	// since OpenShift 4.16, it is the only one.
+	var secret *v1.Secret
+	for _, ref := range serviceaccount.Secrets {
+		s, err := cl.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get the secret %s in namespace %s: %w", ref.Name, namespace, err)
+		}
+
+		if s.Type == v1.SecretTypeServiceAccountToken {
+			secret = s
+			break
+		}
+	}
+	if secret != nil {
+		return tokenFromSecret(secret)
+	}
+
+	switch {
+	case *createSecret && dryRunEnabled():
+		token, err = dryRunTokenSecret(ctx, cl, namespace, name)
+		return token, "", err
+	case *createSecret:
+		logutil.Debugf("serviceaccount %s has no secret of type %s, now creating one since --create-secret was passed", name, v1.SecretTypeServiceAccountToken)
+		secret, err := createTokenSecret(ctx, cl, namespace, name)
+		if err != nil {
+			return "", "", err
+		}
+		return tokenFromSecret(secret)
+	default:
+		logutil.Debugf("serviceaccount %s has no secret of type %s, now trying to generate a token", name, v1.SecretTypeServiceAccountToken)
+		token, err = requestToken(ctx, cl, namespace, name)
+		return token, "", err
+	}
+}
+
+// requestToken requests a token for the given service account using the
+// TokenRequest API. With --bind-to, the token is bound to the given pod or
+// Secret, which means it stops being valid as soon as the object is deleted,
+// the same as 'kubectl create token --bound-object-kind'.
+func requestToken(ctx context.Context, cl kubernetes.Interface, namespace, name string) (string, error) {
+	var req authenticationv1.TokenRequest
+	if *bindTo != "" {
+		ref, err := boundObjectRef(ctx, cl, *bindTo, namespace)
+		if err != nil {
+			return "", fmt.Errorf("while processing flag --bind-to: %w", err)
+		}
+		req.Spec.BoundObjectRef = ref
+	}
+
+	token, err := cl.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, name, &req, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate a token for serviceaccount %s in namespace %s: %w", name, namespace, err)
+	}
+	return token.Status.Token, nil
+}
+
+// boundObjectRef returns the reference to the object given with --bind-to,
+// of the form 'pod=[namespace/]name' or 'secret=[namespace/]name'. The object
+// must be in the namespace of the service account.
+func boundObjectRef(ctx context.Context, cl kubernetes.Interface, value, namespace string) (*authenticationv1.BoundObjectReference, error) {
+	kind, ref := "", ""
+	if splits := strings.SplitN(value, "=", 2); len(splits) == 2 {
+		kind, ref = splits[0], splits[1]
+	}
+	objNamespace, name := namespace, ref
+	if splits := strings.Split(ref, "/"); len(splits) == 2 {
+		objNamespace, name = splits[0], splits[1]
+	} else if len(splits) > 2 {
+		name = ""
+	}
+	if name == "" {
+		return nil, flagErrorf("expected value of the form 'pod=[namespace/]name' or 'secret=[namespace/]name', got: %s", value)
+	}
+	if objNamespace != namespace {
+		return nil, flagErrorf("the %s %s/%s must be in the namespace of the service account, %s", kind, objNamespace, name, namespace)
+	}
+
+	var uid types.UID
+	switch kind {
+	case "pod":
+		pod, err := cl.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("getting pod %s in namespace %s: %w", name, namespace, err)
+		}
+		kind, uid = "Pod", pod.UID
+	case "secret":
+		secret, err := cl.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("getting secret %s in namespace %s: %w", name, namespace, err)
+		}
+		kind, uid = "Secret", secret.UID
+	default:
+		return nil, flagErrorf("expected value of the form 'pod=[namespace/]name' or 'secret=[namespace/]name', got: %s", value)
+	}
+
+	return &authenticationv1.BoundObjectReference{Kind: kind, APIVersion: "v1", Name: name, UID: uid}, nil
+}
+
+// createTokenSecret creates a Secret of type kubernetes.io/service-account-token
+// for the given service account and waits until the token controller has
+// populated it. If the Secret already exists, it is reused.
+func createTokenSecret(ctx context.Context, cl kubernetes.Interface, namespace, serviceaccount string) (*v1.Secret, error) {
+	name := tokenSecretName(serviceaccount)
+
+	_, err := cl.CoreV1().Secrets(namespace).Create(ctx, newTokenSecret(namespace, serviceaccount), metav1.CreateOptions{})
+	switch {
+	case k8serrors.IsAlreadyExists(err):
+		logutil.Debugf("secret %s already exists in namespace %s, reusing it", name, namespace)
+	case err != nil:
+		return nil, fmt.Errorf("creating the token secret %s in namespace %s: %w", name, namespace, err)
+	default:
+		logutil.Infof("created the token secret %s in namespace %s for serviceaccount %s", name, namespace, serviceaccount)
+	}
+
+	var secret *v1.Secret
+	err = wait.PollImmediate(500*time.Millisecond, 30*time.Second, func() (bool, error) {
+		secret, err = cl.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if secret.Annotations[v1.ServiceAccountNameKey] != serviceaccount {
+			return false, fmt.Errorf("secret %s in namespace %s exists but is meant for the serviceaccount %q", name, namespace, secret.Annotations[v1.ServiceAccountNameKey])
+		}
+		if len(secret.Data["token"]) == 0 {
+			logutil.Debugf("waiting for the token controller to populate the secret %s", name)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("waiting for the token secret %s in namespace %s to be populated: %w", name, namespace, err)
+	}
+
+	return secret, nil
+}
+
+// tokenSecretName is the name of the Secret created by --create-secret.
+func tokenSecretName(serviceaccount string) string {
+	return serviceaccount + "-kubectl-incluster-token"
+}
+
+// newTokenSecret returns the Secret created by --create-secret. The token controller
+// populates it with the token of the service account.
+func newTokenSecret(namespace, serviceaccount string) *v1.Secret {
+	return &v1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tokenSecretName(serviceaccount),
+			Namespace: namespace,
+			Annotations: map[string]string{
+				v1.ServiceAccountNameKey: serviceaccount,
+			},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+	}
+}
+
+// dryRunTokenSecret records the Secret that --create-secret would create
+// with --dry-run. With --dry-run=server, the Secret is first validated by the
+// API server. Since the token only exists once the token controller has
+// populated the Secret, the kube config has no token.
+func dryRunTokenSecret(ctx context.Context, cl kubernetes.Interface, namespace, serviceaccount string) (string, error) {
+	name := tokenSecretName(serviceaccount)
+	secret := newTokenSecret(namespace, serviceaccount)
+	if !dryRunClient() {
+		_, err := cl.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{DryRun: dryRunOptions()})
+		if err != nil && !k8serrors.IsAlreadyExists(err) {
+			return "", fmt.Errorf("creating secret %s in namespace %s: %w", name, namespace, err)
+		}
+	}
+	recordDryRun(secret, "secret "+namespace+"/"+name)
+	logutil.Warnf("--dry-run=%s: the kube config has no token, once the manifests are applied, run 'kubectl incluster --from-secret %s/%s' to get it", *dryRun, namespace, name)
+	return "", nil
+}
+
+// getTokenFromSecret returns the token stored in the given Secret. The ref is
+// of the form 'namespace/name'.
+func getTokenFromSecret(ctx context.Context, c *rest.Config, ref string) (token, secretRef string, _ error) {
+	splits := strings.Split(ref, "/")
+	if len(splits) != 2 {
+		return "", "", flagErrorf("expected value of the form 'namespace/secret', got: %s", ref)
+	}
+
+	namespace := splits[0]
+	name := splits[1]
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return "", "", fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	secret, err := cl.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("getting secret %s in namespace %s: %w", name, namespace, err)
+	}
+
+	if secret.Type != v1.SecretTypeServiceAccountToken {
+		return "", "", fmt.Errorf("secret %s in namespace %s is of type %s, expected %s", name, namespace, secret.Type, v1.SecretTypeServiceAccountToken)
+	}
+
+	return tokenFromSecret(secret)
+}
+
+// tokenFromSecret returns the token stored in the given service account
+// token Secret, and the Secret's ref of the form 'namespace/name'.
+func tokenFromSecret(secret *v1.Secret) (token, secretRef string, _ error) {
+	tokenBytes, ok := secret.Data["token"]
+	if !ok {
+		return "", "", fmt.Errorf("key 'token' not found in %s", secret.GetName())
+	}
+
+	// The token controller may not have populated the secret yet.
+	if len(tokenBytes) == 0 {
+		return "", "", fmt.Errorf("key 'token' is empty in %s", secret.GetName())
+	}
+
+	return string(tokenBytes), secret.Namespace + "/" + secret.Name, nil
+}
+
+// useToken replaces the credentials of the given rest config with the given
+// token.
+func useToken(c *rest.Config, token string) {
+	c.BearerToken = token
+	c.BearerTokenFile = ""
+	c.KeyData = nil
+	c.KeyFile = ""
+	c.CertData = nil
+	c.CertFile = ""
+	c.ExecProvider = nil
+	c.AuthProvider = nil
+}
+
+func useClientCert(c *rest.Config, cert, key []byte) {
+	c.CertData = cert
+	c.CertFile = ""
+	c.KeyData = key
+	c.KeyFile = ""
+	c.BearerToken = ""
+	c.BearerTokenFile = ""
+	c.Username = ""
+	c.Password = ""
+	c.ExecProvider = nil
+	c.AuthProvider = nil
+}
+
+// getCAFromConfigMap fetches the "ca.crt" key of the given ConfigMap. The ref
+// is of the form '[namespace/]name[#key]'. When the namespace is omitted, the
+// given default namespace is used, or 'default' if it is empty.
+func getCAFromConfigMap(ctx context.Context, c *rest.Config, ref, defaultNamespace string) ([]byte, error) {
+	namespace, name, key, err := parseObjectRef(ref, defaultNamespace, "ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a CA, the server's certificate can't be verified, which is only
+	// done when asked for with --insecure-skip-tls-verify.
+	switch {
+	case c.TLSClientConfig.Insecure:
+		logutil.Warnf("fetching the ConfigMap %s/%s without verifying the API server's certificate since --insecure-skip-tls-verify was passed", namespace, name)
+	case len(c.TLSClientConfig.CAData) == 0 && c.TLSClientConfig.CAFile == "":
+		return nil, fmt.Errorf("%w: no CA is available to verify the API server's certificate while fetching the ConfigMap %s/%s", incluster.ErrNoCredentials, namespace, name)
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	cm, err := cl.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting configmap %s in namespace %s: %w", name, namespace, err)
+	}
+
+	ca, ok := cm.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("key '%s' not found in configmap %s in namespace %s", key, name, namespace)
+	}
+
+	return []byte(ca), nil
+}
+
+// getCAFromSecret fetches the "ca.crt" key of the given Secret. The ref is of
+// the form '[namespace/]name[#key]'.
+func getCAFromSecret(ctx context.Context, c *rest.Config, ref, defaultNamespace string) ([]byte, error) {
+	namespace, name, key, err := parseObjectRef(ref, defaultNamespace, "ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	secret, err := cl.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting secret %s in namespace %s: %w", name, namespace, err)
+	}
+
+	ca, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("key '%s' not found in secret %s in namespace %s", key, name, namespace)
+	}
+
+	return ca, nil
+}
+
+// getClientCertFromSecret fetches the tls.crt and tls.key of the given
+// Secret. The ref is of the form '[namespace/]name'. When the namespace is
+// omitted, the given default namespace is used, or 'default' if it is empty.
+func getClientCertFromSecret(ctx context.Context, c *rest.Config, ref, defaultNamespace string) (cert, key []byte, _ error) {
+	namespace, name := defaultNamespace, ref
+	if splits := strings.Split(ref, "/"); len(splits) == 2 {
+		namespace, name = splits[0], splits[1]
+	} else if len(splits) > 2 {
+		return nil, nil, flagErrorf("expected value of the form '[namespace/]name', got: %s", ref)
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	if name == "" {
+		return nil, nil, flagErrorf("expected value of the form '[namespace/]name', got: %s", ref)
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	return getTLSFromSecret(ctx, cl, namespace, name)
+}
+
+// getCAFromURL fetches a PEM-encoded CA certificate over HTTP(S).
+func getCAFromURL(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("while fetching the CA at %s: %w", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("while fetching the CA at %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("while fetching the CA at %s: unexpected status %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("while reading the CA at %s: %w", url, err)
+	}
+
+	if _, err := certutil.ParseCertsPEM(body); err != nil {
+		return nil, fmt.Errorf("the content at %s isn't a PEM-encoded certificate: %w", url, err)
+	}
+
+	return body, nil
+}
+
+// parseObjectRef parses references of the form '[namespace/]name[#key]'.
+func parseObjectRef(ref, defaultNamespace, defaultKey string) (namespace, name, key string, _ error) {
+	namespace, name, key = defaultNamespace, ref, defaultKey
+	if i := strings.Index(name, "#"); i != -1 {
+		name, key = name[:i], name[i+1:]
+	}
+	if splits := strings.Split(name, "/"); len(splits) == 2 {
+		namespace, name = splits[0], splits[1]
+	} else if len(splits) > 2 {
+		return "", "", "", flagErrorf("expected value of the form '[namespace/]name[#key]', got: %s", ref)
+	}
+
+	if namespace == "" {
+		namespace = "default"
+	}
+	if name == "" || key == "" {
+		return "", "", "", flagErrorf("expected value of the form '[namespace/]name[#key]', got: %s", ref)
 	}
 
-	return &rest.Config{
-		// TODO: switch to using cluster DNS.
-		Host:            "https://" + net.JoinHostPort(host, port),
-		TLSClientConfig: tlsClientConfig,
-		BearerToken:     string(token),
-		BearerTokenFile: tokenFile,
-	}, nil
+	return namespace, name, key, nil
 }
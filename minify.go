@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// minifyNames renames the context, cluster and user of the kube config, which
+// are all named "kubectl-incluster", after the ones selected in the source
+// kube config. The result is what 'kubectl config view --minify --flatten'
+// would print, with the other flags applied. It is used with --minify.
+func minifyNames(apiconf *clientcmdapi.Config) error {
+	if *server != "" {
+		return flagErrorf("--minify can't be used with --server")
+	}
+	if inCluster() {
+		return flagErrorf("--minify requires --kubeconfig when running in a pod")
+	}
+
+	opts, err := inclusterOptions()
+	if err != nil {
+		return err
+	}
+	source, err := incluster.LoadKubeconfig(opts)
+	if err != nil {
+		return fmt.Errorf("loading the kube config: %w", err)
+	}
+
+	name := opts.Context
+	if name == "" {
+		name = source.CurrentContext
+	}
+	srcCtx, ok := source.Contexts[name]
+	if !ok {
+		return fmt.Errorf("the context %s doesn't exist in the kube config", name)
+	}
+	clusterName, userName := srcCtx.Cluster, srcCtx.AuthInfo
+	if opts.Cluster != "" {
+		clusterName = opts.Cluster
+	}
+	if opts.User != "" {
+		userName = opts.User
+	}
+
+	ctx := apiconf.Contexts[apiconf.CurrentContext]
+	cluster, user := apiconf.Clusters[ctx.Cluster], apiconf.AuthInfos[ctx.AuthInfo]
+
+	ctx.Cluster, ctx.AuthInfo = clusterName, userName
+	apiconf.Contexts = map[string]*clientcmdapi.Context{name: ctx}
+	apiconf.Clusters = map[string]*clientcmdapi.Cluster{clusterName: cluster}
+	apiconf.AuthInfos = map[string]*clientcmdapi.AuthInfo{userName: user}
+	apiconf.CurrentContext = name
+
+	return nil
+}
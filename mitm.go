@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// watchStreamScript is the same as watch-stream.py: it makes mitmproxy
+// stream the responses of the requests that have '?watch=true', without
+// which the watches made by Kubernetes clients hang.
+const watchStreamScript = `from mitmproxy import http
+
+
+def responseheaders(flow: http.HTTPFlow):
+    flow.response.stream = flow.request.query.get("watch") == "true"
+`
+
+// runMitm starts mitmproxy (or the given mitmproxy command, e.g. mitmweb),
+// listening on the given port, with the cluster's CA to verify the upstream
+// API server and the watch-stream script. Once mitmproxy is ready, the kube
+// config is written to --output, or to a temporary file otherwise, with the
+// CA presented by mitmproxy and the proxy written as 'proxy-url'. The
+// temporary files are removed when mitmproxy exits. With an upstream proxy
+// (by default, the proxy-url of the kube config), mitmproxy forwards the
+// traffic to it, which is how the two proxies are chained.
+func runMitm(ctx context.Context, port int, upstreamProxy string, args []string) error {
+	if len(args) == 0 {
+		args = []string{"mitmproxy"}
+	}
+	var userMode bool
+	for _, arg := range args[1:] {
+		if arg == "-p" || arg == "--listen-port" || strings.HasPrefix(arg, "--listen-port=") {
+			return flagErrorf("use --port instead of giving %s to %s", arg, args[0])
+		}
+		if arg == "-m" || arg == "--mode" || strings.HasPrefix(arg, "--mode=") {
+			userMode = true
+		}
+	}
+	if userMode && upstreamProxy != "" {
+		return flagErrorf("--upstream-proxy can't be used when giving --mode to %s", args[0])
+	}
+	proxy := "http://127.0.0.1:" + strconv.Itoa(port)
+
+	// Client certificates can't go through mitmproxy, which is why the
+	// credentials are checked before starting mitmproxy.
+	c, ns, err := resolveConfig(ctx, "")
+	if err != nil {
+		return err
+	}
+	if _, err := incluster.WrapForProxy(c, proxy, nil); err != nil {
+		return fmt.Errorf("%w, use --force-token or --serviceaccount", err)
+	}
+
+	var files []string
+	defer func() {
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+	writeTemp := func(pattern string, content []byte) (string, error) {
+		path, err := writeTempFile(pattern, content)
+		if err == nil {
+			files = append(files, path)
+		}
+		return path, err
+	}
+
+	mitmArgs := append(args[1:], "--listen-port", strconv.Itoa(port))
+	if upstream := configProxyURL(c); upstreamProxy == "" && !userMode && upstream != nil {
+		upstreamProxy = upstream.String()
+		logutil.Infof("mitmproxy will forward the traffic to the proxy-url %s of the kube config", upstreamProxy)
+	}
+	if upstreamProxy != "" {
+		mitmArgs = append(mitmArgs, "--mode", "upstream:"+upstreamProxy)
+	}
+	script, err := writeTemp("watch-stream-*.py", []byte(watchStreamScript))
+	if err != nil {
+		return err
+	}
+	mitmArgs = append(mitmArgs, "-s", script)
+	ca, err := incluster.CACertPEM(c)
+	switch {
+	case c.TLSClientConfig.Insecure || err != nil:
+		mitmArgs = append(mitmArgs, "--ssl-insecure")
+	default:
+		caPath, err := writeTemp("ca-*.pem", ca)
+		if err != nil {
+			return err
+		}
+		mitmArgs = append(mitmArgs, "--set", "ssl_verify_upstream_trusted_ca="+caPath)
+	}
+
+	kubeconfigPath := *output
+	if kubeconfigPath == "" {
+		kubeconfigPath, err = writeTemp("kubeconfig-*.yaml", nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command(args[0], mitmArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	logutil.Debugf("running '%s %s'", args[0], strings.Join(mitmArgs, " "))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", args[0], err)
+	}
+	logutil.Infof("the kube config will be written once %s is ready, use it from another shell with: export KUBECONFIG=%s", args[0], kubeconfigPath)
+
+	go func() {
+		if err := writeMitmKubeconfig(ctx, c, ns, proxy, kubeconfigPath); err != nil {
+			logutil.Errorf("while writing the kube config: %s", err)
+		}
+	}()
+
+	// Like with the run subcommand, mitmproxy gets the Ctrl-C from the
+	// terminal, and we wait for it to exit to remove the temporary files.
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
+
+// writeMitmKubeconfig waits for mitmproxy to be ready, and then writes the
+// kube config that goes through it.
+func writeMitmKubeconfig(ctx context.Context, c *rest.Config, ns, proxy, path string) error {
+	var proxyCA string
+	err := wait.PollImmediate(500*time.Millisecond, 30*time.Second, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+
+		var err error
+		proxyCA, err = fetchCACertFromMitmproxy(ctx, proxy)
+		if err != nil {
+			logutil.Debugf("waiting for mitmproxy: %s", err)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("mitmproxy didn't become ready at %s: %w", proxy, err)
+	}
+	if err := checkProxyStreaming(proxy); err != nil {
+		return err
+	}
+
+	wrapped, err := incluster.WrapForProxy(c, proxy, []byte(proxyCA))
+	if err != nil {
+		return err
+	}
+	kubeconfig, err := kubeconfigFromConfig(ctx, wrapped, ns)
+	if err != nil {
+		return err
+	}
+	for _, cluster := range kubeconfig.Clusters {
+		cluster.ProxyURL = proxy
+	}
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return fmt.Errorf("serializing the kube config: %w", err)
+	}
+	return writeKubeconfigFile(content, path)
+}
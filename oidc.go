@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// writeOIDC fetches the OIDC discovery document and the JWKS of the service
+// account issuer from the API server and prints them as a single JSON object
+// with the keys 'openid-configuration' and 'jwks'. These are what external
+// systems such as AWS IRSA, Vault or Dex need to trust the service account
+// tokens of the cluster. Both endpoints are readable by any service account
+// since Kubernetes 1.21 thanks to the ClusterRole
+// 'system:service-account-issuer-discovery'.
+func writeOIDC(ctx context.Context, c *rest.Config, out io.Writer) error {
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	discovery, err := cl.Discovery().RESTClient().Get().AbsPath("/.well-known/openid-configuration").DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching /.well-known/openid-configuration: %w", err)
+	}
+	var issuer struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(discovery, &issuer); err != nil {
+		return fmt.Errorf("decoding /.well-known/openid-configuration: %w", err)
+	}
+	logutil.Debugf("the service account issuer is %s and its JWKS is served at %s", issuer.Issuer, issuer.JWKSURI)
+
+	// The jwks_uri may point to where the issuer is exposed publicly, which
+	// isn't necessarily reachable from here, but the API server always
+	// serves it.
+	jwks, err := cl.Discovery().RESTClient().Get().AbsPath("/openid/v1/jwks").DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching /openid/v1/jwks: %w", err)
+	}
+
+	data, err := json.MarshalIndent(struct {
+		OpenIDConfiguration json.RawMessage `json:"openid-configuration"`
+		JWKS                json.RawMessage `json:"jwks"`
+	}{discovery, jwks}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
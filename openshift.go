@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// oauthMetadata is the OAuth 2.0 authorization server metadata that
+// OpenShift serves at /.well-known/oauth-authorization-server. Vanilla
+// Kubernetes clusters don't serve it, which is how OpenShift is detected.
+type oauthMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// openShiftOAuthMetadata returns the OAuth metadata of the cluster, or an
+// error when the cluster isn't OpenShift.
+func openShiftOAuthMetadata(ctx context.Context, c *rest.Config) (*oauthMetadata, error) {
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	body, err := cl.Discovery().RESTClient().Get().AbsPath("/.well-known/oauth-authorization-server").DoRaw(ctx)
+	if k8serrors.IsNotFound(err) {
+		return nil, fmt.Errorf("the cluster doesn't seem to be OpenShift since it doesn't serve /.well-known/oauth-authorization-server")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching the OpenShift OAuth metadata: %w", err)
+	}
+
+	var meta oauthMetadata
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, fmt.Errorf("decoding the OpenShift OAuth metadata: %w", err)
+	}
+	if meta.AuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("the OpenShift OAuth metadata has no authorization_endpoint")
+	}
+	logutil.Debugf("the cluster is OpenShift, the OAuth server is %s", meta.Issuer)
+
+	return &meta, nil
+}
+
+// openShiftLogin requests a token from the OpenShift OAuth server with the
+// given username and password, the same way 'oc login -u' does: the
+// 'openshift-challenging-client' answers the basic auth challenge with a
+// redirect that contains the token in its fragment.
+func openShiftLogin(ctx context.Context, c *rest.Config, meta *oauthMetadata, username, password string) (string, error) {
+	// The OAuth server is exposed with a route, which means its certificate
+	// is often signed by the ingress CA instead of the cluster CA.
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	ca := c.TLSClientConfig.CAData
+	if len(ca) == 0 && c.TLSClientConfig.CAFile != "" {
+		ca, err = ioutil.ReadFile(c.TLSClientConfig.CAFile)
+		if err != nil {
+			return "", fmt.Errorf("reading CA file: %w", err)
+		}
+	}
+	pool.AppendCertsFromPEM(ca)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, InsecureSkipVerify: c.TLSClientConfig.Insecure}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   defaultRequestTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", meta.AuthorizationEndpoint+"?response_type=token&client_id=openshift-challenging-client", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(username, password)
+	req.Header.Set("X-CSRF-Token", "1")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("while requesting a token from %s: %w", meta.AuthorizationEndpoint, err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusFound:
+	case http.StatusUnauthorized:
+		return "", fmt.Errorf("%w: the OpenShift OAuth server refused the username %s or its password", incluster.ErrNoCredentials, username)
+	default:
+		return "", fmt.Errorf("while requesting a token from %s: unexpected status %s", meta.AuthorizationEndpoint, resp.Status)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("while requesting a token from %s: %w", meta.AuthorizationEndpoint, err)
+	}
+	values, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		return "", fmt.Errorf("while parsing the redirect of the OpenShift OAuth server: %w", err)
+	}
+	if values.Get("error") != "" {
+		return "", fmt.Errorf("the OpenShift OAuth server returned %s: %s", values.Get("error"), values.Get("error_description"))
+	}
+	token := values.Get("access_token")
+	if token == "" {
+		return "", fmt.Errorf("the OpenShift OAuth server didn't return a token")
+	}
+	logutil.Debugf("got an OpenShift token for %s, it expires in %s seconds", username, values.Get("expires_in"))
+
+	return token, nil
+}
+
+// openShiftUsername returns the username of the given credentials, which is
+// what 'oc whoami' prints.
+func openShiftUsername(ctx context.Context, c *rest.Config) (string, error) {
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return "", fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	body, err := cl.Discovery().RESTClient().Get().AbsPath("/apis/user.openshift.io/v1/users/~").DoRaw(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting users/~: %w", err)
+	}
+
+	var user struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil {
+		return "", fmt.Errorf("decoding users/~: %w", err)
+	}
+	return user.Metadata.Name, nil
+}
+
+// openShiftNames renames the context, cluster and user of the kube config,
+// which are all named "kubectl-incluster", the way 'oc login' names them: the
+// cluster is named after the server's host and port with dots replaced by
+// dashes (e.g., 'api-crc-testing:6443'), the user is 'username/cluster' and
+// the context is 'namespace/cluster/username'.
+func openShiftNames(apiconf *clientcmdapi.Config, username string) error {
+	ctx := apiconf.Contexts[apiconf.CurrentContext]
+	cluster, user := apiconf.Clusters[ctx.Cluster], apiconf.AuthInfos[ctx.AuthInfo]
+
+	server, err := url.Parse(cluster.Server)
+	if err != nil {
+		return fmt.Errorf("parsing the server URL %q: %w", cluster.Server, err)
+	}
+	clusterName := strings.ReplaceAll(server.Host, ".", "-")
+	userName := username + "/" + clusterName
+	ns := ctx.Namespace
+	if ns == "" {
+		ns = "default"
+	}
+	name := ns + "/" + clusterName + "/" + username
+
+	ctx.Cluster, ctx.AuthInfo = clusterName, userName
+	apiconf.Contexts = map[string]*clientcmdapi.Context{name: ctx}
+	apiconf.Clusters = map[string]*clientcmdapi.Cluster{clusterName: cluster}
+	apiconf.AuthInfos = map[string]*clientcmdapi.AuthInfo{userName: user}
+	apiconf.CurrentContext = name
+
+	return nil
+}
+
+// openShiftPassword returns the password of the user given with
+// --openshift-user, which is read from $OPENSHIFT_PASSWORD so that it doesn't
+// show up in the shell history or in the process list.
+func openShiftPassword() (string, error) {
+	password := os.Getenv("OPENSHIFT_PASSWORD")
+	if password == "" {
+		return "", fmt.Errorf("%w: --openshift-user requires the password to be set in $OPENSHIFT_PASSWORD", incluster.ErrNoCredentials)
+	}
+	return password, nil
+}
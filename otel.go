@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// otelFlushInterval is how often the spans are sent to the OTLP collector.
+// The remaining spans are sent when kubectl-incluster exits.
+const otelFlushInterval = 5 * time.Second
+
+// The spans recorded since the last flush. We don't depend on the
+// OpenTelemetry SDK, the spans are sent using OTLP/HTTP with the JSON
+// encoding, which all the collectors (and Jaeger, Tempo...) accept.
+var (
+	otelMu    sync.Mutex
+	otelSpans []otlpSpan
+)
+
+// otlpSpan is a span in the OTLP JSON encoding. The IDs are hex-encoded and
+// the timestamps are nanoseconds given as strings.
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpSpanKindClient = 3
+	otlpStatusOK       = 1
+	otlpStatusError    = 2
+)
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]string{"stringValue": value}}
+}
+
+// The OTLP JSON encoding gives 64-bit integers as strings.
+func otlpInt(key string, value int) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]string{"intValue": strconv.Itoa(value)}}
+}
+
+// otelTransport records a client span for every request made to the API
+// server. The 'traceparent' header is set so that the spans of the API
+// server are part of the same trace when its tracing is turned on.
+type otelTransport struct {
+	next http.RoundTripper
+}
+
+func (t *otelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	traceID, spanID := randomHex(16), randomHex(8)
+	req = req.Clone(req.Context())
+	req.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	end := time.Now()
+
+	span := otlpSpan{
+		TraceID:           traceID,
+		SpanID:            spanID,
+		Name:              req.Method,
+		Kind:              otlpSpanKindClient,
+		StartTimeUnixNano: strconv.FormatInt(start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			otlpString("http.request.method", req.Method),
+			otlpString("url.full", req.URL.String()),
+			otlpString("server.address", req.URL.Hostname()),
+		},
+		Status: otlpStatus{Code: otlpStatusOK},
+	}
+	switch {
+	case err != nil:
+		span.Status = otlpStatus{Code: otlpStatusError, Message: err.Error()}
+	case resp.StatusCode >= 400:
+		span.Attributes = append(span.Attributes, otlpInt("http.response.status_code", resp.StatusCode))
+		span.Status = otlpStatus{Code: otlpStatusError, Message: resp.Status}
+	default:
+		span.Attributes = append(span.Attributes, otlpInt("http.response.status_code", resp.StatusCode))
+	}
+
+	otelMu.Lock()
+	otelSpans = append(otelSpans, span)
+	otelMu.Unlock()
+
+	return resp, err
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// configureOTel checks --otel-endpoint and starts sending the spans
+// periodically, which matters with --watch and the proxy subcommand since
+// they keep running.
+func configureOTel(ctx context.Context) error {
+	if *otelEndpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(*otelEndpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return flagErrorf("--otel-endpoint: expected a URL such as 'http://localhost:4318', got: %s", *otelEndpoint)
+	}
+
+	go func() {
+		ticker := time.NewTicker(otelFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				flushOTelSpans()
+			}
+		}
+	}()
+	return nil
+}
+
+// otelConfig wraps the transport of the config with otelTransport when
+// --otel-endpoint is given.
+func otelConfig(c *rest.Config) {
+	if *otelEndpoint == "" {
+		return
+	}
+	c.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &otelTransport{next: rt}
+	})
+}
+
+// flushOTelSpans sends the spans recorded since the last flush to the OTLP
+// collector. Failing to send them is only logged since tracing must not get
+// in the way of generating the kube config.
+func flushOTelSpans() {
+	otelMu.Lock()
+	spans := otelSpans
+	otelSpans = nil
+	otelMu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := exportOTelSpans(spans); err != nil {
+		logutil.Warnf("while sending %d spans to %s: %s", len(spans), *otelEndpoint, err)
+		return
+	}
+	logutil.Debugf("sent %d spans to %s", len(spans), *otelEndpoint)
+}
+
+func exportOTelSpans(spans []otlpSpan) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{
+					otlpString("service.name", "kubectl-incluster"),
+					otlpString("service.version", version),
+				},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/maelvls/kubectl-incluster"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(*otelEndpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
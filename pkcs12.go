@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"unicode/utf16"
+
+	"k8s.io/client-go/util/keyutil"
+)
+
+// The PKCS#12 bundle uses the legacy algorithms (3DES and SHA-1 with the
+// PKCS#12 key derivation) since they are the only ones that every browser,
+// Java's keytool and the macOS keychain can import. The Go standard library
+// has no PKCS#12 encoder, and golang.org/x/crypto/pkcs12 can only decode.
+var (
+	oidDataContentType               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidCertBag                       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertTypeX509Certificate       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+// p12Iterations is the number of iterations of the key derivation, the same
+// as OpenSSL's default.
+const p12Iterations = 2048
+
+type p12PFX struct {
+	Version  int
+	AuthSafe p12ContentInfo
+	MacData  p12MacData
+}
+
+type p12ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type p12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo p12EncryptedContentInfo
+}
+
+type p12EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type p12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue  `asn1:"tag:0,explicit"`
+	Attributes []p12Attribute `asn1:"set,optional"`
+}
+
+type p12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type p12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type p12EncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type p12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type p12MacData struct {
+	Mac        p12DigestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type p12DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// clientP12 encodes the PEM client key and certificate chain as a PKCS#12
+// bundle protected with the given password. The first certificate is the
+// one of the key.
+func clientP12(keyPEM, certPEM []byte, password string) ([]byte, error) {
+	key, err := keyutil.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the client key: %w", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encoding the client key: %w", err)
+	}
+	certs, err := parseCertsPEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the client certificate: %w", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in the client certificate")
+	}
+	pw, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+
+	// The localKeyId attribute pairs the key with its certificate.
+	keyID := sha1.Sum(certs[0].Raw)
+	localKeyID, err := p12LocalKeyID(keyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []p12SafeBag
+	for i, cert := range certs {
+		bag, err := asn1.Marshal(p12CertBag{ID: oidCertTypeX509Certificate, Data: cert.Raw})
+		if err != nil {
+			return nil, err
+		}
+		safeBag := p12SafeBag{ID: oidCertBag, Value: explicitTag0(bag)}
+		if i == 0 {
+			safeBag.Attributes = []p12Attribute{localKeyID}
+		}
+		certBags = append(certBags, safeBag)
+	}
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	certAlgorithm, encryptedCerts, err := p12Encrypt(certContents, pw)
+	if err != nil {
+		return nil, err
+	}
+	encryptedData, err := asn1.Marshal(p12EncryptedData{
+		EncryptedContentInfo: p12EncryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: certAlgorithm,
+			EncryptedContent:           encryptedCerts,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keyAlgorithm, encryptedKey, err := p12Encrypt(pkcs8, pw)
+	if err != nil {
+		return nil, err
+	}
+	shroudedKey, err := asn1.Marshal(p12EncryptedPrivateKeyInfo{Algorithm: keyAlgorithm, EncryptedData: encryptedKey})
+	if err != nil {
+		return nil, err
+	}
+	keyContents, err := asn1.Marshal([]p12SafeBag{{
+		ID:         oidPKCS8ShroudedKeyBag,
+		Value:      explicitTag0(shroudedKey),
+		Attributes: []p12Attribute{localKeyID},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := asn1.Marshal(keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]p12ContentInfo{
+		{ContentType: oidEncryptedDataContentType, Content: explicitTag0(encryptedData)},
+		{ContentType: oidDataContentType, Content: explicitTag0(keyData)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	authSafeData, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	macSalt, err := randomSalt()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, p12KDF(pw, macSalt, 3, sha1.Size))
+	mac.Write(authSafe)
+
+	return asn1.Marshal(p12PFX{
+		Version:  3,
+		AuthSafe: p12ContentInfo{ContentType: oidDataContentType, Content: explicitTag0(authSafeData)},
+		MacData: p12MacData{
+			Mac: p12DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: p12Iterations,
+		},
+	})
+}
+
+// p12Encrypt encrypts the data with pbeWithSHAAnd3-KeyTripleDES-CBC.
+func p12Encrypt(data, password []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt, err := randomSalt()
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(p12PBEParams{Salt: salt, Iterations: p12Iterations})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	block, err := des.NewTripleDESCipher(p12KDF(password, salt, 1, 24))
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	iv := p12KDF(password, salt, 2, block.BlockSize())
+
+	// PKCS#7 padding.
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	encrypted := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	algorithm := pkix.AlgorithmIdentifier{
+		Algorithm:  oidPBEWithSHAAnd3KeyTripleDESCBC,
+		Parameters: asn1.RawValue{FullBytes: params},
+	}
+	return algorithm, encrypted, nil
+}
+
+// p12KDF is the key derivation of RFC 7292, appendix B.2, with SHA-1. The
+// id is 1 for the key, 2 for the IV and 3 for the MAC key.
+func p12KDF(password, salt []byte, id byte, size int) []byte {
+	// v is the block size of SHA-1.
+	const v = 64
+
+	fill := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(data)+v-1)/v))
+		for i := range out {
+			out[i] = data[i%len(data)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		h := sha1.Sum(append(append([]byte(nil), d...), i...))
+		a := h[:]
+		for r := 1; r < p12Iterations; r++ {
+			h = sha1.Sum(a)
+			a = h[:]
+		}
+		out = append(out, a...)
+
+		// Ij = (Ij + B + 1) mod 2^(v*8) for each v-byte block of I.
+		b := fill(a)
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// bmpString encodes the password as a null-terminated big-endian UTF-16
+// string, as the PKCS#12 key derivation expects.
+func bmpString(s string) ([]byte, error) {
+	var out []byte
+	for _, r := range s {
+		if r > 0xFFFF {
+			return nil, fmt.Errorf("the password can only contain characters of the Basic Multilingual Plane")
+		}
+	}
+	for _, c := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(c>>8), byte(c))
+	}
+	return append(out, 0, 0), nil
+}
+
+func p12LocalKeyID(id []byte) (p12Attribute, error) {
+	value, err := asn1.Marshal(id)
+	if err != nil {
+		return p12Attribute{}, err
+	}
+	// The value of an attribute is a SET.
+	return p12Attribute{
+		ID:    oidLocalKeyID,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+	}, nil
+}
+
+func explicitTag0(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func randomSalt() ([]byte, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating a salt: %w", err)
+	}
+	return salt, nil
+}
@@ -0,0 +1,290 @@
+// Package incluster resolves the credentials kubectl-incluster would use (the
+// mounted service account token when running in a pod or with Telepresence,
+// or the kube config otherwise) and turns them into a standalone kube config.
+// Controllers and test harnesses can use it instead of shelling out to
+// kubectl-incluster.
+package incluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	certutil "k8s.io/client-go/util/cert"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+var (
+	// ErrNoContext is returned when no context is given and the kube config
+	// has no current context, which is also what happens when not running in
+	// a pod and no kube config exists.
+	ErrNoContext = errors.New("no context was provided and no current context was found in the kubeconfig")
+
+	// ErrNoCredentials is wrapped by the errors returned when the token,
+	// client certificate or CA can't be found.
+	ErrNoCredentials = errors.New("missing credentials")
+
+	// ErrTokenMount is wrapped by the errors returned when the token mount
+	// given with Options.TokenMount isn't found, or when several token mounts
+	// are found and none is given.
+	ErrTokenMount = errors.New("can't pick the token mount")
+)
+
+// Options control how the credentials are resolved. The zero value behaves
+// like 'kubectl incluster' without any flag.
+type Options struct {
+	// Kubeconfig is the path to the kube config. It can also be a list of
+	// paths separated by ':' (';' on Windows), which are merged the same way
+	// kubectl merges the paths in $KUBECONFIG. When empty, the in-cluster
+	// config is tried first, and then the kube config is loaded from
+	// $KUBECONFIG or ~/.kube/config.
+	Kubeconfig string
+
+	// KubeconfigData is the content of the kube config. When set, it takes
+	// precedence over Kubeconfig.
+	KubeconfigData []byte
+
+	// Context, Cluster and User select the kube config's context, cluster
+	// and user. When Context is empty, the current context is used.
+	Context string
+	Cluster string
+	User    string
+
+	// Root is the container root, e.g. the value of TELEPRESENCE_ROOT. The
+	// in-cluster token and CA are looked up under this root. It can be a
+	// Windows path.
+	Root string
+
+	// TokenMount is the name or path of the service account token mount to
+	// use when in cluster, e.g. 'vault-token'. See TokenPaths.
+	TokenMount string
+
+	// UseDNS makes the in-cluster config use 'kubernetes.default.svc' as the
+	// server instead of the IP given in KUBERNETES_SERVICE_HOST.
+	UseDNS bool
+
+	// HostEnv and PortEnv are the names of the env vars that hold the API
+	// server's host and port when in cluster, for the environments that
+	// rename or proxy them (custom injectors, service meshes). They default
+	// to KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT.
+	HostEnv string
+	PortEnv string
+
+	// Server is the API server URL used with the in-cluster token and CA,
+	// e.g. 'https://10.0.0.1:6443'. When empty, the server is built from
+	// KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT.
+	//
+	// When these env vars are absent (e.g., with Telepresence or nsenter),
+	// the token mounted under Root is still used as long as Root or Server
+	// is given, and the server defaults to 'https://kubernetes.default.svc'.
+	Server string
+
+	// UserAgent can be for example "controller/v0.1.4/0848c95".
+	UserAgent string
+
+	// QPS and Burst configure the client-side rate limiting of the rest
+	// config. When zero, client-go's defaults are used (5 and 10). A
+	// negative QPS disables the rate limiting. When QPS is positive and
+	// Burst is zero, Burst defaults to DefaultBurst(QPS).
+	QPS   float32
+	Burst int
+}
+
+// RestConfig creates a rest config by first trying to find the in-cluster
+// config (i.e., in a Kubernetes pod). Otherwise, the kube config is loaded.
+// When Kubeconfig or KubeconfigData is given, the in-cluster config isn't
+// tried.
+func RestConfig(ctx context.Context, opts Options) (*rest.Config, error) {
+	var cfg *rest.Config
+	var err error
+
+	if opts.Kubeconfig != "" || len(opts.KubeconfigData) > 0 {
+		logutil.Debugf("using you local kube config since --kubeconfig was passed")
+		cfg, err = outClusterConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("error loading kube config: %w", err)
+		}
+		cfg.UserAgent = opts.UserAgent
+		setRateLimits(cfg, opts)
+		return cfg, nil
+	}
+
+	cfg, err = InClusterConfig(ctx, opts)
+	if err != nil {
+		inClusterErr := err
+		logutil.Debugf("in-cluster config was not found, now trying with your local kube config")
+		cfg, err = outClusterConfig(opts)
+		if err != nil {
+			// When in a pod but the token can't be read, the kube config
+			// error would only be confusing.
+			if errors.Is(inClusterErr, ErrNoCredentials) || errors.Is(inClusterErr, ErrTokenMount) {
+				return nil, inClusterErr
+			}
+			return nil, fmt.Errorf("error loading kube config: %w", err)
+		}
+	} else {
+		logutil.Debugf("in-cluster config found")
+	}
+
+	cfg.UserAgent = opts.UserAgent
+	setRateLimits(cfg, opts)
+
+	return cfg, nil
+}
+
+// DefaultBurst returns the burst to use when only the QPS is given, since
+// client-go refuses to create a client with a positive QPS and a zero burst.
+// It is the larger of the QPS and client-go's default burst.
+func DefaultBurst(qps float32) int {
+	burst := int(math.Ceil(float64(qps)))
+	if burst < rest.DefaultBurst {
+		burst = rest.DefaultBurst
+	}
+	return burst
+}
+
+func setRateLimits(cfg *rest.Config, opts Options) {
+	cfg.QPS, cfg.Burst = opts.QPS, opts.Burst
+	if opts.QPS > 0 && opts.Burst == 0 {
+		cfg.Burst = DefaultBurst(opts.QPS)
+	}
+}
+
+func outClusterConfig(opts Options) (*rest.Config, error) {
+	apicfg, err := LoadKubeconfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error loading kubeconfig: %w", err)
+	}
+
+	if opts.Context == "" && apicfg.CurrentContext == "" {
+		return nil, ErrNoContext
+	}
+
+	return clientcmd.NewDefaultClientConfig(*apicfg, &clientcmd.ConfigOverrides{
+		CurrentContext: opts.Context,
+		Context: clientcmdapi.Context{
+			Cluster:  opts.Cluster,
+			AuthInfo: opts.User,
+		},
+	}).ClientConfig()
+}
+
+// LoadKubeconfig loads the kube config given in the options. When neither
+// Kubeconfig nor KubeconfigData is set, the kube config is loaded from
+// $KUBECONFIG or ~/.kube/config.
+func LoadKubeconfig(opts Options) (*clientcmdapi.Config, error) {
+	if len(opts.KubeconfigData) > 0 {
+		return clientcmd.Load(opts.KubeconfigData)
+	}
+
+	loadRules := clientcmd.NewDefaultClientConfigLoadingRules()
+
+	// Like with $KUBECONFIG, the first file to set a value wins, and the
+	// files that don't exist are ignored.
+	if paths := filepath.SplitList(opts.Kubeconfig); len(paths) > 1 {
+		loadRules.Precedence = paths
+		return loadRules.Load()
+	}
+
+	loadRules.ExplicitPath = opts.Kubeconfig
+	return loadRules.Load()
+}
+
+// InClusterConfig is the vendored version of rest.InClusterConfig:
+// https://github.com/kubernetes/client-go/blob/fb61a7c/rest/config.go
+//
+// Unlike rest.InClusterConfig, the token and CA are looked up under the
+// container root given in the options, and the token mount can be picked.
+// When KUBERNETES_SERVICE_HOST isn't set, the token mounted under the root is
+// used if Root or Server is given, see Options.Server.
+func InClusterConfig(ctx context.Context, opts Options) (*rest.Config, error) {
+	hostEnv, portEnv := HostPortEnv(opts)
+	host, port := os.Getenv(hostEnv), os.Getenv(portEnv)
+	inPod := len(host) > 0 && len(port) > 0
+	if !inPod && opts.Root == "" && opts.Server == "" {
+		return nil, rest.ErrNotInCluster
+	}
+
+	tokenPath, caPath, err := TokenPaths(opts)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		tokenFile  = InRoot(opts.Root, tokenPath)
+		rootCAFile = InRoot(opts.Root, caPath)
+	)
+
+	// Without the env vars, the mount is the only sign of being in cluster.
+	if _, err := os.Stat(tokenFile); !inPod && err != nil {
+		logutil.Debugf("%s is not set and no token was found at %s", hostEnv, tokenFile)
+		return nil, rest.ErrNotInCluster
+	}
+
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoCredentials, err)
+	}
+
+	tlsClientConfig := rest.TLSClientConfig{}
+
+	if _, err := certutil.NewPool(rootCAFile); err != nil {
+		logutil.Errorf("expected to load root CA config from %s, but got err: %v", rootCAFile, err)
+	} else {
+		tlsClientConfig.CAFile = rootCAFile
+	}
+
+	var server string
+	switch {
+	case opts.Server != "":
+		server = opts.Server
+	case !inPod:
+		logutil.Debugf("%s is not set, using https://kubernetes.default.svc as the server", hostEnv)
+		server = "https://kubernetes.default.svc"
+	case opts.UseDNS:
+		host = "kubernetes.default.svc"
+		tlsClientConfig.ServerName = host
+		server = "https://" + net.JoinHostPort(host, port)
+	default:
+		server = "https://" + net.JoinHostPort(host, port)
+	}
+
+	return &rest.Config{
+		Host:            server,
+		TLSClientConfig: tlsClientConfig,
+		BearerToken:     string(token),
+		BearerTokenFile: tokenFile,
+	}, nil
+}
+
+// HostPortEnv returns the names of the env vars that hold the API server's
+// host and port when in cluster, see Options.HostEnv.
+func HostPortEnv(opts Options) (host, port string) {
+	host, port = opts.HostEnv, opts.PortEnv
+	if host == "" {
+		host = "KUBERNETES_SERVICE_HOST"
+	}
+	if port == "" {
+		port = "KUBERNETES_SERVICE_PORT"
+	}
+	return host, port
+}
+
+// InRoot returns the path of the given container path (always using forward
+// slashes, e.g. /var/run/secrets) on the local filesystem, taking the
+// container root into account. The root may be a Windows path, e.g.
+// 'C:\Users\me\telfs-1234'.
+func InRoot(root, containerPath string) string {
+	if root == "" {
+		return filepath.FromSlash(containerPath)
+	}
+	return filepath.Join(root, filepath.FromSlash(containerPath))
+}
@@ -0,0 +1,29 @@
+package incluster
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DecodeJWT decodes the payload of the given JWT into claims, which is a
+// pointer to a struct with json tags or to a map. The signature isn't
+// verified.
+func DecodeJWT(token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("expected 3 dot-separated parts, got %d", len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("decoding the JWT payload: %w", err)
+	}
+
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return fmt.Errorf("decoding the JWT claims: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package incluster
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// KubeconfigOptions control how the kube config is built out of the rest
+// config.
+type KubeconfigOptions struct {
+	// CAData replaces the CA of the rest config when set, e.g. with the CA
+	// of mitmproxy.
+	CAData []byte
+
+	// Namespace is the namespace set in the kube config's context.
+	Namespace string
+
+	// KeepExec copies the exec or auth-provider configuration of the rest
+	// config to the kube config. Without it, the kube config has no
+	// credentials when the rest config relies on an exec plugin.
+	KeepExec bool
+
+	// NoEmbed references the CA, client certificate, client key and token
+	// files by path instead of embedding their content, which means that a
+	// rotated token (e.g., a projected token) is picked up. The data that
+	// doesn't come from a file, such as a token fetched with the
+	// TokenRequest API, is still embedded.
+	NoEmbed bool
+
+	// StripRoot is removed from the beginning of the file paths when NoEmbed
+	// is set, e.g. the container root, so that the kube config works inside
+	// the container.
+	StripRoot string
+}
+
+// path returns the path to write to the kube config for the given file.
+func (opts KubeconfigOptions) path(file string) string {
+	if opts.StripRoot == "" {
+		return file
+	}
+	rel, err := filepath.Rel(opts.StripRoot, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return file
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// KubeconfigFromRestConfig builds a kube config with a single context named
+// "kubectl-incluster" out of the given rest config. Unless NoEmbed is set,
+// the CA, client certificate, client key and token are embedded in the kube
+// config as base64 strings instead of file paths so that the kube config can
+// be used somewhere else.
+// https://github.com/kubernetes/client-go/issues/711
+func KubeconfigFromRestConfig(ctx context.Context, restconf *rest.Config, opts KubeconfigOptions) (*clientcmdapi.Config, error) {
+	apiconf := clientcmdapi.NewConfig()
+
+	apiconf.Clusters["kubectl-incluster"] = &clientcmdapi.Cluster{
+		Server:                restconf.Host,
+		TLSServerName:         restconf.TLSClientConfig.ServerName,
+		InsecureSkipTLSVerify: restconf.TLSClientConfig.Insecure,
+	}
+
+	apiconf.Clusters["kubectl-incluster"].CertificateAuthorityData = restconf.TLSClientConfig.CAData
+	if len(opts.CAData) > 0 {
+		apiconf.Clusters["kubectl-incluster"].CertificateAuthorityData = opts.CAData
+	} else if restconf.TLSClientConfig.CAFile != "" && opts.NoEmbed {
+		apiconf.Clusters["kubectl-incluster"].CertificateAuthorityData = nil
+		apiconf.Clusters["kubectl-incluster"].CertificateAuthority = opts.path(restconf.TLSClientConfig.CAFile)
+	} else if restconf.TLSClientConfig.CAFile != "" {
+		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		apiconf.Clusters["kubectl-incluster"].CertificateAuthorityData = bytes
+	}
+
+	// Client-go refuses kube configs that have both a CA and
+	// insecure-skip-tls-verify.
+	if restconf.TLSClientConfig.Insecure {
+		apiconf.Clusters["kubectl-incluster"].CertificateAuthorityData = nil
+		apiconf.Clusters["kubectl-incluster"].CertificateAuthority = ""
+	}
+
+	apiconf.AuthInfos["kubectl-incluster"] = &clientcmdapi.AuthInfo{}
+
+	apiconf.AuthInfos["kubectl-incluster"].ClientCertificateData = restconf.TLSClientConfig.CertData
+	if restconf.TLSClientConfig.CertFile != "" && opts.NoEmbed {
+		apiconf.AuthInfos["kubectl-incluster"].ClientCertificateData = nil
+		apiconf.AuthInfos["kubectl-incluster"].ClientCertificate = opts.path(restconf.TLSClientConfig.CertFile)
+	} else if restconf.TLSClientConfig.CertFile != "" {
+		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client certificate file: %w", err)
+		}
+		apiconf.AuthInfos["kubectl-incluster"].ClientCertificateData = bytes
+	}
+
+	apiconf.AuthInfos["kubectl-incluster"].ClientKeyData = restconf.TLSClientConfig.KeyData
+	if restconf.TLSClientConfig.KeyFile != "" && opts.NoEmbed {
+		apiconf.AuthInfos["kubectl-incluster"].ClientKeyData = nil
+		apiconf.AuthInfos["kubectl-incluster"].ClientKey = opts.path(restconf.TLSClientConfig.KeyFile)
+	} else if restconf.TLSClientConfig.KeyFile != "" {
+		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client key file: %w", err)
+		}
+		apiconf.AuthInfos["kubectl-incluster"].ClientKeyData = bytes
+	}
+
+	apiconf.AuthInfos["kubectl-incluster"].Token = restconf.BearerToken
+	if restconf.BearerTokenFile != "" && opts.NoEmbed {
+		apiconf.AuthInfos["kubectl-incluster"].Token = ""
+		apiconf.AuthInfos["kubectl-incluster"].TokenFile = opts.path(restconf.BearerTokenFile)
+	} else if restconf.BearerTokenFile != "" {
+		bytes, err := ioutil.ReadFile(restconf.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading token file: %w", err)
+		}
+
+		apiconf.AuthInfos["kubectl-incluster"].Token = string(bytes)
+	}
+
+	apiconf.AuthInfos["kubectl-incluster"].Impersonate = restconf.Impersonate.UserName
+	apiconf.AuthInfos["kubectl-incluster"].ImpersonateGroups = restconf.Impersonate.Groups
+	apiconf.AuthInfos["kubectl-incluster"].ImpersonateUserExtra = restconf.Impersonate.Extra
+
+	if opts.KeepExec {
+		apiconf.AuthInfos["kubectl-incluster"].Exec = restconf.ExecProvider
+		apiconf.AuthInfos["kubectl-incluster"].AuthProvider = restconf.AuthProvider
+	}
+
+	apiconf.CurrentContext = "kubectl-incluster"
+	apiconf.Contexts["kubectl-incluster"] = clientcmdapi.NewContext()
+	apiconf.Contexts["kubectl-incluster"].Cluster = "kubectl-incluster"
+	apiconf.Contexts["kubectl-incluster"].AuthInfo = "kubectl-incluster"
+	apiconf.Contexts["kubectl-incluster"].Namespace = opts.Namespace
+
+	return apiconf, nil
+}
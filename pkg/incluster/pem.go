@@ -0,0 +1,93 @@
+package incluster
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"k8s.io/client-go/rest"
+)
+
+// ClientCertPEM returns the PEM bundle made of the client key and client
+// certificate of the given rest config. The PEM-encoded private key is
+// displayed first.
+func ClientCertPEM(restconf *rest.Config) ([]byte, error) {
+	if restconf.TLSClientConfig.KeyFile == "" && len(restconf.TLSClientConfig.KeyData) == 0 && restconf.BearerTokenFile != "" {
+		return nil, fmt.Errorf("%w: cannot produce a PEM client certificate bundle when the kube config uses a token", ErrNoCredentials)
+	}
+
+	key, err := clientKey(restconf)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := clientCert(restconf)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), key...), cert...), nil
+}
+
+// ClientKeyPEM returns the PEM-encoded client key of the given rest config.
+func ClientKeyPEM(restconf *rest.Config) ([]byte, error) {
+	key, err := clientKey(restconf)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: no client-key-data nor client-key", ErrNoCredentials)
+	}
+	return key, nil
+}
+
+// ClientCertOnlyPEM returns the PEM-encoded client certificate of the given
+// rest config, without the client key.
+func ClientCertOnlyPEM(restconf *rest.Config) ([]byte, error) {
+	cert, err := clientCert(restconf)
+	if err != nil {
+		return nil, err
+	}
+	if len(cert) == 0 {
+		return nil, fmt.Errorf("%w: no client-certificate-data nor client-certificate", ErrNoCredentials)
+	}
+	return cert, nil
+}
+
+func clientKey(restconf *rest.Config) ([]byte, error) {
+	if restconf.TLSClientConfig.KeyFile != "" {
+		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client key file: %w", err)
+		}
+		return bytes, nil
+	}
+	return restconf.TLSClientConfig.KeyData, nil
+}
+
+func clientCert(restconf *rest.Config) ([]byte, error) {
+	if len(restconf.TLSClientConfig.CertData) > 0 {
+		return restconf.TLSClientConfig.CertData, nil
+	}
+	if restconf.TLSClientConfig.CertFile != "" {
+		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client certificate file: %w", err)
+		}
+		return bytes, nil
+	}
+	return nil, nil
+}
+
+// CACertPEM returns the PEM-encoded CA of the given rest config.
+func CACertPEM(restconf *rest.Config) ([]byte, error) {
+	if len(restconf.TLSClientConfig.CAData) > 0 {
+		return restconf.TLSClientConfig.CAData, nil
+	} else if restconf.TLSClientConfig.CAFile != "" {
+		bytes, err := ioutil.ReadFile(restconf.TLSClientConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client certificate file: %w", err)
+		}
+
+		return bytes, nil
+	}
+
+	return nil, fmt.Errorf("%w: no ca-certificate-data nor ca-certificate-file", ErrNoCredentials)
+}
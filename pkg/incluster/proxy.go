@@ -0,0 +1,62 @@
+package incluster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"k8s.io/client-go/rest"
+)
+
+// WrapForProxy returns a copy of the given rest config that sends its traffic
+// through the given HTTP proxy (e.g., mitmproxy). It does the same
+// transformation as kubectl-incluster does when HTTPS_PROXY is set:
+//
+//   - the proxy is set on the transport. Unlike HTTPS_PROXY, it is also used
+//     when the server is "localhost" or "127.0.0.1",
+//   - the proxy's CA is appended to the CA of the rest config so that the
+//     certificates presented by the proxy are trusted,
+//   - the client certificate is dropped in favor of the token, since mitmproxy
+//     can't forward client certificates. An error is returned when the rest
+//     config has no token, exec plugin or auth provider.
+//
+// The proxyCA may be nil, in which case the CA is left untouched.
+func WrapForProxy(cfg *rest.Config, proxyURL string, proxyCA []byte) (*rest.Config, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the proxy URL %q: %w", proxyURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("the proxy URL %q has no host, expected a URL of the form 'http://localhost:9090'", proxyURL)
+	}
+
+	cfg = rest.CopyConfig(cfg)
+	cfg.Proxy = http.ProxyURL(u)
+
+	if len(proxyCA) > 0 {
+		ca := cfg.TLSClientConfig.CAData
+		if len(ca) == 0 && cfg.TLSClientConfig.CAFile != "" {
+			ca, err = ioutil.ReadFile(cfg.TLSClientConfig.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA file: %w", err)
+			}
+		}
+		merged := append([]byte{}, ca...)
+		if len(merged) > 0 && merged[len(merged)-1] != '\n' {
+			merged = append(merged, '\n')
+		}
+		cfg.TLSClientConfig.CAData = append(merged, proxyCA...)
+		cfg.TLSClientConfig.CAFile = ""
+	}
+
+	if cfg.BearerToken == "" && cfg.BearerTokenFile == "" && cfg.ExecProvider == nil && cfg.AuthProvider == nil {
+		return nil, fmt.Errorf("%w: the rest config has no token, and client certificates can't go through the proxy", ErrNoCredentials)
+	}
+	cfg.TLSClientConfig.CertData = nil
+	cfg.TLSClientConfig.CertFile = ""
+	cfg.TLSClientConfig.KeyData = nil
+	cfg.TLSClientConfig.KeyFile = ""
+
+	return cfg, nil
+}
@@ -0,0 +1,214 @@
+package incluster
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// DefaultTokenMount is where the service account token, CA and namespace are
+// mounted in pods.
+const DefaultTokenMount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// ProjectedTokensDir is where projected service account tokens are usually
+// mounted, e.g. /var/run/secrets/tokens/vault-token.
+const ProjectedTokensDir = "/var/run/secrets/tokens"
+
+// A tokenMount is a service account token found in one of the container's
+// mounts. The paths are relative to the container root.
+type tokenMount struct {
+	Name      string
+	TokenPath string
+	CAPath    string // Empty when the mount has no ca.crt.
+}
+
+// discoverTokenMounts scans <root>/proc/mounts for mounts that look like
+// service account tokens. Runtimes like Argo or pods with bound projected
+// volumes mount tokens at places like /var/run/secrets/tokens/<name> instead
+// of the usual /var/run/secrets/kubernetes.io/serviceaccount.
+//
+// A mount that contains a file named "token" is named after its directory
+// (e.g., "serviceaccount"). Otherwise, each file in the mount that contains a
+// JWT is considered a token named after the file (e.g., "vault-token"), which
+// leaves out the other secrets (e.g., a tls.key) that happen to be mounted
+// under a path containing "secrets".
+func discoverTokenMounts(root string) ([]tokenMount, error) {
+	f, err := os.Open(InRoot(root, "/proc/mounts"))
+	if err != nil {
+		return nil, fmt.Errorf("while reading the list of mounts: %w", err)
+	}
+	defer f.Close()
+
+	var mounts []tokenMount
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Each line looks like:
+		//  tmpfs /var/run/secrets/kubernetes.io/serviceaccount tmpfs ro,relatime 0 0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		dir := fields[1]
+		if !strings.Contains(dir, "secrets") && !strings.Contains(dir, "token") {
+			continue
+		}
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		files, err := ioutil.ReadDir(InRoot(root, dir))
+		if err != nil {
+			logutil.Debugf("skipping mount %s: %s", dir, err)
+			continue
+		}
+
+		ca := ""
+		hasToken := false
+		for _, file := range files {
+			switch file.Name() {
+			case "ca.crt":
+				ca = path.Join(dir, "ca.crt")
+			case "token":
+				hasToken = true
+			}
+		}
+
+		if hasToken {
+			mounts = append(mounts, tokenMount{Name: path.Base(dir), TokenPath: path.Join(dir, "token"), CAPath: ca})
+			continue
+		}
+
+		for _, file := range files {
+			// Projected volumes are made of symlinks to a "..data" directory.
+			if strings.HasPrefix(file.Name(), ".") || file.IsDir() || file.Name() == "ca.crt" || file.Name() == "namespace" {
+				continue
+			}
+			tokenPath := path.Join(dir, file.Name())
+			if !isJWTFile(InRoot(root, tokenPath)) {
+				logutil.Debugf("skipping %s since it isn't a JWT", tokenPath)
+				continue
+			}
+			mounts = append(mounts, tokenMount{Name: file.Name(), TokenPath: tokenPath, CAPath: ca})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("while reading the list of mounts: %w", err)
+	}
+
+	return mounts, nil
+}
+
+// isJWTFile returns true when the file contains a JWT, which is what service
+// account tokens are.
+func isJWTFile(file string) bool {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	var claims map[string]interface{}
+	return DecodeJWT(strings.TrimSpace(string(bytes)), &claims) == nil
+}
+
+// findTokenMount returns the token mount matching the given name. The name can
+// either be the name of the mount (e.g., "vault-token") or the path to the
+// token file or to its directory.
+func findTokenMount(root, name string) (tokenMount, error) {
+	mounts, err := discoverTokenMounts(root)
+	if err != nil {
+		logutil.Debugf("%s", err)
+	}
+
+	for _, m := range mounts {
+		if m.Name == name || m.TokenPath == name || path.Dir(m.TokenPath) == path.Clean(name) {
+			return m, nil
+		}
+	}
+
+	// The token may not be listed in /proc/mounts, e.g. when it is a file
+	// of a projected volume that isn't named after a secret or a token, or
+	// when the container root was copied without /proc. A name that isn't a
+	// path is looked up in /var/run/secrets/tokens, which is where projected
+	// tokens are usually mounted.
+	file := name
+	if !path.IsAbs(file) {
+		file = path.Join(ProjectedTokensDir, name)
+	}
+	if info, statErr := os.Stat(InRoot(root, file)); statErr == nil && !info.IsDir() {
+		m := tokenMount{Name: path.Base(file), TokenPath: file}
+		if _, err := os.Stat(InRoot(root, path.Join(path.Dir(file), "ca.crt"))); err == nil {
+			m.CAPath = path.Join(path.Dir(file), "ca.crt")
+		}
+		return m, nil
+	}
+	if err != nil {
+		return tokenMount{}, err
+	}
+
+	return tokenMount{}, fmt.Errorf("no token mount named %q was found, the following were found: %s", name, tokenMountNames(mounts))
+}
+
+func tokenMountNames(mounts []tokenMount) string {
+	var names []string
+	for _, m := range mounts {
+		names = append(names, fmt.Sprintf("%s (%s)", m.Name, m.TokenPath))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// pickedTokenMounts holds the token paths picked by TokenPaths, which is
+// called several times per run, so that the choice is only logged once.
+var pickedTokenMounts sync.Map
+
+// TokenPaths returns the paths to the token and CA files, relative to the
+// container root. When no token mount is given in the options and nothing is
+// mounted at the default location, the mounts are scanned and the token is
+// used if there is only one. The errors about the token mount wrap
+// ErrTokenMount.
+func TokenPaths(opts Options) (token, ca string, _ error) {
+	defaultToken, defaultCA := DefaultTokenMount+"/token", DefaultTokenMount+"/ca.crt"
+
+	if opts.TokenMount != "" {
+		m, err := findTokenMount(opts.Root, opts.TokenMount)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %s", ErrTokenMount, err)
+		}
+		if m.CAPath == "" {
+			m.CAPath = defaultCA
+		}
+		logutil.Debugf("using the token mount %s at %s", m.Name, m.TokenPath)
+		return m.TokenPath, m.CAPath, nil
+	}
+
+	if _, err := os.Stat(InRoot(opts.Root, defaultToken)); !os.IsNotExist(err) {
+		return defaultToken, defaultCA, nil
+	}
+
+	mounts, err := discoverTokenMounts(opts.Root)
+	if err != nil || len(mounts) == 0 {
+		logutil.Debugf("no token found at %s and no other token mount was found: %v", defaultToken, err)
+		return defaultToken, defaultCA, nil
+	}
+	if len(mounts) > 1 {
+		return "", "", fmt.Errorf("%w: no token found at %s, and several token mounts were found: %s", ErrTokenMount, defaultToken, tokenMountNames(mounts))
+	}
+
+	m := mounts[0]
+	if m.CAPath == "" {
+		m.CAPath = defaultCA
+	}
+	if _, logged := pickedTokenMounts.LoadOrStore(m.TokenPath, true); !logged {
+		logutil.Infof("no token found at %s, using the token mount %s at %s", defaultToken, m.Name, m.TokenPath)
+	}
+	return m.TokenPath, m.CAPath, nil
+}
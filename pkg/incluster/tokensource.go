@@ -0,0 +1,165 @@
+package incluster
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// A TokenSource hands out a service account token and fetches a new one
+// before the current one expires. Long-running out-of-cluster processes that
+// use an extracted service account identity can use it so that they don't
+// start failing with "Unauthorized" once the token expires:
+//
+//	ts := incluster.NewTokenRequestSource(cl, "cert-manager", "cert-manager", time.Hour)
+//	restcfg.BearerToken, restcfg.BearerTokenFile = "", ""
+//	restcfg.WrapTransport = ts.WrapTransport
+type TokenSource struct {
+	fetch func(ctx context.Context) (token string, expiry time.Time, _ error)
+
+	mu      sync.Mutex
+	token   string
+	refresh time.Time // When to fetch a new token.
+}
+
+// MinTokenExpiration is the shortest expiration the TokenRequest API accepts.
+const MinTokenExpiration = 10 * time.Minute
+
+// NewTokenRequestSource returns a TokenSource that requests tokens for the
+// given service account using the TokenRequest API. The client needs the
+// permission to create the 'serviceaccounts/token' subresource. The API
+// server may issue tokens with a different expiration than the requested one.
+//
+// When the expiration is 0, the API server picks it (one hour by default).
+// An expiration shorter than MinTokenExpiration is raised to
+// MinTokenExpiration since the API server would reject it.
+func NewTokenRequestSource(cl kubernetes.Interface, namespace, serviceaccount string, expiration time.Duration) *TokenSource {
+	var spec authenticationv1.TokenRequestSpec
+	if expiration > 0 {
+		if expiration < MinTokenExpiration {
+			logutil.Debugf("the token expiration %s is shorter than the minimum, using %s instead", expiration, MinTokenExpiration)
+			expiration = MinTokenExpiration
+		}
+		secs := int64(expiration / time.Second)
+		spec.ExpirationSeconds = &secs
+	}
+
+	return &TokenSource{fetch: func(ctx context.Context) (string, time.Time, error) {
+		resp, err := cl.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, serviceaccount, &authenticationv1.TokenRequest{
+			Spec: spec,
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("requesting a token for serviceaccount %s in namespace %s: %w", serviceaccount, namespace, err)
+		}
+		return resp.Status.Token, resp.Status.ExpirationTimestamp.Time, nil
+	}}
+}
+
+// NewFileTokenSource returns a TokenSource that reads the token from the
+// given file, e.g. a projected service account token that the kubelet
+// rotates. The file is read again when the token is about to expire according
+// to its 'exp' claim, or every minute when the token isn't a JWT.
+func NewFileTokenSource(path string) *TokenSource {
+	return &TokenSource{fetch: func(ctx context.Context) (string, time.Time, error) {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("reading token file: %w", err)
+		}
+		token := strings.TrimSpace(string(bytes))
+
+		expiry, err := jwtExpiry(token)
+		if err != nil {
+			logutil.Debugf("the token in %s has no expiry, reading it again in a minute: %s", path, err)
+			return token, time.Now().Add(time.Minute), nil
+		}
+		return token, expiry, nil
+	}}
+}
+
+// Token returns the current token, fetching a new one when 80% of its
+// lifetime has elapsed.
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.refresh) {
+		return s.token, nil
+	}
+
+	token, expiry, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	s.token = token
+	s.refresh = now.Add(expiry.Sub(now) * 8 / 10)
+	logutil.Debugf("fetched a new token, refreshing it at %s", s.refresh.Format(time.RFC3339))
+
+	return s.token, nil
+}
+
+// invalidate forces the next call to Token to fetch a new token.
+func (s *TokenSource) invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// WrapTransport sets the token as the Authorization header of each request.
+// Its signature matches rest.Config.WrapTransport. The rest config's
+// BearerToken and BearerTokenFile should be left empty.
+func (s *TokenSource) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return &tokenRoundTripper{source: s, next: rt}
+}
+
+type tokenRoundTripper struct {
+	source *TokenSource
+	next   http.RoundTripper
+}
+
+func (rt *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := rt.source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// A RoundTripper mustn't modify the given request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := rt.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// The token may have been revoked or may have expired early, let's
+		// fetch a new one for the next request.
+		rt.source.invalidate(token)
+	}
+	return resp, err
+}
+
+// jwtExpiry returns the expiry found in the 'exp' claim of the given JWT. The
+// signature isn't verified.
+func jwtExpiry(token string) (time.Time, error) {
+	var claims struct {
+		Expiry int64 `json:"exp"`
+	}
+	if err := DecodeJWT(token, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.Expiry == 0 {
+		return time.Time{}, fmt.Errorf("the JWT has no 'exp' claim")
+	}
+
+	return time.Unix(claims.Expiry, 0), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// configProxyURL returns the proxy the rest config goes through, which is
+// the proxy-url of the kube config's cluster, or nil when there is none.
+func configProxyURL(c *rest.Config) *url.URL {
+	if c.Proxy == nil {
+		return nil
+	}
+	req, err := http.NewRequest("GET", c.Host, nil)
+	if err != nil {
+		return nil
+	}
+	u, err := c.Proxy(req)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// warnProxyChain warns when the source kube config has a proxy-url (e.g., a
+// corporate proxy) and another proxy (e.g., mitmproxy) is given. A kube
+// config can only have one proxy, which means the generated kube config goes
+// through the given proxy only, and the requests fail when the API server
+// can only be reached through the proxy-url. To chain the two, mitmproxy has
+// to forward its traffic to the other proxy with '--mode upstream'.
+func warnProxyChain(c *rest.Config, proxy string) {
+	upstream := configProxyURL(c)
+	if upstream == nil || upstream.String() == proxy {
+		return
+	}
+	logutil.Warnf("the kube config's cluster goes through the proxy-url %s, but the generated kube config goes through %s instead since a kube config can't chain proxies. If the API server can only be reached through %s, start mitmproxy with '--mode upstream:%s', or use 'kubectl incluster mitm --upstream-proxy %s'", upstream, proxy, upstream, upstream, upstream)
+}
+
+// warnProxyEnvIgnored warns when HTTPS_PROXY is set to another proxy than the
+// one written as proxy-url in the generated kube config, since the clients
+// (kubectl, client-go) ignore HTTPS_PROXY when proxy-url is set.
+func warnProxyEnvIgnored(proxyURL string) {
+	env := os.Getenv("HTTPS_PROXY")
+	if env == "" || env == proxyURL {
+		return
+	}
+	logutil.Warnf("HTTPS_PROXY is set to %s, but the clients using the generated kube config will ignore it since its proxy-url %s takes precedence", env, proxyURL)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// rancherAPICluster is a cluster as returned by the Rancher v3 API.
+type rancherAPICluster struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// useRancher mints the kube config of a cluster managed by Rancher with the
+// 'generateKubeconfig' action of the Rancher v3 API, the same as the
+// "Download KubeConfig" button of the Rancher UI, and uses it as the source
+// kube config instead of the one given with --kubeconfig. The API key is of
+// the form 'token-xxxxx:secret' and is read from the file given with
+// --rancher-token. The cluster is given with --rancher-cluster as a name or an
+// ID (e.g., 'c-m-abcd1234'); it can be omitted when Rancher manages a single
+// cluster. Since the kube config generated for a cluster with the authorized
+// cluster endpoint enabled has one context per endpoint, --context picks one.
+//
+// Like the rancher CLI, the system CA bundle is used to verify Rancher's
+// certificate; set $SSL_CERT_FILE when Rancher uses a private CA.
+func useRancher(ctx context.Context, addr, tokenPath, cluster string) error {
+	if tokenPath == "" {
+		return flagErrorf("--rancher-server requires --rancher-token")
+	}
+	if *kubeconfig != "" {
+		return flagErrorf("--rancher-server can't be used with --kubeconfig")
+	}
+	if *server != "" {
+		return flagErrorf("--rancher-server can't be used with --server")
+	}
+	serverURL, err := url.Parse(addr)
+	if err != nil || serverURL.Scheme == "" || serverURL.Host == "" {
+		return flagErrorf("--rancher-server: expected a URL such as 'https://rancher.example.com', got: %s", addr)
+	}
+
+	content, err := readFile(tokenPath)
+	if err != nil {
+		return fmt.Errorf("while processing flag --rancher-token: %w", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return fmt.Errorf("%w: the file given with --rancher-token is empty", incluster.ErrNoCredentials)
+	}
+
+	rancher := &rancherClient{
+		addr:  strings.TrimSuffix(serverURL.String(), "/"),
+		token: token,
+		http:  &http.Client{Timeout: defaultRequestTimeout},
+	}
+
+	var clusters struct {
+		Data []rancherAPICluster `json:"data"`
+	}
+	if err := rancher.do(ctx, "GET", "/v3/clusters", &clusters); err != nil {
+		return fmt.Errorf("while processing flag --rancher-server: listing the clusters: %w", err)
+	}
+	id, err := pickRancherCluster(clusters.Data, cluster)
+	if err != nil {
+		return err
+	}
+
+	var generated struct {
+		Config string `json:"config"`
+	}
+	if err := rancher.do(ctx, "POST", "/v3/clusters/"+url.PathEscape(id)+"?action=generateKubeconfig", &generated); err != nil {
+		return fmt.Errorf("while processing flag --rancher-server: generating the kube config of the cluster %s: %w", id, err)
+	}
+	if _, err := clientcmd.Load([]byte(generated.Config)); err != nil {
+		return fmt.Errorf("while processing flag --rancher-server: the kube config generated by Rancher for the cluster %s is invalid: %w", id, err)
+	}
+	logutil.Debugf("using the kube config generated by Rancher for the cluster %s", id)
+
+	sourceKubeconfig = []byte(generated.Config)
+	return nil
+}
+
+// pickRancherCluster returns the ID of the cluster whose name or ID is the
+// given one. When none is given, the only cluster is picked.
+func pickRancherCluster(clusters []rancherAPICluster, nameOrID string) (string, error) {
+	var names []string
+	for _, c := range clusters {
+		if nameOrID != "" && (c.ID == nameOrID || c.Name == nameOrID) {
+			return c.ID, nil
+		}
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+
+	switch {
+	case len(clusters) == 0:
+		return "", fmt.Errorf("while processing flag --rancher-server: the Rancher API key doesn't give access to any cluster")
+	case nameOrID != "":
+		return "", flagErrorf("--rancher-cluster: no cluster named %s, pick one of %s", nameOrID, strings.Join(names, ", "))
+	case len(clusters) > 1:
+		return "", flagErrorf("--rancher-server: Rancher manages several clusters, pick one of %s with --rancher-cluster", strings.Join(names, ", "))
+	}
+	return clusters[0].ID, nil
+}
+
+type rancherClient struct {
+	addr  string
+	token string
+	http  *http.Client
+}
+
+// do sends a request to the Rancher API and decodes the JSON response into
+// out.
+func (r *rancherClient) do(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, r.addr+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading the response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%w: Rancher refused the API key given with --rancher-token", incluster.ErrNoCredentials)
+	case resp.StatusCode/100 != 2:
+		var rancherErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &rancherErr) == nil && rancherErr.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, rancherErr.Message)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding the response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// redacted is what replaces the secret parts of the kube config with
+// --redact.
+const redacted = "REDACTED"
+
+// redactKubeconfig returns a copy of the kube config in which the tokens,
+// passwords, client keys and certificates are replaced with placeholders that
+// keep their structure, so that the kube config can be shared in a bug report
+// without leaking credentials: a JWT stays a JWT with the same header and the
+// same claim names, and PEM data keeps the same number and types of blocks.
+// The file paths (e.g., with --no-embed) are kept as is.
+func redactKubeconfig(kubeconfig *clientcmdapi.Config) *clientcmdapi.Config {
+	kubeconfig = kubeconfig.DeepCopy()
+
+	for _, cluster := range kubeconfig.Clusters {
+		cluster.CertificateAuthorityData = redactPEM(cluster.CertificateAuthorityData)
+	}
+	for _, user := range kubeconfig.AuthInfos {
+		user.Token = redactToken(user.Token)
+		if user.Password != "" {
+			user.Password = redacted
+		}
+		user.ClientCertificateData = redactPEM(user.ClientCertificateData)
+		user.ClientKeyData = redactPEM(user.ClientKeyData)
+		if user.AuthProvider != nil {
+			for k, v := range user.AuthProvider.Config {
+				if v != "" && (strings.Contains(k, "token") || strings.Contains(k, "secret")) {
+					user.AuthProvider.Config[k] = redactToken(v)
+				}
+			}
+		}
+	}
+
+	return kubeconfig
+}
+
+// redactPEM replaces the content of each PEM block with a placeholder. The
+// data is entirely replaced when it isn't PEM.
+func redactPEM(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+
+	var out []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		out = append(out, "-----BEGIN "+block.Type+"-----\n"+redacted+"\n-----END "+block.Type+"-----\n"...)
+	}
+	if out == nil {
+		return []byte(redacted)
+	}
+	return out
+}
+
+// redactToken replaces the token with a placeholder. When the token is a JWT
+// (e.g., a service account token), the header is kept and the value of each
+// claim is replaced, which shows which claims the token has (e.g., whether it
+// is bound to a pod) without showing the identity or the signature.
+func redactToken(token string) string {
+	if token == "" {
+		return ""
+	}
+
+	var claims map[string]interface{}
+	if err := incluster.DecodeJWT(token, &claims); err != nil {
+		return redacted
+	}
+	for k := range claims {
+		claims[k] = redacted
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return redacted
+	}
+
+	header := strings.Split(token, ".")[0]
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + redacted
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// restConfigJSON is what -o rest-config prints. It is a subset of the
+// rest.Config fields that Kubernetes clients in other languages (e.g.,
+// Python and JavaScript) need. The byte fields are base64-encoded PEM. Empty
+// fields are omitted. The schema is documented in the README, don't change
+// the field names.
+type restConfigJSON struct {
+	Host        string                 `json:"host"`
+	BearerToken string                 `json:"bearerToken,omitempty"`
+	Username    string                 `json:"username,omitempty"`
+	Password    string                 `json:"password,omitempty"`
+	Impersonate *restConfigImpersonate `json:"impersonate,omitempty"`
+	TLS         restConfigTLS          `json:"tls"`
+	ProxyURL    string                 `json:"proxyURL,omitempty"`
+	Namespace   string                 `json:"namespace,omitempty"`
+	QPS         float32                `json:"qps,omitempty"`
+	Burst       int                    `json:"burst,omitempty"`
+}
+
+type restConfigImpersonate struct {
+	UserName string   `json:"userName,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+type restConfigTLS struct {
+	Insecure   bool   `json:"insecure"`
+	ServerName string `json:"serverName,omitempty"`
+	CAData     []byte `json:"caData,omitempty"`
+	CertData   []byte `json:"certData,omitempty"`
+	KeyData    []byte `json:"keyData,omitempty"`
+}
+
+// restConfigOutput turns the kube config back into a rest.Config, the same
+// way kubectl would, and serializes it as JSON.
+func restConfigOutput(kubeconfig *clientcmdapi.Config) ([]byte, error) {
+	clientConfig := clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{})
+	c, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading the kube config: %w", err)
+	}
+	if c.ExecProvider != nil || c.AuthProvider != nil {
+		return nil, fmt.Errorf("the credentials use an exec or auth provider plugin, use a token or a client certificate instead")
+	}
+	ns, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, fmt.Errorf("loading the namespace: %w", err)
+	}
+
+	out := restConfigJSON{
+		Host:        c.Host,
+		BearerToken: c.BearerToken,
+		Username:    c.Username,
+		Password:    c.Password,
+		TLS: restConfigTLS{
+			Insecure:   c.Insecure,
+			ServerName: c.ServerName,
+			CAData:     c.CAData,
+			CertData:   c.CertData,
+			KeyData:    c.KeyData,
+		},
+		Namespace: ns,
+		QPS:       *qps,
+		Burst:     *burst,
+	}
+	if c.Impersonate.UserName != "" || len(c.Impersonate.Groups) > 0 {
+		out.Impersonate = &restConfigImpersonate{UserName: c.Impersonate.UserName, Groups: c.Impersonate.Groups}
+	}
+
+	// The rest config only has the proxy as a func, so we read the URL from
+	// the kube config.
+	if cluster, _, err := currentClusterAndUser(kubeconfig); err == nil {
+		out.ProxyURL = cluster.ProxyURL
+	}
+
+	content, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("serializing the rest config: %w", err)
+	}
+	return append(content, '\n'), nil
+}
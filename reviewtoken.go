@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// reviewToken submits the token of the resolved credentials to the
+// TokenReview API and reports the user it authenticates as, its audiences
+// and its expiry to stderr. It is used with --validate-token to make sure
+// that a token works before embedding it. Creating TokenReviews requires a
+// permission that most tokens don't have (e.g., the ClusterRole
+// 'system:auth-delegator'); when it is missing, only the expiry found in the
+// token (JWT) is checked.
+func reviewToken(ctx context.Context, c *rest.Config) error {
+	token, err := userToken(&clientcmdapi.AuthInfo{Token: c.BearerToken, TokenFile: c.BearerTokenFile})
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return fmt.Errorf("%w: --validate-token requires a token, use --force-token or --serviceaccount", incluster.ErrNoCredentials)
+	}
+
+	var expiry time.Time
+	if claims, err := decodeJWT(token); err == nil && claims.Expiry != 0 {
+		expiry = time.Unix(claims.Expiry, 0)
+	}
+	expiresIn := "never expires"
+	if !expiry.IsZero() {
+		expiresIn = fmt.Sprintf("expires on %s (in %s)", expiry.UTC().Format(time.RFC3339), time.Until(expiry).Round(time.Second))
+	}
+	if !expiry.IsZero() && time.Now().After(expiry) {
+		return fmt.Errorf("%w: the token expired on %s", incluster.ErrNoCredentials, expiry.UTC().Format(time.RFC3339))
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+	review, err := cl.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	switch {
+	case k8serrors.IsForbidden(err):
+		logutil.Warnf("the token can't be validated since the credentials aren't allowed to create TokenReviews, it %s", expiresIn)
+		return nil
+	case err != nil:
+		return fmt.Errorf("while processing flag --validate-token: creating a TokenReview: %w", err)
+	case !review.Status.Authenticated:
+		return fmt.Errorf("%w: the token isn't valid: %s", incluster.ErrNoCredentials, review.Status.Error)
+	}
+
+	user := review.Status.User
+	logutil.Infof("the token is valid, it authenticates as %s and %s", user.Username, expiresIn)
+	if len(user.Groups) > 0 {
+		logutil.Infof("groups: %s", strings.Join(user.Groups, ", "))
+	}
+	if len(review.Status.Audiences) > 0 {
+		logutil.Infof("audiences: %s", strings.Join(review.Status.Audiences, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// rootCandidate is a container root found in the environment, along with
+// where it was found, e.g. 'TELEPRESENCE_ROOT'.
+type rootCandidate struct {
+	Path   string
+	Source string
+}
+
+func (c rootCandidate) String() string {
+	return fmt.Sprintf("%s (%s)", c.Path, c.Source)
+}
+
+// resolveRoot sets --root when it isn't given. The candidates are
+// CONTAINER_ROOT and TELEPRESENCE_ROOT, and the Telepresence 2 mounts when
+// neither is set. With '--root auto', the candidates are the mounts of the
+// active Telepresence intercepts instead. Instead of silently picking one of
+// several candidates, --root-index or --interactive is required to choose,
+// unless the in-cluster config is skipped with --kubeconfig or --server.
+func resolveRoot() error {
+	if *root == "auto" {
+		candidates, err := telepresenceInterceptRoots()
+		if err != nil {
+			return fmt.Errorf("--root auto: %w", err)
+		}
+		if len(candidates) == 0 {
+			return fmt.Errorf("--root auto: no active Telepresence intercept with a mounted service account token was found")
+		}
+		return pickRoot(candidates)
+	}
+
+	if flags.Changed("root") {
+		if *rootIndex != 0 {
+			return flagErrorf("--root-index can't be used with --root")
+		}
+		return nil
+	}
+
+	// The root is only used by the in-cluster config, which --kubeconfig and
+	// --server skip. There is no need to make the user choose in that case.
+	candidates := rootCandidates()
+	if len(candidates) > 1 && *rootIndex == 0 && (*kubeconfig != "" || *server != "") {
+		logutil.Debugf("several container roots were found, not picking one since --kubeconfig or --server is given: %s", rootCandidateList(candidates))
+		*root = ""
+		return nil
+	}
+	return pickRoot(candidates)
+}
+
+// pickRoot sets --root to the only candidate, or to the one picked with
+// --root-index or --interactive.
+func pickRoot(candidates []rootCandidate) error {
+	var picked rootCandidate
+	switch {
+	case *rootIndex != 0 && len(candidates) == 0:
+		return flagErrorf("--root-index: no container root was found")
+	case *rootIndex != 0 && (*rootIndex < 1 || *rootIndex > len(candidates)):
+		return flagErrorf("--root-index: expected a number between 1 and %d, got %d. The container roots found are: %s", len(candidates), *rootIndex, rootCandidateList(candidates))
+	case *rootIndex != 0:
+		picked = candidates[*rootIndex-1]
+	case len(candidates) == 0:
+		return nil
+	case len(candidates) == 1:
+		picked = candidates[0]
+	case *interactive:
+		if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			return flagErrorf("--interactive requires stdin to be a terminal")
+		}
+		var choices []string
+		for _, c := range candidates {
+			choices = append(choices, c.String())
+		}
+		choice, err := prompt(bufio.NewReader(os.Stdin), "Pick a container root", choices, "")
+		if err != nil {
+			return err
+		}
+		for i := range choices {
+			if choices[i] == choice {
+				picked = candidates[i]
+			}
+		}
+	default:
+		return flagErrorf("several container roots were found: %s. Use --root, --root-index or --interactive to pick one", rootCandidateList(candidates))
+	}
+
+	*root = picked.Path
+	logutil.Debugf("using %s as the container root", picked)
+	return nil
+}
+
+// rootCandidates returns the container roots found in the environment. The
+// Telepresence 2 mounts are only looked for when CONTAINER_ROOT and
+// TELEPRESENCE_ROOT aren't set, we seem to be in an intercept, and no token
+// can be found locally.
+func rootCandidates() []rootCandidate {
+	var candidates []rootCandidate
+	for _, env := range []string{"CONTAINER_ROOT", "TELEPRESENCE_ROOT"} {
+		path := os.Getenv(env)
+		if path == "" || (len(candidates) > 0 && candidates[0].Path == path) {
+			continue
+		}
+		candidates = append(candidates, rootCandidate{Path: path, Source: env})
+	}
+	if len(candidates) > 0 {
+		return candidates
+	}
+
+	// Telepresence 2 doesn't always set TELEPRESENCE_ROOT, so let's look for
+	// its mounts.
+	_, tokenErr := os.Stat(incluster.DefaultTokenMount + "/token")
+	if !os.IsNotExist(tokenErr) || (os.Getenv("TELEPRESENCE_MOUNTS") == "" && os.Getenv(*hostEnv) == "") {
+		return nil
+	}
+	roots, err := detectTelepresenceRoot()
+	switch {
+	case err != nil:
+		logutil.Debugf("while looking for Telepresence 2 mounts: %s", err)
+	case len(roots) == 0:
+		logutil.Debugf("no Telepresence 2 mount found")
+	}
+	for _, path := range roots {
+		candidates = append(candidates, rootCandidate{Path: path, Source: "Telepresence 2 mount"})
+	}
+	return candidates
+}
+
+// rootCandidateList returns the numbered list of candidates, as expected by
+// --root-index.
+func rootCandidateList(candidates []rootCandidate) string {
+	var items []string
+	for i, c := range candidates {
+		items = append(items, fmt.Sprintf("%d) %s", i+1, c))
+	}
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// commandExitError is returned when the command given to the run
+// subcommand fails, so that kubectl-incluster exits with the same code.
+type commandExitError struct {
+	code int
+	err  error
+}
+
+func (e *commandExitError) Error() string { return e.err.Error() }
+func (e *commandExitError) Unwrap() error { return e.err }
+
+// runCommand writes the kube config to a temporary file, runs the command
+// with KUBECONFIG set to it, and removes the file once the command exits.
+// With a proxy, the command also gets HTTPS_PROXY and SSL_CERT_FILE, the
+// latter being a temporary file with the CA presented by mitmproxy, which
+// most HTTP clients (Go, curl, Python's requests) trust.
+func runCommand(ctx context.Context, proxy string, args []string) error {
+	env := os.Environ()
+	resolveProxy := proxy
+	if resolveProxy == "" {
+		resolveProxy = os.Getenv("HTTPS_PROXY")
+	}
+
+	kubeconfig, err := resolveKubeconfig(ctx, resolveProxy)
+	if err != nil {
+		return err
+	}
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return fmt.Errorf("serializing the kube config: %w", err)
+	}
+	path, err := writeTempKubeconfig(content)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	env = append(env, "KUBECONFIG="+path)
+
+	if proxy != "" {
+		ca, err := fetchCACertFromMitmproxy(ctx, proxy)
+		if err != nil {
+			return fmt.Errorf("while processing flag --proxy: %w", err)
+		}
+		caPath, err := writeTempFile("mitmproxy-ca-*.pem", []byte(ca))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(caPath)
+		env = append(env, "HTTPS_PROXY="+proxy, "SSL_CERT_FILE="+caPath)
+	}
+
+	// The command gets the Ctrl-C from the terminal too, which is why it
+	// isn't killed when the context is cancelled: we wait for it to exit
+	// so that the temporary files are removed.
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	logutil.Debugf("running '%s' with KUBECONFIG=%s", strings.Join(args, " "), path)
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return &commandExitError{code: exitErr.ExitCode(), err: fmt.Errorf("run: '%s' failed: %w", args[0], err)}
+	}
+	if err != nil {
+		return fmt.Errorf("run: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// sopsEncrypt encrypts the kube config with the sops CLI. The recipients
+// (age, PGP, KMS...) are the ones of the creation rule of .sops.yaml that
+// matches the filename, the same as when running 'sops --encrypt' on that
+// file. The filename is where the output is written; it defaults to
+// 'kubeconfig.yaml' when printing to stdout. sops finds .sops.yaml by looking
+// in the current directory and its parents.
+func sopsEncrypt(kubeconfig []byte, filename string) ([]byte, error) {
+	if filename == "" {
+		filename = "kubeconfig.yaml"
+	}
+
+	// sops can't read stdin on every platform, which is why the kube config
+	// is written to a temporary file that only the current user can read.
+	tmp, err := ioutil.TempFile("", "kubectl-incluster-*.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("creating a temporary file for sops: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(kubeconfig)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("writing the temporary file for sops: %w", err)
+	}
+
+	args := []string{"--encrypt", "--input-type", "yaml", "--output-type", "yaml", "--filename-override", filename, tmp.Name()}
+	logutil.Debugf("running 'sops %s'", strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sops", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running 'sops --encrypt': %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// outputSummary is what --summary prints. The schema is in
+// summary.schema.json, keep both in sync and don't rename the fields since
+// scripts rely on them. Empty fields are omitted.
+type outputSummary struct {
+	Server                  string           `json:"server"`
+	TLSServerName           string           `json:"tlsServerName,omitempty"`
+	InsecureSkipTLSVerify   bool             `json:"insecureSkipTLSVerify"`
+	ProxyURL                string           `json:"proxyURL,omitempty"`
+	Namespace               string           `json:"namespace,omitempty"`
+	AuthType                string           `json:"authType"`
+	Identity                *summaryIdentity `json:"identity,omitempty"`
+	TokenExpiry             *time.Time       `json:"tokenExpiry,omitempty"`
+	ClientCertificateExpiry *time.Time       `json:"clientCertificateExpiry,omitempty"`
+	CA                      []certInfo       `json:"ca,omitempty"`
+
+	// tokenIsJWT tells whether the identity and expiry could be decoded
+	// from the token. It isn't part of the JSON.
+	tokenIsJWT bool
+}
+
+// summaryIdentity is the user the credentials most likely map to. It is
+// decoded from the token (JWT) or the client certificate without asking the
+// API server, use the whoami subcommand to know for sure.
+type summaryIdentity struct {
+	Username       string   `json:"username"`
+	Groups         []string `json:"groups,omitempty"`
+	ServiceAccount string   `json:"serviceAccount,omitempty"`
+	Source         string   `json:"source"`
+}
+
+// The values of authType.
+const (
+	authTypeToken        = "token"
+	authTypeClientCert   = "client-certificate"
+	authTypeBasic        = "basic"
+	authTypeExec         = "exec"
+	authTypeAuthProvider = "auth-provider"
+	authTypeNone         = "none"
+)
+
+// printSummary prints the summary of the current context of the kube config
+// as JSON. Nothing is sent to the API server.
+func printSummary(out io.Writer, kubeconfig *clientcmdapi.Config) error {
+	summary, err := summarizeOutput(kubeconfig)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", content)
+	return err
+}
+
+// summarizeOutput returns the summary of the current context of the kube
+// config. The token, CA and client certificate files are read when the kube
+// config references them instead of embedding them.
+func summarizeOutput(kubeconfig *clientcmdapi.Config) (outputSummary, error) {
+	cluster, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return outputSummary{}, err
+	}
+	clientConfig := clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{})
+	ns, _, err := clientConfig.Namespace()
+	if err != nil {
+		return outputSummary{}, fmt.Errorf("loading the namespace: %w", err)
+	}
+
+	summary := outputSummary{
+		Server:                cluster.Server,
+		TLSServerName:         cluster.TLSServerName,
+		InsecureSkipTLSVerify: cluster.InsecureSkipTLSVerify,
+		ProxyURL:              cluster.ProxyURL,
+		Namespace:             ns,
+	}
+
+	ca, err := dataOrFile(cluster.CertificateAuthorityData, cluster.CertificateAuthority)
+	if err != nil {
+		return outputSummary{}, fmt.Errorf("reading the CA: %w", err)
+	}
+	if len(ca) > 0 {
+		certs, err := parseCertsPEM(ca)
+		if err != nil {
+			return outputSummary{}, fmt.Errorf("parsing the CA: %w", err)
+		}
+		for _, cert := range certs {
+			summary.CA = append(summary.CA, newCertInfo(cert))
+		}
+	}
+
+	token, err := userToken(user)
+	if err != nil {
+		return outputSummary{}, err
+	}
+	cert, err := dataOrFile(user.ClientCertificateData, user.ClientCertificate)
+	if err != nil {
+		return outputSummary{}, fmt.Errorf("reading the client certificate: %w", err)
+	}
+
+	switch {
+	case token != "":
+		summary.AuthType = authTypeToken
+		claims, err := decodeJWT(token)
+		if err != nil {
+			break
+		}
+		summary.tokenIsJWT = true
+		username := claims.Subject
+		if username == "" && claims.LegacyName != "" {
+			username = "system:serviceaccount:" + claims.LegacyNamespace + ":" + claims.LegacyName
+		}
+		if username != "" {
+			summary.Identity = &summaryIdentity{Username: username, Source: "token"}
+		}
+		if claims.Expiry != 0 {
+			expiry := time.Unix(claims.Expiry, 0).UTC()
+			summary.TokenExpiry = &expiry
+		}
+	case len(cert) > 0:
+		summary.AuthType = authTypeClientCert
+		certs, err := parseCertsPEM(cert)
+		if err != nil {
+			return outputSummary{}, fmt.Errorf("parsing the client certificate: %w", err)
+		}
+		summary.Identity = &summaryIdentity{
+			Username: certs[0].Subject.CommonName,
+			Groups:   certs[0].Subject.Organization,
+			Source:   "client-certificate",
+		}
+		expiry := certs[0].NotAfter.UTC()
+		summary.ClientCertificateExpiry = &expiry
+	case user.Exec != nil:
+		summary.AuthType = authTypeExec
+	case user.AuthProvider != nil:
+		summary.AuthType = authTypeAuthProvider
+	case user.Username != "":
+		summary.AuthType = authTypeBasic
+		summary.Identity = &summaryIdentity{Username: user.Username, Source: "basic"}
+	default:
+		summary.AuthType = authTypeNone
+	}
+
+	if summary.Identity != nil {
+		if ns, name, ok := serviceAccountFromUsername(summary.Identity.Username); ok {
+			summary.Identity.ServiceAccount = ns + "/" + name
+		}
+	}
+
+	return summary, nil
+}
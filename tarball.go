@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// tarballLoader is the 'load.sh' script of the tarball. It prints the export
+// of KUBECONFIG meant to be eval'ed, or runs the given command with it.
+const tarballLoader = `#!/bin/sh
+# Generated by kubectl-incluster. Usage:
+#   eval "$(./load.sh)"
+#   ./load.sh kubectl get pods
+KUBECONFIG="$(cd "$(dirname "$0")" && pwd)/kubeconfig"
+export KUBECONFIG
+if [ $# -eq 0 ]; then
+  echo "export KUBECONFIG='$KUBECONFIG'"
+  exit 0
+fi
+exec "$@"
+`
+
+// tarballFile is a file of the tarball.
+type tarballFile struct {
+	name    string
+	mode    int64
+	content []byte
+}
+
+// kubeconfigTarball packages the current context of the kube config as a tar
+// archive in which the kube config refers to the CA, token and client
+// certificate files next to it with relative paths, which client-go resolves
+// relative to the kube config. It is meant to be extracted into containers
+// built from scratch or distroless images that have neither kubectl nor a
+// shell to write the files with, e.g. with 'kubectl cp' to a volume shared
+// with a debug container. Only KUBECONFIG needs to be set.
+func kubeconfigTarball(kubeconfig *clientcmdapi.Config) ([]byte, error) {
+	kubeconfig = kubeconfig.DeepCopy()
+	cluster, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if user.Exec != nil || user.AuthProvider != nil {
+		return nil, fmt.Errorf("the credentials use an exec or auth provider plugin, use a token or a client certificate instead")
+	}
+
+	var files []tarballFile
+	add := func(name string, mode int64, content []byte) {
+		files = append(files, tarballFile{name: name, mode: mode, content: content})
+	}
+
+	ca, err := dataOrFile(cluster.CertificateAuthorityData, cluster.CertificateAuthority)
+	if err != nil {
+		return nil, fmt.Errorf("reading the CA: %w", err)
+	}
+	if len(ca) > 0 {
+		add("ca.crt", 0644, ca)
+		cluster.CertificateAuthorityData, cluster.CertificateAuthority = nil, "ca.crt"
+	}
+
+	token, err := userToken(user)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		add("token", 0600, []byte(token))
+		user.Token, user.TokenFile = "", "token"
+	}
+
+	cert, err := dataOrFile(user.ClientCertificateData, user.ClientCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("reading the client certificate: %w", err)
+	}
+	key, err := dataOrFile(user.ClientKeyData, user.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("reading the client key: %w", err)
+	}
+	if len(cert) > 0 {
+		add("client.crt", 0644, cert)
+		add("client.key", 0600, key)
+		user.ClientCertificateData, user.ClientCertificate = nil, "client.crt"
+		user.ClientKeyData, user.ClientKey = nil, "client.key"
+	}
+
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("serializing the kube config: %w", err)
+	}
+	files = append([]tarballFile{{name: "kubeconfig", mode: 0600, content: content}}, files...)
+	add("load.sh", 0755, []byte(tarballLoader))
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now().Truncate(time.Second)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    f.mode,
+			Size:    int64(len(f.content)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
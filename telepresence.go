@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// detectTelepresenceRoot finds the mount point of a Telepresence 2 intercept.
+// Telepresence 2 doesn't always set TELEPRESENCE_ROOT in your shell; instead,
+// it mounts the remote volumes using sshfs in a temporary directory of the
+// form /tmp/telfs-123456, which is found by scanning the local mount points.
+// The TELEPRESENCE_MOUNTS env var can't be used for that since it lists the
+// paths of the volumes in the container, not where they are mounted locally.
+//
+// Only the mount points that contain a service account token are returned.
+func detectTelepresenceRoot() ([]string, error) {
+	mountPoints, err := localMountPoints()
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []string
+	for _, mountPoint := range mountPoints {
+		if !strings.HasPrefix(filepath.Base(mountPoint), "telfs-") {
+			continue
+		}
+		if _, err := os.Stat(incluster.InRoot(mountPoint, incluster.DefaultTokenMount+"/token")); err != nil {
+			logutil.Debugf("skipping the Telepresence mount %s since it has no token: %s", mountPoint, err)
+			continue
+		}
+		roots = append(roots, mountPoint)
+	}
+
+	return roots, nil
+}
+
+// localMountPoints lists the mount points of the machine kubectl-incluster
+// runs on. On Linux, /proc/mounts is used. On other platforms, the output of
+// the 'mount' command is parsed.
+func localMountPoints() ([]string, error) {
+	var mountPoints []string
+
+	if runtime.GOOS == "linux" {
+		bytes, err := ioutil.ReadFile("/proc/mounts")
+		if err != nil {
+			return nil, fmt.Errorf("while reading /proc/mounts: %w", err)
+		}
+		scanner := bufio.NewScanner(strings.NewReader(string(bytes)))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+			mountPoints = append(mountPoints, fields[1])
+		}
+		return mountPoints, nil
+	}
+
+	// The output of 'mount' on macOS looks like:
+	//  localhost:/ on /private/var/folders/xx/T/telfs-123456 (macfuse, nodev, nosuid)
+	out, err := exec.Command("mount").Output()
+	if err != nil {
+		return nil, fmt.Errorf("while running 'mount': %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, " on ")
+		j := strings.LastIndex(line, " (")
+		if i == -1 || j == -1 || j < i {
+			continue
+		}
+		mountPoints = append(mountPoints, line[i+len(" on "):j])
+	}
+
+	return mountPoints, nil
+}
+
+// telepresenceWorkload is an item of 'telepresence list --intercepts --output
+// json'. Telepresence 2.0 to 2.5 only give a single intercept_info.
+type telepresenceWorkload struct {
+	Name           string                  `json:"name"`
+	Namespace      string                  `json:"namespace"`
+	InterceptInfos []telepresenceIntercept `json:"intercept_infos"`
+	InterceptInfo  *telepresenceIntercept  `json:"intercept_info"`
+}
+
+type telepresenceIntercept struct {
+	Spec struct {
+		Name string `json:"name"`
+	} `json:"spec"`
+	MountPoint string `json:"mount_point"`
+}
+
+// telepresenceInterceptRoots asks the Telepresence CLI for the active
+// intercepts and returns their mount points, which is what '--root auto'
+// uses. The intercepts started with '--mount=false' and the mounts that have
+// no service account token are skipped.
+func telepresenceInterceptRoots() ([]rootCandidate, error) {
+	out, err := runCat("telepresence", "list", "--intercepts", "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	// Since Telepresence 2.9, the output is wrapped in {"cmd": "list",
+	// "stdout": [...]}.
+	var workloads []telepresenceWorkload
+	if err := json.Unmarshal(out, &workloads); err != nil {
+		var wrapped struct {
+			Stdout json.RawMessage `json:"stdout"`
+		}
+		if json.Unmarshal(out, &wrapped) != nil || json.Unmarshal(wrapped.Stdout, &workloads) != nil {
+			return nil, fmt.Errorf("parsing the output of 'telepresence list': %w", err)
+		}
+	}
+
+	var candidates []rootCandidate
+	for _, w := range workloads {
+		intercepts := w.InterceptInfos
+		if w.InterceptInfo != nil {
+			intercepts = append(intercepts, *w.InterceptInfo)
+		}
+		for _, intercept := range intercepts {
+			if intercept.MountPoint == "" {
+				logutil.Debugf("skipping the intercept %s of %s/%s since it has no mount", intercept.Spec.Name, w.Namespace, w.Name)
+				continue
+			}
+			if _, err := os.Stat(incluster.InRoot(intercept.MountPoint, incluster.DefaultTokenMount+"/token")); err != nil {
+				logutil.Debugf("skipping the mount %s of the intercept %s since it has no token: %s", intercept.MountPoint, intercept.Spec.Name, err)
+				continue
+			}
+			candidates = append(candidates, rootCandidate{Path: intercept.MountPoint, Source: "intercept " + intercept.Spec.Name})
+		}
+	}
+	return candidates, nil
+}
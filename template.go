@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// templateData is what the template given with --template is executed
+// against. The fields are documented in the README, don't rename them since
+// users' templates rely on them. The PEM fields are the PEM blocks as-is,
+// use 'b64enc' to base64-encode them.
+type templateData struct {
+	Server                string
+	TLSServerName         string
+	InsecureSkipTLSVerify bool
+	ProxyURL              string
+	Namespace             string
+	Token                 string
+	Username              string
+	Password              string
+	CAData                string
+	ClientCertificateData string
+	ClientKeyData         string
+	// Expiry is when the token or the client certificate expires, and is
+	// nil when it isn't known, e.g. when the token isn't a JWT.
+	Expiry *time.Time
+}
+
+// templateFuncs are the functions available in the template on top of
+// the built-in ones.
+var templateFuncs = template.FuncMap{
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"json": func(v interface{}) (string, error) {
+		content, err := json.Marshal(v)
+		return string(content), err
+	},
+	"rfc3339": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	},
+}
+
+// templateOutput executes the template given with --template against the
+// current context of the kube config. It lets users generate formats that
+// kubectl-incluster doesn't know about, e.g. Ansible variables or a .netrc,
+// without a new output format for each.
+func templateOutput(kubeconfig *clientcmdapi.Config, text string) ([]byte, error) {
+	if text == "" {
+		return nil, flagErrorf("-o go-template requires --template")
+	}
+	tmpl, err := template.New("go-template").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, flagErrorf("--template: %s", err)
+	}
+
+	cluster, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := summarizeOutput(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	data := templateData{
+		Server:                cluster.Server,
+		TLSServerName:         cluster.TLSServerName,
+		InsecureSkipTLSVerify: cluster.InsecureSkipTLSVerify,
+		ProxyURL:              cluster.ProxyURL,
+		Namespace:             summary.Namespace,
+		Token:                 user.Token,
+		Username:              user.Username,
+		Password:              user.Password,
+		Expiry:                summary.TokenExpiry,
+	}
+	if data.Expiry == nil {
+		data.Expiry = summary.ClientCertificateExpiry
+	}
+	if data.Token, err = userToken(user); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		field    *string
+		what     string
+		data     []byte
+		filename string
+	}{
+		{&data.CAData, "CA", cluster.CertificateAuthorityData, cluster.CertificateAuthority},
+		{&data.ClientCertificateData, "client certificate", user.ClientCertificateData, user.ClientCertificate},
+		{&data.ClientKeyData, "client key", user.ClientKeyData, user.ClientKey},
+	} {
+		content, err := dataOrFile(f.data, f.filename)
+		if err != nil {
+			return nil, fmt.Errorf("reading the %s: %w", f.what, err)
+		}
+		*f.field = string(content)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("executing the template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
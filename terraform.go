@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// terraformProviders renders the current context of the kube config as the
+// 'kubernetes' and 'helm' Terraform provider blocks. The PEM data is
+// base64-encoded and decoded with base64decode() to avoid heredocs.
+func terraformProviders(kubeconfig *clientcmdapi.Config) ([]byte, error) {
+	cluster, user, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if user.Exec != nil || user.AuthProvider != nil {
+		return nil, fmt.Errorf("the credentials use an exec or auth provider plugin, use a token or a client certificate instead")
+	}
+
+	// The attributes are the same for both providers, only the indentation
+	// differs.
+	attrs := func(indent string) string {
+		var b bytes.Buffer
+		attr := func(name, value string) {
+			fmt.Fprintf(&b, "%s%-22s = %s\n", indent, name, value)
+		}
+		b64 := func(data []byte) string {
+			return fmt.Sprintf("base64decode(%q)", base64.StdEncoding.EncodeToString(data))
+		}
+
+		attr("host", fmt.Sprintf("%q", cluster.Server))
+		if user.Token != "" {
+			attr("token", fmt.Sprintf("%q", user.Token))
+		}
+		if user.Username != "" {
+			attr("username", fmt.Sprintf("%q", user.Username))
+			attr("password", fmt.Sprintf("%q", user.Password))
+		}
+		if len(user.ClientCertificateData) > 0 {
+			attr("client_certificate", b64(user.ClientCertificateData))
+			attr("client_key", b64(user.ClientKeyData))
+		}
+		if len(cluster.CertificateAuthorityData) > 0 {
+			attr("cluster_ca_certificate", b64(cluster.CertificateAuthorityData))
+		}
+		if cluster.InsecureSkipTLSVerify {
+			attr("insecure", "true")
+		}
+		if cluster.TLSServerName != "" {
+			attr("tls_server_name", fmt.Sprintf("%q", cluster.TLSServerName))
+		}
+		if cluster.ProxyURL != "" {
+			attr("proxy_url", fmt.Sprintf("%q", cluster.ProxyURL))
+		}
+		return b.String()
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "provider \"kubernetes\" {\n%s}\n\n", attrs("  "))
+	fmt.Fprintf(&out, "provider \"helm\" {\n  kubernetes {\n%s  }\n}\n", attrs("    "))
+	return out.Bytes(), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	certutil "k8s.io/client-go/util/cert"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// trustOnFirstUse connects to the given API server and returns the last
+// certificate of the chain it presents, PEM-encoded, to be used as the CA.
+// It is used with --tofu-ca when the CA file isn't available locally. The
+// last certificate is the root when the server sends it, or the leaf when
+// the server certificate is self-signed.
+//
+// The connection doesn't go through HTTPS_PROXY since we want the
+// certificate of the API server, not the one presented by mitmproxy.
+func trustOnFirstUse(ctx context.Context, server, tlsServerName string) ([]byte, error) {
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the server URL %q: %w", server, err)
+	}
+	addr := serverURL.Host
+	if serverURL.Port() == "" {
+		addr = net.JoinHostPort(serverURL.Hostname(), "443")
+	}
+	serverName := serverURL.Hostname()
+	if tlsServerName != "" {
+		serverName = tlsServerName
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	// The whole point is to get the chain without having a CA to verify it.
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s: %w", addr, err)
+	}
+	chain := tlsConn.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("the server %s didn't present any certificate", addr)
+	}
+	ca := chain[len(chain)-1]
+
+	logutil.Warnf("trusting on first use the certificate '%s' presented by %s, make sure that its SHA-256 fingerprint is %s", ca.Subject, addr, fingerprint(ca.Raw))
+
+	pem, err := certutil.EncodeCertificates(ca)
+	if err != nil {
+		return nil, fmt.Errorf("encoding the certificate presented by %s: %w", addr, err)
+	}
+	return pem, nil
+}
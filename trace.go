@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// traceTransport logs the method, URL, status and latency of every request
+// made to the API server, and their headers with --trace-headers. It gives
+// an idea of what goes on the wire without having to run mitmproxy.
+type traceTransport struct {
+	next    http.RoundTripper
+	headers bool
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.headers {
+		logTraceHeaders("> ", req.Header)
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	latency := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		logutil.Infof("trace: %s %s: %s (%s)", req.Method, req.URL, err, latency)
+		return nil, err
+	}
+	logutil.Infof("trace: %s %s: %s (%s)", req.Method, req.URL, resp.Status, latency)
+
+	if t.headers {
+		logTraceHeaders("< ", resp.Header)
+	}
+	return resp, nil
+}
+
+// logTraceHeaders logs the headers sorted by name. The credentials only keep
+// their scheme (e.g., 'Bearer REDACTED') so that the trace can be shared.
+func logTraceHeaders(prefix string, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range h[name] {
+			switch name {
+			case "Authorization", "Proxy-Authorization":
+				if i := strings.Index(value, " "); i > 0 {
+					value = value[:i+1] + redacted
+				} else {
+					value = redacted
+				}
+			case "Cookie", "Set-Cookie":
+				value = redacted
+			}
+			logutil.Infof("trace: %s%s: %s", prefix, name, value)
+		}
+	}
+}
+
+// traceConfig wraps the transport of the config with traceTransport when
+// --trace or --trace-headers is given.
+func traceConfig(c *rest.Config) {
+	if !*trace && !*traceHeaders {
+		return
+	}
+	c.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &traceTransport{next: rt, headers: *traceHeaders}
+	})
+}
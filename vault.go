@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// runVaultLogin logs into Vault using the Kubernetes auth method with the
+// resolved service account token. It prints the Vault token, or, when
+// kubeconfig=path is given, the kube config stored in Vault at that path. It
+// is used with --vault-login.
+//
+// The value is a comma-separated list of key=value pairs:
+//
+//	role=name         the Vault role to log in as (required)
+//	addr=url          the Vault address, defaults to $VAULT_ADDR
+//	mount=path        where the Kubernetes auth method is mounted, defaults to 'kubernetes'
+//	kubeconfig=path   the path of a secret whose 'kubeconfig' field is printed instead of the token
+//
+// Like the vault CLI, $VAULT_CACERT is used to verify Vault's certificate.
+func runVaultLogin(ctx context.Context, value string) error {
+	params, err := parseKeyValues(value)
+	if err != nil {
+		return fmt.Errorf("while processing flag --vault-login: %w", err)
+	}
+	for k := range params {
+		switch k {
+		case "role", "addr", "mount", "kubeconfig":
+		default:
+			return fmt.Errorf("while processing flag --vault-login: unknown key '%s', expected one of role, addr, mount or kubeconfig", k)
+		}
+	}
+	role, addr, mount := params["role"], params["addr"], params["mount"]
+	if role == "" {
+		return fmt.Errorf("while processing flag --vault-login: expected role=name, got: %s", value)
+	}
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return fmt.Errorf("while processing flag --vault-login: expected addr=url or VAULT_ADDR to be set")
+	}
+	if mount == "" {
+		mount = "kubernetes"
+	}
+
+	c, _, err := resolveConfig(ctx, "")
+	if err != nil {
+		return err
+	}
+	jwt, err := userToken(&clientcmdapi.AuthInfo{Token: c.BearerToken, TokenFile: c.BearerTokenFile})
+	if err != nil {
+		return err
+	}
+	if jwt == "" {
+		return fmt.Errorf("%w: --vault-login needs a service account token, run it in a pod or use --serviceaccount", incluster.ErrNoCredentials)
+	}
+
+	vault, err := newVaultClient(addr)
+	if err != nil {
+		return err
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	err = vault.do(ctx, "POST", "auth/"+strings.Trim(mount, "/")+"/login", "", map[string]string{"role": role, "jwt": jwt}, &login)
+	if err != nil {
+		return fmt.Errorf("logging into Vault with the role %s: %w", role, err)
+	}
+	logutil.Debugf("logged into Vault at %s with the role %s", addr, role)
+
+	path := params["kubeconfig"]
+	if path == "" {
+		fmt.Println(login.Auth.ClientToken)
+		return nil
+	}
+
+	// The KV v2 engine nests the fields under 'data.data', the KV v1 engine
+	// under 'data'.
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = vault.do(ctx, "GET", strings.Trim(path, "/"), login.Auth.ClientToken, nil, &secret)
+	if err != nil {
+		return fmt.Errorf("reading the secret %s: %w", path, err)
+	}
+	fields := secret.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		fields = nested
+	}
+	content, ok := fields["kubeconfig"].(string)
+	if !ok {
+		return fmt.Errorf("the secret %s has no 'kubeconfig' field", path)
+	}
+
+	kubeconfig, err := clientcmd.Load([]byte(content))
+	if err != nil {
+		return fmt.Errorf("parsing the kube config stored in the secret %s: %w", path, err)
+	}
+	return writeKubeconfigOutput(ctx, kubeconfig)
+}
+
+type vaultClient struct {
+	addr string
+	http *http.Client
+}
+
+func newVaultClient(addr string) (*vaultClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if path := os.Getenv("VAULT_CACERT"); path != "" {
+		ca, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading VAULT_CACERT: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no PEM certificate found in VAULT_CACERT %s", path)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &vaultClient{
+		addr: strings.TrimSuffix(addr, "/"),
+		http: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// do sends a request to the Vault API and decodes the JSON response into
+// out. The token may be empty.
+func (v *vaultClient) do(ctx context.Context, method, path, token string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading the response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(respBody, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.Join(vaultErr.Errors, ", "))
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("decoding the response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// portForwarding is set when a port-forward was established with --vcluster
+// or --via-port-forward. The kube config only works as long as
+// kubectl-incluster keeps running.
+var portForwarding bool
+
+// useVcluster uses the kube config that vcluster stores in the Secret
+// 'vc-<name>' as the source kube config. The server of that kube config is
+// 'https://localhost:8443', which is why it is replaced with the
+// LoadBalancer or Ingress endpoint of the vcluster when there is one, with
+// the Service's DNS name when in cluster, or with a port-forward to the
+// vcluster's pod otherwise. The tls-server-name is kept to 'localhost' since
+// the certificate of the vcluster is valid for it. The ref is of the form
+// '[namespace/]name'.
+func useVcluster(ctx context.Context, ref string) error {
+	namespace, name := "", ref
+	if splits := strings.Split(ref, "/"); len(splits) == 2 {
+		namespace, name = splits[0], splits[1]
+	} else if len(splits) > 2 {
+		return flagErrorf("--vcluster: expected value of the form '[namespace/]name', got: %s", ref)
+	}
+	if name == "" {
+		return flagErrorf("--vcluster: expected value of the form '[namespace/]name', got: %s", ref)
+	}
+	if namespace == "" {
+		namespace = contextNamespace()
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	c, err := apiConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	if *serverOverride == "" && !*forHost {
+		server, err := vclusterServer(ctx, c, cl, namespace, name)
+		if err != nil {
+			return fmt.Errorf("while processing flag --vcluster: %w", err)
+		}
+		*serverOverride = server
+	}
+
+	return useKubeconfigFromSecret(ctx, "--vcluster", namespace+"/vc-"+name+"#config")
+}
+
+// vclusterServer returns the URL at which the vcluster's API server can be
+// reached from where kubectl-incluster runs.
+func vclusterServer(ctx context.Context, c *rest.Config, cl kubernetes.Interface, namespace, name string) (string, error) {
+	svc, err := cl.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("getting service %s in namespace %s: %w", name, namespace, err)
+	}
+	if len(svc.Spec.Ports) == 0 {
+		return "", fmt.Errorf("the service %s in namespace %s has no port", name, namespace)
+	}
+	port := svc.Spec.Ports[0]
+	for _, p := range svc.Spec.Ports {
+		if p.Name == "https" {
+			port = p
+		}
+	}
+
+	if svc.Spec.Type == v1.ServiceTypeLoadBalancer && len(svc.Status.LoadBalancer.Ingress) > 0 {
+		host := svc.Status.LoadBalancer.Ingress[0].IP
+		if host == "" {
+			host = svc.Status.LoadBalancer.Ingress[0].Hostname
+		}
+		logutil.Debugf("using the LoadBalancer endpoint %s of the vcluster %s/%s", host, namespace, name)
+		return "https://" + net.JoinHostPort(host, strconv.Itoa(int(port.Port))), nil
+	}
+
+	// With an Ingress, vcluster relies on SSL passthrough.
+	ing, err := cl.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil && len(ing.Spec.Rules) > 0 && ing.Spec.Rules[0].Host != "":
+		logutil.Debugf("using the Ingress host %s of the vcluster %s/%s", ing.Spec.Rules[0].Host, namespace, name)
+		return "https://" + ing.Spec.Rules[0].Host, nil
+	case err != nil && !k8serrors.IsNotFound(err):
+		logutil.Debugf("while looking for the Ingress of the vcluster %s/%s: %s", namespace, name, err)
+	}
+
+	if inCluster() {
+		return fmt.Sprintf("https://%s.%s.svc:%d", name, namespace, port.Port), nil
+	}
+
+	pods, err := cl.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("listing the pods of service %s in namespace %s: %w", name, namespace, err)
+	}
+	var pod string
+	for _, p := range pods.Items {
+		if p.Status.Phase == v1.PodRunning {
+			pod = p.Name
+			break
+		}
+	}
+	if pod == "" {
+		return "", fmt.Errorf("no running pod found for the service %s in namespace %s", name, namespace)
+	}
+
+	targetPort := port.TargetPort.IntValue()
+	if targetPort == 0 {
+		targetPort = 8443
+	}
+	localPort, err := portForward(ctx, c, namespace, pod, targetPort)
+	if err != nil {
+		return "", fmt.Errorf("port-forwarding to pod %s in namespace %s: %w", pod, namespace, err)
+	}
+	logutil.Infof("port-forwarding 127.0.0.1:%d to the vcluster pod %s/%s", localPort, namespace, pod)
+	return fmt.Sprintf("https://127.0.0.1:%d", localPort), nil
+}
+
+// portForward forwards a random local port to the given port of the pod
+// until the context is cancelled, and returns the local port.
+func portForward(ctx context.Context, c *rest.Config, namespace, pod string, port int) (uint16, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(c)
+	if err != nil {
+		return 0, err
+	}
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return 0, fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+	req := cl.CoreV1().RESTClient().Post().Resource("pods").Namespace(namespace).Name(pod).SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+
+	ready := make(chan struct{})
+	fw, err := portforward.New(dialer, []string{fmt.Sprintf("0:%d", port)}, ctx.Done(), ready, ioutil.Discard, os.Stderr)
+	if err != nil {
+		return 0, err
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- fw.ForwardPorts()
+	}()
+
+	select {
+	case <-ready:
+	case err := <-errs:
+		return 0, err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	ports, err := fw.GetPorts()
+	if err != nil {
+		return 0, err
+	}
+	portForwarding = true
+	return ports[0].Local, nil
+}
+
+// waitForPortForward blocks until the context is cancelled (e.g., with
+// Ctrl-C) when a port-forward was established with --vcluster or
+// --via-port-forward, since the
+// printed kube config stops working as soon as kubectl-incluster exits.
+func waitForPortForward(ctx context.Context) {
+	if !portForwarding {
+		return
+	}
+	logutil.Infof("the kube config uses a port-forward, keep kubectl-incluster running while you use it and press Ctrl-C to stop")
+	<-ctx.Done()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// verify loads the given kube config the same way kubectl would, and then
+// calls /version and creates a SelfSubjectReview to answer the question "will
+// this kube config actually work?".
+func verify(ctx context.Context, kubeconfig *clientcmdapi.Config, out io.Writer) error {
+	c, err := restConfigFromKubeconfig(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("loading the generated kube config: %w", err)
+	}
+
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	version, err := cl.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("calling /version on %s: %w", c.Host, err)
+	}
+
+	user, err := selfSubjectReview(ctx, cl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "server:   %s\n", c.Host)
+	fmt.Fprintf(out, "version:  %s\n", version.GitVersion)
+	fmt.Fprintf(out, "username: %s\n", user.Username)
+	fmt.Fprintf(out, "groups:   %s\n", strings.Join(user.Groups, ", "))
+	return nil
+}
+
+// selfSubjectReview returns the user the credentials map to. SelfSubjectReview
+// is GA since Kubernetes 1.28, beta in 1.27, and alpha in 1.26. Since the
+// client-go version we use doesn't know about SelfSubjectReview, we do the
+// request by hand.
+func selfSubjectReview(ctx context.Context, cl kubernetes.Interface) (authenticationv1.UserInfo, error) {
+	var lastErr error
+	for _, version := range []string{"v1", "v1beta1", "v1alpha1"} {
+		body := fmt.Sprintf(`{"apiVersion":"authentication.k8s.io/%s","kind":"SelfSubjectReview"}`, version)
+		raw, err := cl.Discovery().RESTClient().Post().
+			AbsPath("/apis/authentication.k8s.io", version, "selfsubjectreviews").
+			SetHeader("Content-Type", "application/json").
+			Body([]byte(body)).
+			Do(ctx).
+			Raw()
+		if k8serrors.IsNotFound(err) {
+			logutil.Debugf("SelfSubjectReview isn't available in authentication.k8s.io/%s", version)
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			return authenticationv1.UserInfo{}, fmt.Errorf("creating a SelfSubjectReview: %w", err)
+		}
+
+		var review struct {
+			Status struct {
+				UserInfo authenticationv1.UserInfo `json:"userInfo"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal(raw, &review); err != nil {
+			return authenticationv1.UserInfo{}, fmt.Errorf("decoding the SelfSubjectReview: %w", err)
+		}
+
+		return review.Status.UserInfo, nil
+	}
+
+	return authenticationv1.UserInfo{}, fmt.Errorf("SelfSubjectReview isn't available, it requires Kubernetes 1.26 or later: %w", lastErr)
+}
+
+// restConfigFromKubeconfig loads the given kube config the same way kubectl
+// would.
+func restConfigFromKubeconfig(kubeconfig *clientcmdapi.Config) (*rest.Config, error) {
+	c, err := clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	c.QPS, c.Burst = *qps, *burst
+	traceConfig(c)
+	otelConfig(c)
+	return c, nil
+}
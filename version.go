@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	runtimedebug "runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// These are set at build time, for example:
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// versionInfo is what 'kubectl incluster version --output json' prints.
+type versionInfo struct {
+	Version         string `json:"version"`
+	Commit          string `json:"commit"`
+	BuildDate       string `json:"buildDate"`
+	GoVersion       string `json:"goVersion"`
+	ClientGoVersion string `json:"clientGoVersion"`
+	Platform        string `json:"platform"`
+}
+
+func newVersionCmd() *cobra.Command {
+	var outputFormat string
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of kubectl-incluster",
+		Long: `Print the version, git commit and build date of kubectl-incluster as
+well as the versions of Go and client-go it was built with.`,
+		Example: `kubectl incluster version --output json`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch outputFormat {
+			case "":
+				printVersion(os.Stdout, buildVersionInfo())
+				return nil
+			case "json":
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(buildVersionInfo())
+			default:
+				return flagErrorf("unknown output format %q, the only supported format is 'json'", outputFormat)
+			}
+		},
+	}
+	// This --output shadows the global --output, which doesn't make sense
+	// for this subcommand anyway. The name is the same as in 'kubectl
+	// version --output json'.
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Output format. The only supported format is 'json'.")
+
+	return cmd
+}
+
+// buildVersionInfo returns the version information. When kubectl-incluster
+// was installed with 'go install' rather than built with -ldflags, the module
+// version is used instead of 'dev'.
+func buildVersionInfo() versionInfo {
+	info := versionInfo{
+		Version:         version,
+		Commit:          commit,
+		BuildDate:       date,
+		GoVersion:       runtime.Version(),
+		ClientGoVersion: "unknown",
+		Platform:        runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	build, ok := runtimedebug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+		info.Version = build.Main.Version
+	}
+	for _, dep := range build.Deps {
+		if dep.Path != "k8s.io/client-go" {
+			continue
+		}
+		info.ClientGoVersion = dep.Version
+		if dep.Replace != nil {
+			info.ClientGoVersion = dep.Replace.Version
+		}
+	}
+
+	return info
+}
+
+func printVersion(out io.Writer, info versionInfo) {
+	fmt.Fprintf(out, "version:   %s\n", info.Version)
+	fmt.Fprintf(out, "commit:    %s\n", info.Commit)
+	fmt.Fprintf(out, "built:     %s\n", info.BuildDate)
+	fmt.Fprintf(out, "go:        %s\n", info.GoVersion)
+	fmt.Fprintf(out, "client-go: %s\n", info.ClientGoVersion)
+	fmt.Fprintf(out, "platform:  %s\n", info.Platform)
+}
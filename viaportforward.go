@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+)
+
+// useViaPortForward establishes a port-forward to the given pod or service
+// and replaces the server of the generated kube config with the local end of
+// the port-forward. It is used with --via-port-forward to reach API servers
+// (or aggregated API servers) that are only exposed inside the cluster. The
+// value is of the form '[namespace/][pod/|svc/]name:port'; when the kind is
+// omitted, a Service of that name is looked up, then a pod.
+//
+// Unless --tls-server-name is given, the tls-server-name is set to the
+// Service's DNS name (e.g., 'api.team-a.svc') when port-forwarding to a
+// Service, or kept to the original host when port-forwarding to a pod.
+func useViaPortForward(ctx context.Context, value string) error {
+	switch {
+	case *serverOverride != "":
+		return flagErrorf("--via-port-forward and --server-override can't be used together")
+	case *forHost:
+		return flagErrorf("--via-port-forward and --for-host can't be used together")
+	case *vcluster != "":
+		return flagErrorf("--via-port-forward and --vcluster can't be used together")
+	}
+
+	invalid := flagErrorf("--via-port-forward: expected value of the form '[namespace/][pod/|svc/]name:port', got: %s", value)
+	i := strings.LastIndex(value, ":")
+	if i == -1 {
+		return invalid
+	}
+	port, err := strconv.Atoi(value[i+1:])
+	if err != nil || port <= 0 || port > 65535 {
+		return invalid
+	}
+	namespace, kind, name := "", "", value[:i]
+	splits := strings.Split(name, "/")
+	switch {
+	case len(splits) == 3:
+		namespace, kind, name = splits[0], splits[1], splits[2]
+	case len(splits) == 2 && isPortForwardKind(splits[0]):
+		kind, name = splits[0], splits[1]
+	case len(splits) == 2:
+		namespace, name = splits[0], splits[1]
+	case len(splits) > 3:
+		return invalid
+	}
+	if name == "" || kind != "" && !isPortForwardKind(kind) {
+		return invalid
+	}
+	if namespace == "" {
+		namespace = contextNamespace()
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	c, err := apiConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	pod, targetPort, serverName := name, port, ""
+	if kind != "pod" && kind != "pods" {
+		svc, err := cl.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			pod, targetPort, err = podForServicePort(ctx, cl, svc, port)
+			if err != nil {
+				return fmt.Errorf("while processing flag --via-port-forward: %w", err)
+			}
+			serverName = name + "." + namespace + ".svc"
+		case k8serrors.IsNotFound(err) && kind == "":
+			logutil.Debugf("no service %s in namespace %s, port-forwarding to the pod of that name", name, namespace)
+		default:
+			return fmt.Errorf("while processing flag --via-port-forward: getting service %s in namespace %s: %w", name, namespace, err)
+		}
+	}
+
+	localPort, err := portForward(ctx, c, namespace, pod, targetPort)
+	if err != nil {
+		return fmt.Errorf("while processing flag --via-port-forward: port-forwarding to pod %s in namespace %s: %w", pod, namespace, err)
+	}
+	logutil.Infof("port-forwarding 127.0.0.1:%d to port %d of the pod %s/%s", localPort, targetPort, namespace, pod)
+
+	*serverOverride = fmt.Sprintf("https://127.0.0.1:%d", localPort)
+	if *tlsServerName == "" {
+		*tlsServerName = serverName
+	}
+	return nil
+}
+
+func isPortForwardKind(kind string) bool {
+	switch kind {
+	case "pod", "pods", "svc", "service", "services":
+		return true
+	}
+	return false
+}
+
+// podForServicePort returns a running pod of the Service and the pod port
+// that the given Service port targets.
+func podForServicePort(ctx context.Context, cl kubernetes.Interface, svc *v1.Service, port int) (string, int, error) {
+	var svcPort *v1.ServicePort
+	for i := range svc.Spec.Ports {
+		if int(svc.Spec.Ports[i].Port) == port {
+			svcPort = &svc.Spec.Ports[i]
+		}
+	}
+	if svcPort == nil {
+		return "", 0, fmt.Errorf("the service %s in namespace %s has no port %d", svc.Name, svc.Namespace, port)
+	}
+
+	// An empty selector would select every pod of the namespace. Services
+	// without selector (e.g., 'default/kubernetes' or ExternalName services)
+	// aren't backed by pods we can port-forward to.
+	if len(svc.Spec.Selector) == 0 {
+		return "", 0, fmt.Errorf("the service %s in namespace %s has no selector, use 'pod/<name>:<port>' to port-forward to one of its pods instead", svc.Name, svc.Namespace)
+	}
+
+	pods, err := cl.CoreV1().Pods(svc.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("listing the pods of service %s in namespace %s: %w", svc.Name, svc.Namespace, err)
+	}
+	for _, p := range pods.Items {
+		if p.Status.Phase != v1.PodRunning {
+			continue
+		}
+		switch {
+		case svcPort.TargetPort.Type == intstr.String:
+			for _, container := range p.Spec.Containers {
+				for _, cp := range container.Ports {
+					if cp.Name == svcPort.TargetPort.StrVal {
+						return p.Name, int(cp.ContainerPort), nil
+					}
+				}
+			}
+			return "", 0, fmt.Errorf("the pod %s has no port named %s", p.Name, svcPort.TargetPort.StrVal)
+		case svcPort.TargetPort.IntValue() != 0:
+			return p.Name, svcPort.TargetPort.IntValue(), nil
+		default:
+			return p.Name, port, nil
+		}
+	}
+	return "", 0, fmt.Errorf("no running pod found for the service %s in namespace %s", svc.Name, svc.Namespace)
+}
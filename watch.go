@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// rootCAConfigMap is the ConfigMap that Kubernetes 1.21+ publishes in every
+// namespace with the CA that signed the API server certificate.
+const rootCAConfigMap = "kube-root-ca.crt"
+
+// runWatch writes the kube config like runPrint does, and then keeps running
+// and writes it again whenever something it was built from changes: the
+// mounted ca.crt (when in cluster) and the kube-root-ca.crt ConfigMap, which
+// both change when the cluster CA rotates, the mounted token (or the one given
+// with --token-file), which the kubelet rotates, and the Secret the token was
+// read from, which changes when the token is reset. The old and new CA
+// fingerprints are logged when the CA of the kube config changes.
+func runWatch(ctx context.Context, proxy string) error {
+	if *output == "" && *outputSecret == "" {
+		return flagErrorf("--watch requires --output or --output-secret")
+	}
+	if *outputDir != "" || *allContexts {
+		return flagErrorf("--watch can't be used with --output-dir or --all-contexts")
+	}
+
+	kubeconfig, tokenSecret, err := resolveKubeconfigAndTokenSecret(ctx, proxy)
+	if err != nil {
+		return err
+	}
+	if err := writeKubeconfigOutput(ctx, kubeconfig); err != nil {
+		return err
+	}
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return fmt.Errorf("serializing the kube config: %w", err)
+	}
+	ca := caFingerprints(kubeconfig)
+
+	changes := make(chan string, 1)
+	if err := startWatchers(ctx, tokenSecret, changes); err != nil {
+		return fmt.Errorf("while processing flag --watch: %w", err)
+	}
+	logutil.Infof("watching for changes, the CA fingerprints are %s", ca)
+
+	for {
+		var what string
+		select {
+		case <-ctx.Done():
+			return nil
+		case what = <-changes:
+		}
+		logutil.Debugf("%s changed, regenerating the kube config", what)
+
+		kubeconfig, err := resolveKubeconfig(ctx, proxy)
+		if err != nil {
+			logutil.Errorf("while regenerating the kube config after %s changed: %s", what, err)
+			continue
+		}
+		newContent, err := clientcmd.Write(*kubeconfig)
+		if err != nil {
+			return fmt.Errorf("serializing the kube config: %w", err)
+		}
+		if bytes.Equal(newContent, content) {
+			logutil.Debugf("%s changed but the kube config stays the same", what)
+			continue
+		}
+		if newCA := caFingerprints(kubeconfig); newCA != ca {
+			logutil.Infof("the CA rotated (%s changed), the CA fingerprints were %s and are now %s", what, ca, newCA)
+			ca = newCA
+		}
+		if err := writeKubeconfigOutput(ctx, kubeconfig); err != nil {
+			logutil.Errorf("while writing the kube config after %s changed: %s", what, err)
+			continue
+		}
+		content = newContent
+		logutil.Infof("the kube config was written again since %s changed", what)
+	}
+}
+
+// startWatchers sends to the channel what changed each time one of the
+// watched files or objects changes. The sends don't block: when a change is
+// already pending, the kube config will be regenerated anyway. The
+// tokenSecret is the Secret, of the form 'namespace/name', the token was read
+// from, or empty when the token was requested using the TokenRequest API.
+func startWatchers(ctx context.Context, tokenSecret string, changes chan<- string) error {
+	notify := func(what string) {
+		select {
+		case changes <- what:
+		default:
+		}
+	}
+
+	if inCluster() {
+		opts, err := inclusterOptions()
+		if err != nil {
+			return err
+		}
+		tokenPath, caPath, err := incluster.TokenPaths(opts)
+		if err != nil {
+			return err
+		}
+		watchFile(ctx, incluster.InRoot(*root, caPath), "the mounted ca.crt", notify)
+
+		// The kubelet rotates the projected tokens long before they
+		// expire, which means the embedded token would otherwise stop
+		// working after an hour or so.
+		watchFile(ctx, incluster.InRoot(*root, tokenPath), "the mounted token", notify)
+	}
+	if *tokenFile != "" && *tokenFile != "-" {
+		watchFile(ctx, *tokenFile, "the token file", notify)
+	}
+
+	c, err := apiConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+	ns := contextNamespace()
+	if ns == "" {
+		ns = "default"
+	}
+	if err := watchRootCAConfigMap(ctx, cl, ns, notify); err != nil {
+		return err
+	}
+
+	switch {
+	case tokenSecret != "":
+		splits := strings.Split(tokenSecret, "/")
+		watchTokenSecret(ctx, cl, splits[0], splits[1], notify)
+	case len(*serviceaccount) > 0:
+		logutil.Debugf("not watching the token of --serviceaccount since it was requested using the TokenRequest API rather than read from a Secret")
+	}
+	return nil
+}
+
+// watchFile polls the file every --watch-interval. The content is compared
+// rather than the modification time since the kubelet updates the mounted
+// files by swapping a symlink.
+func watchFile(ctx context.Context, path, what string, notify func(string)) {
+	last, err := ioutil.ReadFile(path)
+	if err != nil {
+		logutil.Warnf("not watching %s: %s", what, err)
+		return
+	}
+	logutil.Debugf("watching %s (%s) every %s", what, path, *watchInterval)
+
+	go wait.Until(func() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			logutil.Debugf("while reading %s: %s", what, err)
+			return
+		}
+		if !bytes.Equal(data, last) {
+			last = data
+			notify(what)
+		}
+	}, *watchInterval, ctx.Done())
+}
+
+// watchRootCAConfigMap watches the kube-root-ca.crt ConfigMap of the given
+// namespace with an informer. When its CA isn't the one of the source kube
+// config, regenerating won't help, which is why its fingerprints are logged
+// so that you can compare them.
+func watchRootCAConfigMap(ctx context.Context, cl kubernetes.Interface, ns string, notify func(string)) error {
+	_, err := cl.CoreV1().ConfigMaps(ns).Get(ctx, rootCAConfigMap, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err) || k8serrors.IsForbidden(err):
+		logutil.Warnf("not watching the ConfigMap %s in namespace %s: %s", rootCAConfigMap, ns, err)
+		return nil
+	case err != nil:
+		return fmt.Errorf("getting configmap %s in namespace %s: %w", rootCAConfigMap, ns, err)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(cl, 0,
+		informers.WithNamespace(ns),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", rootCAConfigMap).String()
+		}),
+	)
+	what := "the ConfigMap " + ns + "/" + rootCAConfigMap
+	factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldCM, newCM := oldObj.(*v1.ConfigMap), newObj.(*v1.ConfigMap)
+			if oldCM.Data["ca.crt"] == newCM.Data["ca.crt"] {
+				return
+			}
+			logutil.Infof("the CA fingerprints of %s were %s and are now %s", what, pemFingerprints([]byte(oldCM.Data["ca.crt"])), pemFingerprints([]byte(newCM.Data["ca.crt"])))
+			notify(what)
+		},
+	})
+	factory.Start(ctx.Done())
+	logutil.Debugf("watching %s", what)
+
+	return nil
+}
+
+// watchTokenSecret watches the Secret the token was read from with an
+// informer. The kube config is regenerated when its token or ca.crt changes,
+// e.g. after the Secret is deleted and re-created to reset the token.
+func watchTokenSecret(ctx context.Context, cl kubernetes.Interface, ns, name string, notify func(string)) {
+	factory := informers.NewSharedInformerFactoryWithOptions(cl, 0,
+		informers.WithNamespace(ns),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+	what := "the Secret " + ns + "/" + name
+	factory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSecret, newSecret := oldObj.(*v1.Secret), newObj.(*v1.Secret)
+			if bytes.Equal(oldSecret.Data["token"], newSecret.Data["token"]) && bytes.Equal(oldSecret.Data["ca.crt"], newSecret.Data["ca.crt"]) {
+				return
+			}
+			notify(what)
+		},
+		DeleteFunc: func(interface{}) {
+			logutil.Warnf("%s was deleted, its token is no longer valid", what)
+			notify(what)
+		},
+	})
+	factory.Start(ctx.Done())
+	logutil.Debugf("watching %s", what)
+}
+
+// caFingerprints returns the SHA-256 fingerprints of the CA certificates of
+// the kube config's current cluster, separated by commas.
+func caFingerprints(kubeconfig *clientcmdapi.Config) string {
+	cluster, _, err := currentClusterAndUser(kubeconfig)
+	if err != nil {
+		return "<none>"
+	}
+	ca, err := dataOrFile(cluster.CertificateAuthorityData, cluster.CertificateAuthority)
+	if err != nil {
+		logutil.Debugf("while reading the CA: %s", err)
+		return "<none>"
+	}
+	return pemFingerprints(ca)
+}
+
+// pemFingerprints returns the SHA-256 fingerprints of the certificates of the
+// PEM bundle, separated by commas.
+func pemFingerprints(data []byte) string {
+	certs, err := parseCertsPEM(data)
+	if err != nil {
+		return "<none>"
+	}
+	var fingerprints []string
+	for _, cert := range certs {
+		fingerprints = append(fingerprints, fingerprint(cert.Raw))
+	}
+	return strings.Join(fingerprints, ", ")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/maelvls/kubectl-incluster/logutil"
+	"github.com/maelvls/kubectl-incluster/pkg/incluster"
+)
+
+// whoami prints the identity the credentials of the given kube config map to.
+// It first tries a SelfSubjectReview, then a TokenReview (which requires the
+// permission to create TokenReviews), and finally falls back to decoding the
+// token (JWT) or the client certificate locally.
+func whoami(ctx context.Context, kubeconfig *clientcmdapi.Config, out io.Writer) error {
+	c, err := restConfigFromKubeconfig(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("loading the generated kube config: %w", err)
+	}
+
+	user, source, err := identity(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "username: %s\n", user.Username)
+	if len(user.Groups) > 0 {
+		fmt.Fprintf(out, "groups:   %s\n", strings.Join(user.Groups, ", "))
+	}
+	if ns, name, ok := serviceAccountFromUsername(user.Username); ok {
+		fmt.Fprintf(out, "serviceaccount: %s/%s\n", ns, name)
+	}
+	fmt.Fprintf(out, "source:   %s\n", source)
+	return nil
+}
+
+// identity returns the user the given credentials map to and how it was
+// found out.
+func identity(ctx context.Context, c *rest.Config) (_ authenticationv1.UserInfo, source string, _ error) {
+	cl, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return authenticationv1.UserInfo{}, "", fmt.Errorf("creating Kubernetes client: %w", err)
+	}
+
+	user, err := selfSubjectReview(ctx, cl)
+	if err == nil {
+		return user, "SelfSubjectReview", nil
+	}
+	logutil.Debugf("falling back to TokenReview: %s", err)
+
+	if c.BearerToken != "" {
+		review, err := cl.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: c.BearerToken},
+		}, metav1.CreateOptions{})
+		switch {
+		case err != nil:
+			logutil.Debugf("falling back to decoding the token: %s", err)
+		case !review.Status.Authenticated:
+			return authenticationv1.UserInfo{}, "", fmt.Errorf("the token isn't valid: %s", review.Status.Error)
+		default:
+			return review.Status.User, "TokenReview", nil
+		}
+
+		claims, err := decodeJWT(c.BearerToken)
+		if err != nil {
+			return authenticationv1.UserInfo{}, "", fmt.Errorf("the token isn't a JWT and neither SelfSubjectReview nor TokenReview could be used: %w", err)
+		}
+		username := claims.Subject
+		if username == "" && claims.LegacyName != "" {
+			username = "system:serviceaccount:" + claims.LegacyNamespace + ":" + claims.LegacyName
+		}
+		return authenticationv1.UserInfo{Username: username}, "token (JWT, not validated)", nil
+	}
+
+	if len(c.TLSClientConfig.CertData) > 0 {
+		block, _ := pem.Decode(c.TLSClientConfig.CertData)
+		if block == nil {
+			return authenticationv1.UserInfo{}, "", fmt.Errorf("the client certificate isn't PEM-encoded")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return authenticationv1.UserInfo{}, "", fmt.Errorf("parsing the client certificate: %w", err)
+		}
+		return authenticationv1.UserInfo{Username: cert.Subject.CommonName, Groups: cert.Subject.Organization}, "client certificate (not validated)", nil
+	}
+
+	return authenticationv1.UserInfo{}, "", fmt.Errorf("SelfSubjectReview failed and there is no token nor client certificate to look at: %w", err)
+}
+
+// jwtClaims are the claims found in service account tokens. Legacy tokens
+// (the ones stored in Secrets) use the "kubernetes.io/serviceaccount/*"
+// claims, while bound tokens use the "kubernetes.io" claim.
+type jwtClaims struct {
+	Subject    string `json:"sub"`
+	Issuer     string `json:"iss"`
+	Expiry     int64  `json:"exp"`
+	Kubernetes struct {
+		Namespace      string `json:"namespace"`
+		ServiceAccount struct {
+			Name string `json:"name"`
+			UID  string `json:"uid"`
+		} `json:"serviceaccount"`
+	} `json:"kubernetes.io"`
+	LegacyNamespace string `json:"kubernetes.io/serviceaccount/namespace"`
+	LegacyName      string `json:"kubernetes.io/serviceaccount/service-account.name"`
+}
+
+// decodeJWT decodes the payload of the given JWT without verifying its
+// signature.
+func decodeJWT(token string) (jwtClaims, error) {
+	var claims jwtClaims
+	if err := incluster.DecodeJWT(token, &claims); err != nil {
+		return jwtClaims{}, err
+	}
+	return claims, nil
+}
+
+// serviceAccountFromUsername returns the namespace and name of the service
+// account when the username is of the form
+// 'system:serviceaccount:<namespace>:<name>'.
+func serviceAccountFromUsername(username string) (namespace, name string, ok bool) {
+	parts := strings.Split(username, ":")
+	if len(parts) != 4 || parts[0] != "system" || parts[1] != "serviceaccount" {
+		return "", "", false
+	}
+	return parts[2], parts[3], true
+}